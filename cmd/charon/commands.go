@@ -0,0 +1,212 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/lukas-pastva/web-charon/internal/config"
+	"github.com/lukas-pastva/web-charon/internal/handlers"
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+)
+
+const commandUsage = `usage:
+  charon                           start the web server
+  charon storage gc [flags]        delete uploaded files no longer referenced by the database
+  charon storage migrate [flags]   copy uploaded files from one storage backend to another
+  charon storage rehash [flags]    move files uploaded before content addressing to hash-based names`
+
+func runCommand(cfg *config.Config, db *sql.DB, args []string) error {
+	if len(args) >= 2 && args[0] == "storage" {
+		switch args[1] {
+		case "gc":
+			return runStorageGC(cfg, db, args[2:])
+		case "migrate":
+			return runStorageMigrate(cfg, args[2:])
+		case "rehash":
+			return runStorageRehash(cfg, db, args[2:])
+		}
+	}
+	return errors.New("unknown command\n" + commandUsage)
+}
+
+func runStorageGC(cfg *config.Config, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("storage gc", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only list orphaned files, do not delete them")
+	grace := fs.Duration("grace", storage.DefaultGracePeriod, "skip files modified more recently than this")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := openStorage(cfg, cfg.StorageBackend)
+	if err != nil {
+		return err
+	}
+
+	articles := &models.ArticleStore{DB: db}
+	galleries := &models.GalleryStore{DB: db}
+	referenced, err := handlers.ReferencedUploads(articles, galleries)
+	if err != nil {
+		return err
+	}
+
+	report, err := storage.FindOrphans(store, referenced, *grace)
+	if err != nil {
+		return err
+	}
+
+	for _, o := range report.Orphans {
+		fmt.Printf("%s\t%d\t%s\n", o.Name, o.Size, o.ModTime.Format("2006-01-02 15:04:05"))
+	}
+	log.Printf("storage gc: %d orphaned files (%d bytes), %d within the %s grace period",
+		len(report.Orphans), report.OrphanBytes, len(report.Recent), *grace)
+
+	if *dryRun {
+		return nil
+	}
+	removed, err := storage.RemoveOrphans(store, report)
+	media := &models.MediaStore{DB: db}
+	for _, name := range removed {
+		if err := media.DeleteByFilename(name); err != nil {
+			log.Printf("storage gc: forget media %s: %v", name, err)
+		}
+	}
+	log.Printf("storage gc: removed %d files", len(removed))
+	return err
+}
+
+func runStorageMigrate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("storage migrate", flag.ContinueOnError)
+	from := fs.String("from", "local", "source backend (local or s3)")
+	to := fs.String("to", "s3", "destination backend (local or s3)")
+	dryRun := fs.Bool("dry-run", false, "only list files that would be copied")
+	overwrite := fs.Bool("overwrite", false, "copy files that already exist in the destination")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == *to {
+		return fmt.Errorf("source and destination backend are both %q", *from)
+	}
+
+	src, err := openStorage(cfg, *from)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	dst, err := openStorage(cfg, *to)
+	if err != nil {
+		return fmt.Errorf("open destination: %w", err)
+	}
+
+	objects, err := src.List()
+	if err != nil {
+		return err
+	}
+
+	copied, skipped, failed := 0, 0, 0
+	for _, obj := range objects {
+		if !*overwrite {
+			if _, err := dst.Stat(obj.Key); err == nil {
+				skipped++
+				continue
+			}
+		}
+		if *dryRun {
+			fmt.Printf("%s\t%d\n", obj.Key, obj.Size)
+			copied++
+			continue
+		}
+		if err := copyObject(src, dst, obj.Key); err != nil {
+			log.Printf("storage migrate: %s: %v", obj.Key, err)
+			failed++
+			continue
+		}
+		copied++
+	}
+
+	log.Printf("storage migrate: %s -> %s: %d copied, %d already present, %d failed", *from, *to, copied, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be copied", failed)
+	}
+	return nil
+}
+
+func copyObject(src, dst storage.Storage, key string) error {
+	rc, info, err := src.Get(key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return dst.Put(key, rc, info.Size, info.ContentType)
+}
+
+// runStorageRehash renames legacy uploads (timestamp-based names) to their
+// content hash, merging identical files and rebuilding media reference counts.
+func runStorageRehash(cfg *config.Config, db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("storage rehash", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "only list files that would be renamed")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := openStorage(cfg, cfg.StorageBackend)
+	if err != nil {
+		return err
+	}
+
+	articles := &models.ArticleStore{DB: db}
+	galleries := &models.GalleryStore{DB: db}
+	media := &models.MediaStore{DB: db}
+	referenced, err := handlers.ReferencedUploads(articles, galleries)
+	if err != nil {
+		return err
+	}
+
+	renamed, failed := 0, 0
+	for name := range referenced {
+		if handlers.IsContentAddressed(name) {
+			continue
+		}
+		if *dryRun {
+			fmt.Println(name)
+			renamed++
+			continue
+		}
+		m, _, err := handlers.StoreUpload(store, media, name, func() (io.ReadCloser, error) {
+			rc, _, err := store.Get(name)
+			return rc, err
+		})
+		if err != nil {
+			log.Printf("storage rehash: %s: %v", name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s\t%s\n", name, m.Filename)
+		if err := galleries.RenameImageFile(name, m.Filename); err != nil {
+			return err
+		}
+		if err := articles.RenameCoverImage(name, m.Filename); err != nil {
+			return err
+		}
+		if err := store.Delete(name); err != nil {
+			log.Printf("storage rehash: remove %s: %v", name, err)
+		}
+		renamed++
+	}
+
+	if *dryRun {
+		log.Printf("storage rehash: %d files would be renamed", renamed)
+		return nil
+	}
+	if err := media.RecountReferences(); err != nil {
+		return fmt.Errorf("recount media references: %w", err)
+	}
+	log.Printf("storage rehash: %d files renamed, %d failed", renamed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be rehashed", failed)
+	}
+	return nil
+}
@@ -4,19 +4,27 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"fmt"
-	"html/template"
 	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	charon "github.com/lukas-pastva/web-charon"
 	"github.com/lukas-pastva/web-charon/internal/config"
 	"github.com/lukas-pastva/web-charon/internal/database"
+	"github.com/lukas-pastva/web-charon/internal/events"
 	"github.com/lukas-pastva/web-charon/internal/handlers"
+	"github.com/lukas-pastva/web-charon/internal/i18n"
+	"github.com/lukas-pastva/web-charon/internal/mail"
 	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/pagecache"
 	"github.com/lukas-pastva/web-charon/internal/router"
+	"github.com/lukas-pastva/web-charon/internal/security"
+	"github.com/lukas-pastva/web-charon/internal/webhook"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -35,9 +43,18 @@ func main() {
 		log.Fatalf("database migration failed: %v", err)
 	}
 
-	// Ensure storage directory exists
-	if err := os.MkdirAll(cfg.StoragePath, 0755); err != nil {
-		log.Fatalf("failed to create storage directory: %v", err)
+	// Maintenance subcommands (e.g. "charon storage gc") run instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(cfg, db, os.Args[1:]); err != nil {
+			log.Fatalf("%s: %v", strings.Join(os.Args[1:], " "), err)
+		}
+		return
+	}
+
+	// Open upload storage
+	store, err := openStorage(cfg, cfg.StorageBackend)
+	if err != nil {
+		log.Fatalf("failed to open %s storage: %v", cfg.StorageBackend, err)
 	}
 
 	// Generate session secret
@@ -46,83 +63,28 @@ func main() {
 		log.Fatalf("failed to generate session secret: %v", err)
 	}
 
-	// Parse templates
-	funcMap := template.FuncMap{
-		"nl2br": func(s string) template.HTML {
-			return template.HTML(strings.ReplaceAll(template.HTMLEscapeString(s), "\n", "<br>"))
-		},
-		"deref": func(p *int64) int64 {
-			if p == nil {
-				return 0
-			}
-			return *p
-		},
-	}
-
-	// Parse public templates — each page gets its own template set cloned from
-	// the base so that block definitions (title, content) don't collide.
-	publicBaseTmpl, err := template.New("").Funcs(funcMap).ParseFS(charon.PublicTemplatesFS, "templates/public/base.html")
-	if err != nil {
-		log.Fatalf("failed to parse public base template: %v", err)
-	}
-
-	publicPages := []string{
-		"home.html", "article.html", "articles.html",
-		"gallery.html", "gallery_detail.html",
-	}
-
-	publicTmpl := make(map[string]*template.Template)
-	for _, page := range publicPages {
-		clone, err := publicBaseTmpl.Clone()
-		if err != nil {
-			log.Fatalf("failed to clone public base template: %v", err)
-		}
-		t, err := clone.ParseFS(charon.PublicTemplatesFS, "templates/public/"+page)
-		if err != nil {
-			log.Fatalf("failed to parse public template %s: %v", page, err)
-		}
-		publicTmpl[page] = t
-	}
-
-	// Parse admin templates — each page gets its own template set cloned from
-	// the base so that block definitions (title, content) don't collide.
-	baseTmpl, err := template.New("").Funcs(funcMap).ParseFS(charon.AdminTemplatesFS, "templates/admin/base.html")
+	// Parse templates, once per language of the message catalogs
+	bundle, err := i18n.Load(charon.LocalesFS, "locales", "sk")
 	if err != nil {
-		log.Fatalf("failed to parse admin base template: %v", err)
-	}
-
-	adminPages := []string{
-		"dashboard.html", "articles.html", "article_form.html",
-		"galleries.html", "gallery_form.html", "comments.html",
-		"settings.html", "users.html", "user_form.html", "profile.html",
+		log.Fatalf("failed to load message catalogs: %v", err)
 	}
-
-	adminTmpl := make(map[string]*template.Template)
-	for _, page := range adminPages {
-		clone, err := baseTmpl.Clone()
-		if err != nil {
-			log.Fatalf("failed to clone base template: %v", err)
-		}
-		t, err := clone.ParseFS(charon.AdminTemplatesFS, "templates/admin/"+page)
-		if err != nil {
-			log.Fatalf("failed to parse admin template %s: %v", page, err)
-		}
-		adminTmpl[page] = t
-	}
-
-	// Login template is self-contained (no base layout)
-	loginTmpl, err := template.New("").Funcs(funcMap).ParseFS(charon.AdminTemplatesFS, "templates/admin/login.html")
+	publicTmpl, adminTmpl, err := parseTemplates(bundle)
 	if err != nil {
-		log.Fatalf("failed to parse login template: %v", err)
+		log.Fatalf("failed to parse templates: %v", err)
 	}
-	adminTmpl["login.html"] = loginTmpl
 
 	// Initialize stores
 	articleStore := &models.ArticleStore{DB: db}
 	galleryStore := &models.GalleryStore{DB: db}
 	commentStore := &models.CommentStore{DB: db}
+	banStore := &models.CommentBanStore{DB: db}
 	settingsStore := &models.SettingsStore{DB: db}
 	userStore := &models.UserStore{DB: db}
+	mediaStore := &models.MediaStore{DB: db}
+	outboxStore := &models.OutboxStore{DB: db}
+	tokenStore := &models.UserTokenStore{DB: db}
+	goneStore := &models.GoneStore{DB: db}
+	apiTokenStore := &models.APITokenStore{DB: db}
 
 	// Seed initial admin user if no users exist
 	count, err := userStore.Count()
@@ -151,53 +113,191 @@ func main() {
 		log.Println("created initial admin user (nickname: admin)")
 	}
 
+	if err := handlers.ImportLegacyBlocklist(settingsStore, banStore); err != nil {
+		log.Printf("warning: could not import comment blocklist: %v", err)
+	}
+	visitorSalt, err := handlers.VisitorSalt(settingsStore)
+	if err != nil {
+		log.Fatalf("failed to load visitor hash salt: %v", err)
+	}
+
 	// Compute cache-bust version from embedded static files
-	cssData, _ := fs.ReadFile(charon.StaticFS, "static/css/style.css")
-	jsData, _ := fs.ReadFile(charon.StaticFS, "static/js/app.js")
 	h := sha256.New()
-	h.Write(cssData)
-	h.Write(jsData)
+	for _, name := range []string{"static/css/style.css", "static/css/admin.css", "static/css/login.css", "static/js/app.js", "static/js/admin.js"} {
+		data, _ := fs.ReadFile(charon.StaticFS, name)
+		h.Write(data)
+	}
 	version := fmt.Sprintf("%x", h.Sum(nil))[:8]
 
 	// Initialize handlers
 	baseURL := "https://" + cfg.PublicDomain
-	publicHandler := &handlers.PublicHandler{
+
+	// Set up outgoing mail
+	mailRenderer, err := mail.NewRenderer(charon.MailTemplatesFS, "templates/mail", bundle)
+	if err != nil {
+		log.Fatalf("failed to parse mail templates: %v", err)
+	}
+	var mailSender mail.Sender
+	if cfg.SMTPHost != "" {
+		port, err := strconv.Atoi(cfg.SMTPPort)
+		if err != nil {
+			log.Fatalf("invalid SMTP_PORT %q", cfg.SMTPPort)
+		}
+		mailSender = &mail.SMTPSender{
+			Host:        cfg.SMTPHost,
+			Port:        port,
+			Username:    cfg.SMTPUsername,
+			Password:    cfg.SMTPPassword,
+			ImplicitTLS: cfg.SMTPTLS,
+		}
+	}
+	mailFrom := cfg.MailFrom
+	if mailFrom == "" {
+		mailFrom = "Charon <noreply@" + cfg.PublicDomain + ">"
+	}
+	mailer := mail.NewMailer(outboxStore, mailRenderer, mailSender, mailFrom, baseURL)
+	go mailer.Run()
+
+	// Set up outgoing webhooks
+	webhooks := webhook.NewDispatcher(&models.WebhookStore{DB: db}, &models.WebhookDeliveryStore{DB: db}, store, baseURL)
+	go webhooks.Run()
+
+	// Cache rendered public pages. Expired pages are served for up to an
+	// hour longer while a fresh copy renders in the background.
+	cacheMB, err := strconv.Atoi(cfg.PageCacheMB)
+	if err != nil || cacheMB < 0 {
+		log.Fatalf("invalid PAGE_CACHE_MB %q", cfg.PageCacheMB)
+	}
+	cacheTTL, err := time.ParseDuration(cfg.PageCacheTTL)
+	if err != nil || cacheTTL <= 0 {
+		log.Fatalf("invalid PAGE_CACHE_TTL %q", cfg.PageCacheTTL)
+	}
+	pageCache := pagecache.New(int64(cacheMB)<<20, cacheTTL, time.Hour)
+	spamGuard := handlers.NewSpamGuard(sessionSecret)
+	pageCache.Hole("comment-token", func(_ *http.Request, target string) string { return spamGuard.Token(target) })
+	pageCache.Hole("csp-nonce", func(r *http.Request, _ string) string { return security.Nonce(r) })
+
+	locales := handlers.NewLocales(bundle, settingsStore)
+	mailer.SiteLanguage = locales.Site
+
+	// Side effects of content changes subscribe to the event bus
+	bus := events.New()
+	// The site language is reloaded before the page cache is emptied, so
+	// no page in the old language is cached again.
+	locales.Subscribe(bus)
+	pageCache.Subscribe(bus, articleStore, galleryStore)
+	mailer.Subscribe(bus, userStore)
+	webhooks.Subscribe(bus)
+
+	errorPages := &handlers.ErrorPages{
+		Public:    publicTmpl,
+		Admin:     adminTmpl,
 		Articles:  articleStore,
 		Galleries: galleryStore,
-		Comments:  commentStore,
-		Templates: publicTmpl,
+		GonePaths: goneStore,
 		BaseURL:   baseURL,
 		Version:   version,
 	}
 
-	adminHandler := &handlers.AdminHandler{
+	publicHandler := &handlers.PublicHandler{
 		Articles:    articleStore,
 		Galleries:   galleryStore,
 		Comments:    commentStore,
+		Bans:        banStore,
 		Settings:    settingsStore,
-		Users:       userStore,
-		Templates:   adminTmpl,
-		StoragePath: cfg.StoragePath,
+		Media:       mediaStore,
+		Spam:        spamGuard,
+		VisitorSalt: visitorSalt,
+		Storage:     store,
+		Events:      bus,
+		Cache:       pageCache,
+		Templates:   publicTmpl,
+		Errors:      errorPages,
+		BaseURL:     baseURL,
+		Version:     version,
+	}
+
+	adminHandler := &handlers.AdminHandler{
+		Articles:  articleStore,
+		Galleries: galleryStore,
+		Comments:  commentStore,
+		Bans:      banStore,
+		Settings:  settingsStore,
+		Users:     userStore,
+		Media:     mediaStore,
+		Templates: adminTmpl,
+		Storage:   store,
+		Mailer:    mailer,
+		Webhooks:  webhooks,
+		Events:    bus,
+		Cache:     pageCache,
+		Tokens:    tokenStore,
+		APITokens: apiTokenStore,
+		GonePaths: goneStore,
+		Errors:    errorPages,
+		Locales:   locales,
+		Version:   version,
+	}
+
+	apiHandler := &handlers.APIHandler{
+		Articles:    articleStore,
+		Galleries:   galleryStore,
+		Comments:    commentStore,
+		Storage:     store,
+		BaseURL:     baseURL,
+		CORSOrigins: cfg.APICORSOrigins,
+	}
+
+	adminAPIHandler := &handlers.AdminAPIHandler{
+		Admin:  adminHandler,
+		Public: apiHandler,
+		Tokens: apiTokenStore,
+	}
+
+	uploadsHandler := &handlers.UploadsHandler{
+		Storage: store,
+		Media:   mediaStore,
 	}
 
 	authHandler := &handlers.AuthHandler{
 		Users:         userStore,
+		Tokens:        tokenStore,
+		Mailer:        mailer,
 		Templates:     adminTmpl,
 		SessionSecret: sessionSecret,
+		Errors:        errorPages,
+		Version:       version,
 	}
+	pageCache.Bypass = authHandler.SignedIn
+	uploadsHandler.CanViewPrivate = authHandler.SignedIn
 
 	// Static file system
 	staticSub, err := fs.Sub(charon.StaticFS, "static")
 	if err != nil {
 		log.Fatalf("failed to create static sub filesystem: %v", err)
 	}
+	staticHandler, err := handlers.NewStaticHandler(staticSub, version)
+	if err != nil {
+		log.Fatalf("failed to read static files: %v", err)
+	}
+
+	// Security headers; uploads may be served straight from a public bucket
+	imageOrigin := ""
+	if u, err := url.Parse(cfg.S3PublicURL); err == nil && u.Host != "" {
+		imageOrigin = u.Scheme + "://" + u.Host
+	}
+	policies := router.Policies{
+		Public: security.Public(imageOrigin),
+		Admin:  security.Admin(imageOrigin),
+		API:    security.API(),
+	}
 
 	// Create router
-	handler := router.New(publicHandler, adminHandler, authHandler, cfg.StoragePath, http.FS(staticSub))
+	handler := router.New(publicHandler, adminHandler, authHandler, apiHandler, adminAPIHandler, uploadsHandler, errorPages, locales, pageCache, staticHandler, policies, cfg.TrustProxy)
 
 	// Start server
 	addr := ":" + cfg.Port
-	log.Printf("Charon starting on %s (public: %s)", addr, cfg.PublicDomain)
+	log.Printf("Charon starting on %s (public: %s, storage: %s)", addr, cfg.PublicDomain, cfg.StorageBackend)
 	if err := http.ListenAndServe(addr, handler); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lukas-pastva/web-charon/internal/config"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+)
+
+// uploadsURL is the path prefix under which the application serves uploads.
+const uploadsURL = "/uploads/"
+
+// openStorage creates the storage backend with the given name ("local" or "s3").
+func openStorage(cfg *config.Config, backend string) (storage.Storage, error) {
+	switch backend {
+	case "local":
+		return storage.NewLocal(cfg.StoragePath, uploadsURL)
+	case "s3":
+		s, err := storage.NewS3(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3PathStyle)
+		if err != nil {
+			return nil, err
+		}
+		s.PublicURL = cfg.S3PublicURL
+		s.BaseURL = uploadsURL
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown storage backend %q (expected \"local\" or \"s3\")", backend)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	charon "github.com/lukas-pastva/web-charon"
+	"github.com/lukas-pastva/web-charon/internal/handlers"
+	"github.com/lukas-pastva/web-charon/internal/i18n"
+)
+
+var publicPages = []string{
+	"home.html", "article.html", "articles.html",
+	"gallery.html", "gallery_detail.html", "photo.html", "error.html",
+}
+
+var adminPages = []string{
+	"dashboard.html", "articles.html", "article_form.html",
+	"galleries.html", "gallery_form.html", "comments.html", "comment_form.html",
+	"settings.html", "users.html", "user_form.html", "profile.html",
+	"storage.html", "mail.html", "bans.html", "error.html",
+	"webhooks.html", "webhook_form.html",
+}
+
+// parseTemplates parses the public and admin pages once for every language
+// of bundle, with T and lang bound to that language.
+func parseTemplates(bundle *i18n.Bundle) (public, admin *handlers.Templates, err error) {
+	public = &handlers.Templates{Sets: make(map[string]map[string]*template.Template), Fallback: bundle.Fallback()}
+	admin = &handlers.Templates{Sets: make(map[string]map[string]*template.Template), Fallback: bundle.Fallback()}
+	for _, lang := range bundle.Languages() {
+		funcMap := templateFuncs(bundle.Localizer(lang.Code))
+		if public.Sets[lang.Code], err = parsePublic(funcMap); err != nil {
+			return nil, nil, err
+		}
+		if admin.Sets[lang.Code], err = parseAdmin(funcMap); err != nil {
+			return nil, nil, err
+		}
+	}
+	return public, admin, nil
+}
+
+func templateFuncs(loc *i18n.Localizer) template.FuncMap {
+	return template.FuncMap{
+		"T":    loc.T,
+		"lang": loc.Lang,
+		// Thtml is T for messages with markup; the catalogs are trusted,
+		// the arguments are escaped.
+		"Thtml": func(key string, args ...interface{}) template.HTML {
+			for i := 1; i < len(args); i += 2 {
+				args[i] = template.HTMLEscapeString(fmt.Sprint(args[i]))
+			}
+			return template.HTML(loc.T(key, args...))
+		},
+		"nl2br": func(s string) template.HTML {
+			return template.HTML(strings.ReplaceAll(template.HTMLEscapeString(s), "\n", "<br>"))
+		},
+		"deref": func(p *int64) int64 {
+			if p == nil {
+				return 0
+			}
+			return *p
+		},
+		"filesize": func(n int64) string {
+			switch {
+			case n >= 1<<20:
+				return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+			case n >= 1<<10:
+				return fmt.Sprintf("%.1f kB", float64(n)/(1<<10))
+			}
+			return fmt.Sprintf("%d B", n)
+		},
+		// dict builds a map from key/value pairs so recursive templates can
+		// receive more than one value.
+		"dict": func(pairs ...interface{}) (map[string]interface{}, error) {
+			if len(pairs)%2 != 0 {
+				return nil, fmt.Errorf("dict: odd number of arguments")
+			}
+			m := make(map[string]interface{}, len(pairs)/2)
+			for i := 0; i < len(pairs); i += 2 {
+				key, ok := pairs[i].(string)
+				if !ok {
+					return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+				}
+				m[key] = pairs[i+1]
+			}
+			return m, nil
+		},
+	}
+}
+
+// parsePublic parses the public pages. Each page gets its own template set
+// cloned from the base so that block definitions (title, content) don't
+// collide.
+func parsePublic(funcMap template.FuncMap) (map[string]*template.Template, error) {
+	base, err := template.New("").Funcs(funcMap).ParseFS(charon.PublicTemplatesFS, "templates/public/base.html", "templates/public/comments.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse public base template: %w", err)
+	}
+	pages := make(map[string]*template.Template)
+	for _, page := range publicPages {
+		clone, err := base.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("clone public base template: %w", err)
+		}
+		if pages[page], err = clone.ParseFS(charon.PublicTemplatesFS, "templates/public/"+page); err != nil {
+			return nil, fmt.Errorf("parse public template %s: %w", page, err)
+		}
+	}
+	return pages, nil
+}
+
+// parseAdmin parses the admin pages the same way, plus the self-contained
+// login page.
+func parseAdmin(funcMap template.FuncMap) (map[string]*template.Template, error) {
+	base, err := template.New("").Funcs(funcMap).ParseFS(charon.AdminTemplatesFS, "templates/admin/base.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse admin base template: %w", err)
+	}
+	pages := make(map[string]*template.Template)
+	for _, page := range adminPages {
+		clone, err := base.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("clone admin base template: %w", err)
+		}
+		if pages[page], err = clone.ParseFS(charon.AdminTemplatesFS, "templates/admin/"+page); err != nil {
+			return nil, fmt.Errorf("parse admin template %s: %w", page, err)
+		}
+	}
+
+	// Login template is self-contained (no base layout)
+	if pages["login.html"], err = template.New("").Funcs(funcMap).ParseFS(charon.AdminTemplatesFS, "templates/admin/login.html"); err != nil {
+		return nil, fmt.Errorf("parse login template: %w", err)
+	}
+	return pages, nil
+}
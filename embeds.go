@@ -8,8 +8,14 @@ var PublicTemplatesFS embed.FS
 //go:embed templates/admin/*.html
 var AdminTemplatesFS embed.FS
 
+//go:embed templates/mail/*
+var MailTemplatesFS embed.FS
+
 //go:embed static
 var StaticFS embed.FS
 
 //go:embed migrations/*.sql
 var MigrationsFS embed.FS
+
+//go:embed locales/*.json
+var LocalesFS embed.FS
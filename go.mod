@@ -3,6 +3,7 @@ module github.com/lukas-pastva/web-charon
 go 1.24.0
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/go-chi/chi/v5 v5.1.0
 	github.com/go-sql-driver/mysql v1.8.1
 	golang.org/x/crypto v0.48.0
@@ -0,0 +1,231 @@
+// Package compress negotiates and applies gzip or brotli compression to
+// text responses. Images, archives and other already compressed formats
+// are sent as they are.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings in order of preference.
+const (
+	Brotli = "br"
+	Gzip   = "gzip"
+)
+
+// minSize is the smallest body worth compressing when its length is known
+// up front.
+const minSize = 1024
+
+// Negotiate returns the preferred coding the request accepts, or "" for
+// none.
+func Negotiate(r *http.Request) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if name != "" {
+			accepted[name] = q > 0
+		}
+	}
+	for _, coding := range []string{Brotli, Gzip} {
+		if ok, listed := accepted[coding]; ok || (!listed && accepted["*"]) {
+			return coding
+		}
+	}
+	return ""
+}
+
+// AddVary marks a response as depending on Accept-Encoding, once.
+func AddVary(h http.Header) {
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// Compressible reports whether responses of contentType benefit from
+// compression.
+func Compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/feed+json", "application/javascript",
+		"application/xml", "application/rss+xml", "application/atom+xml",
+		"image/svg+xml", "application/manifest+json":
+		return true
+	}
+	return false
+}
+
+// Bytes compresses data with coding at the highest level, for content
+// compressed once and served many times.
+func Bytes(coding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch coding {
+	case Brotli:
+		w = brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	case Gzip:
+		w, _ = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	default:
+		return data, nil
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Dynamic responses are compressed at moderate levels; pooled writers keep
+// the allocations down.
+var (
+	gzipPool = sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}}
+	brotliPool = sync.Pool{New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 5)
+	}}
+)
+
+// Middleware compresses text responses of next for clients that accept it.
+// Handlers that set Content-Encoding themselves, e.g. for precompressed
+// files, are left alone.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &writer{ResponseWriter: w, coding: Negotiate(r)}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// writer decides whether to compress when the response starts, once the
+// handler has set its headers. Compressible responses vary by
+// Accept-Encoding even when this client gets them uncompressed.
+type writer struct {
+	http.ResponseWriter
+	coding      string
+	wroteHeader bool
+	enc         interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	}
+}
+
+func (w *writer) WriteHeader(status int) {
+	if w.wroteHeader || status < 200 {
+		// Informational responses precede the real one.
+		if !w.wroteHeader {
+			w.ResponseWriter.WriteHeader(status)
+		}
+		return
+	}
+	w.wroteHeader = true
+	if w.shouldCompress(status) {
+		h := w.Header()
+		h.Set("Content-Encoding", w.coding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// The compressed body is no longer byte-for-byte the one a strong
+		// validator names.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch w.coding {
+		case Brotli:
+			bw := brotliPool.Get().(*brotli.Writer)
+			bw.Reset(w.ResponseWriter)
+			w.enc = bw
+		case Gzip:
+			gw := gzipPool.Get().(*gzip.Writer)
+			gw.Reset(w.ResponseWriter)
+			w.enc = gw
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *writer) shouldCompress(status int) bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || !Compressible(h.Get("Content-Type")) {
+		return false
+	}
+	AddVary(h)
+	if w.coding == "" || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent || h.Get("Content-Range") != "" {
+		return false
+	}
+	n, err := strconv.Atoi(h.Get("Content-Length"))
+	return err != nil || n >= minSize
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what was compressed so far, for streamed responses.
+func (w *writer) Flush() {
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *writer) close() {
+	if w.enc == nil {
+		return
+	}
+	w.enc.Close()
+	w.enc.Reset(io.Discard)
+	switch enc := w.enc.(type) {
+	case *brotli.Writer:
+		brotliPool.Put(enc)
+	case *gzip.Writer:
+		gzipPool.Put(enc)
+	}
+}
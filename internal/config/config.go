@@ -2,31 +2,77 @@ package config
 
 import (
 	"os"
+	"strings"
 )
 
 type Config struct {
-	DBHost       string
-	DBPort       string
-	DBUser       string
-	DBPassword   string
-	DBName       string
-	StoragePath  string
-	PublicDomain  string
-	AdminPassword string
-	Port          string
+	DBHost         string
+	DBPort         string
+	DBUser         string
+	DBPassword     string
+	DBName         string
+	StorageBackend string
+	StoragePath    string
+	S3Endpoint     string
+	S3Region       string
+	S3Bucket       string
+	S3AccessKey    string
+	S3SecretKey    string
+	S3PathStyle    bool
+	S3PublicURL    string
+	PublicDomain   string
+	AdminPassword  string
+	Port           string
+	// TrustProxy takes the client address from X-Forwarded-For / X-Real-IP.
+	// Enable it only behind a reverse proxy that sets these headers.
+	TrustProxy bool
+	// SMTP settings for outgoing mail. Without SMTPHost mail is queued in
+	// the outbox but not delivered.
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTLS      bool
+	MailFrom     string
+	// APICORSOrigins lists origins allowed to call the public JSON API from
+	// a browser, comma-separated; "*" allows any.
+	APICORSOrigins []string
+	// PageCacheMB limits the memory used by cached public pages; 0 turns
+	// the cache off. PageCacheTTL is how long a page stays fresh, as a Go
+	// duration.
+	PageCacheMB  string
+	PageCacheTTL string
 }
 
 func Load() *Config {
 	return &Config{
-		DBHost:       getEnv("DB_HOST", "localhost"),
-		DBPort:       getEnv("DB_PORT", "3306"),
-		DBUser:       getEnv("DB_USER", "charon"),
-		DBPassword:   getEnv("DB_PASSWORD", ""),
-		DBName:       getEnv("DB_NAME", "charon"),
-		StoragePath:  getEnv("STORAGE_PATH", "/data/uploads"),
-		PublicDomain:  getEnv("PUBLIC_DOMAIN", "localhost"),
-		AdminPassword: getEnv("ADMIN_PASSWORD", ""),
-		Port:          getEnv("PORT", "8080"),
+		DBHost:         getEnv("DB_HOST", "localhost"),
+		DBPort:         getEnv("DB_PORT", "3306"),
+		DBUser:         getEnv("DB_USER", "charon"),
+		DBPassword:     getEnv("DB_PASSWORD", ""),
+		DBName:         getEnv("DB_NAME", "charon"),
+		StorageBackend: getEnv("STORAGE_BACKEND", "local"),
+		StoragePath:    getEnv("STORAGE_PATH", "/data/uploads"),
+		S3Endpoint:     getEnv("S3_ENDPOINT", ""),
+		S3Region:       getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:       getEnv("S3_BUCKET", ""),
+		S3AccessKey:    getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretKey:    getEnv("S3_SECRET_ACCESS_KEY", ""),
+		S3PathStyle:    getEnv("S3_PATH_STYLE", "true") == "true",
+		S3PublicURL:    getEnv("S3_PUBLIC_URL", ""),
+		PublicDomain:   getEnv("PUBLIC_DOMAIN", "localhost"),
+		AdminPassword:  getEnv("ADMIN_PASSWORD", ""),
+		Port:           getEnv("PORT", "8080"),
+		TrustProxy:     getEnv("TRUST_PROXY", "false") == "true",
+		SMTPHost:       getEnv("SMTP_HOST", ""),
+		SMTPPort:       getEnv("SMTP_PORT", "587"),
+		SMTPUsername:   getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:   getEnv("SMTP_PASSWORD", ""),
+		SMTPTLS:        getEnv("SMTP_TLS", "false") == "true",
+		MailFrom:       getEnv("MAIL_FROM", ""),
+		APICORSOrigins: splitList(getEnv("API_CORS_ORIGINS", "")),
+		PageCacheMB:    getEnv("PAGE_CACHE_MB", "64"),
+		PageCacheTTL:   getEnv("PAGE_CACHE_TTL", "5m"),
 	}
 }
 
@@ -40,3 +86,14 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// splitList parses a comma-separated setting, dropping empty entries.
+func splitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,147 @@
+// Package events is a small in-process event bus. Handlers publish what
+// happened to the content and subsystems such as webhooks or notifications
+// subscribe to it, so a new side effect does not have to be wired into
+// every handler.
+package events
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+// Event is anything published on the bus; Name identifies it in logs.
+type Event interface {
+	Name() string
+}
+
+// Mode says how a subscriber receives events.
+type Mode int
+
+const (
+	// Sync subscribers run inside Publish, in subscription order, before
+	// Publish returns.
+	Sync Mode = iota
+	// Async subscribers run on their own goroutine. Each one receives the
+	// events in the order they were published, one at a time.
+	Async
+)
+
+// asyncQueueSize is how many events an async subscriber may fall behind
+// before Publish waits for it.
+const asyncQueueSize = 256
+
+type subscriber struct {
+	name   string
+	handle func(Event) error
+	queue  chan Event // nil for sync subscribers
+}
+
+// Bus delivers published events to subscribers. A subscriber that fails or
+// panics is logged and does not affect the others or the publisher.
+// Sync subscribers may publish further events. Async ones must not: every
+// event is queued for them too, so with their own queue full Publish would
+// wait for them forever.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []*subscriber
+	// sending counts Publish calls still delivering, which Close waits
+	// for before closing the queues.
+	sending sync.WaitGroup
+	wg      sync.WaitGroup
+	closed  bool
+}
+
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers fn for events of type E, or for every event when E is
+// Event itself. name identifies the subscriber in error logs.
+func Subscribe[E Event](b *Bus, name string, mode Mode, fn func(E) error) {
+	s := &subscriber{
+		name: name,
+		handle: func(e Event) error {
+			if ev, ok := e.(E); ok {
+				return fn(ev)
+			}
+			return nil
+		},
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		panic("events: Subscribe on a closed bus")
+	}
+	if mode == Async {
+		s.queue = make(chan Event, asyncQueueSize)
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			for e := range s.queue {
+				s.deliver(e)
+			}
+		}()
+	}
+	b.subs = append(b.subs, s)
+}
+
+// Publish delivers e to the sync subscribers and queues it for the async
+// ones. Events published after Close are dropped. The lock is not held
+// while delivering, so a full queue does not block Subscribe or Close and
+// sync subscribers can publish.
+func (b *Bus) Publish(e Event) {
+	b.mu.RLock()
+	if b.closed {
+		b.mu.RUnlock()
+		log.Printf("events: %s published after close, dropped", e.Name())
+		return
+	}
+	// Subscribe only appends, so the subscribers seen here do not change.
+	subs := b.subs
+	b.sending.Add(1)
+	b.mu.RUnlock()
+	defer b.sending.Done()
+
+	for _, s := range subs {
+		if s.queue != nil {
+			s.queue <- e
+		} else {
+			s.deliver(e)
+		}
+	}
+}
+
+// Close stops accepting events and waits until the async subscribers have
+// handled everything already published.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	subs := b.subs
+	b.mu.Unlock()
+
+	// Publish calls that got in before closed was set may still be
+	// queueing; the subscribers keep draining meanwhile.
+	b.sending.Wait()
+	for _, s := range subs {
+		if s.queue != nil {
+			close(s.queue)
+		}
+	}
+	b.wg.Wait()
+}
+
+func (s *subscriber) deliver(e Event) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("events: %s panicked handling %s: %v\n%s", s.name, e.Name(), rec, debug.Stack())
+		}
+	}()
+	if err := s.handle(e); err != nil {
+		log.Printf("events: %s failed handling %s: %v", s.name, e.Name(), err)
+	}
+}
@@ -0,0 +1,201 @@
+package events
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testEvent struct{ N int }
+
+func (testEvent) Name() string { return "test" }
+
+type otherEvent struct{}
+
+func (otherEvent) Name() string { return "other" }
+
+// quietLog hides the logged subscriber failures and panics.
+func quietLog(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+// recorder collects what a subscriber received.
+type recorder struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (r *recorder) add(s string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.got = append(r.got, s)
+}
+
+func (r *recorder) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.got)
+}
+
+func TestSyncSubscribersRunInOrder(t *testing.T) {
+	b := New()
+	defer b.Close()
+	var rec recorder
+	for _, name := range []string{"a", "b", "c"} {
+		Subscribe(b, name, Sync, func(e testEvent) error {
+			rec.add(name)
+			return nil
+		})
+	}
+	Subscribe(b, "other", Sync, func(otherEvent) error {
+		rec.add("other")
+		return nil
+	})
+
+	b.Publish(testEvent{})
+	if got, want := rec.list(), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("after Publish got %v, want %v", got, want)
+	}
+	b.Publish(otherEvent{})
+	if got, want := rec.list(), []string{"a", "b", "c", "other"}; !slices.Equal(got, want) {
+		t.Fatalf("after Publish got %v, want %v", got, want)
+	}
+}
+
+func TestAsyncSubscriberKeepsOrder(t *testing.T) {
+	b := New()
+	var got []int
+	Subscribe(b, "async", Async, func(e testEvent) error {
+		got = append(got, e.N)
+		return nil
+	})
+
+	// More events than the queue holds, so Publish has to wait too.
+	const n = 4 * asyncQueueSize
+	for i := 0; i < n; i++ {
+		b.Publish(testEvent{N: i})
+	}
+	b.Close()
+
+	if len(got) != n {
+		t.Fatalf("received %d events, want %d", len(got), n)
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("event %d is %d, events are out of order", i, v)
+		}
+	}
+}
+
+func TestFailingSubscriberDoesNotAffectOthers(t *testing.T) {
+	quietLog(t)
+	b := New()
+	var syncRec, asyncRec recorder
+	Subscribe(b, "failing", Sync, func(testEvent) error { return errors.New("failed") })
+	Subscribe(b, "panicking", Sync, func(testEvent) error { panic("boom") })
+	Subscribe(b, "sync", Sync, func(e testEvent) error {
+		syncRec.add("ok")
+		return nil
+	})
+	Subscribe(b, "async panicking", Async, func(testEvent) error { panic("boom") })
+	Subscribe(b, "async", Async, func(e testEvent) error {
+		asyncRec.add("ok")
+		return nil
+	})
+
+	b.Publish(testEvent{N: 1})
+	b.Publish(testEvent{N: 2})
+	b.Close()
+
+	if got := syncRec.list(); len(got) != 2 {
+		t.Errorf("sync subscriber received %d events, want 2", len(got))
+	}
+	if got := asyncRec.list(); len(got) != 2 {
+		t.Errorf("async subscriber received %d events, want 2", len(got))
+	}
+}
+
+func TestCloseDrainsQueues(t *testing.T) {
+	quietLog(t)
+	b := New()
+	release := make(chan struct{})
+	var rec recorder
+	Subscribe(b, "slow", Async, func(e testEvent) error {
+		<-release
+		rec.add("handled")
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		b.Publish(testEvent{N: i})
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		b.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the queue was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+
+	if got := rec.list(); len(got) != 10 {
+		t.Fatalf("handled %d events before Close returned, want 10", len(got))
+	}
+	b.Publish(testEvent{N: 10})
+	if got := rec.list(); len(got) != 10 {
+		t.Fatalf("event published after Close was delivered")
+	}
+}
+
+// TestPublishDoesNotHoldLock checks that a sync subscriber can publish
+// while another goroutine is subscribing.
+func TestPublishDoesNotHoldLock(t *testing.T) {
+	b := New()
+	defer b.Close()
+	var rec recorder
+	Subscribe(b, "nested", Sync, func(e testEvent) error {
+		if e.N > 0 {
+			return nil
+		}
+		subscribed := make(chan struct{})
+		go func() {
+			Subscribe(b, "late", Sync, func(otherEvent) error { return nil })
+			close(subscribed)
+		}()
+		select {
+		case <-subscribed:
+		case <-time.After(5 * time.Second):
+			return errors.New("Subscribe blocked while publishing")
+		}
+		b.Publish(testEvent{N: 1})
+		return nil
+	})
+	Subscribe(b, "record", Sync, func(e testEvent) error {
+		rec.add("event")
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		b.Publish(testEvent{N: 0})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Publish deadlocked")
+	}
+	if got := rec.list(); len(got) != 2 {
+		t.Fatalf("received %d events, want 2", len(got))
+	}
+}
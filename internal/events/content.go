@@ -0,0 +1,85 @@
+package events
+
+import "github.com/lukas-pastva/web-charon/internal/models"
+
+// ArticleSaved is published after an article was created or updated.
+type ArticleSaved struct {
+	Article *models.Article
+	Created bool
+	// FirstPublished is set when the article has just been published for
+	// the first time.
+	FirstPublished bool
+}
+
+// ArticleDeleted carries the article as it was before deletion.
+type ArticleDeleted struct {
+	Article *models.Article
+}
+
+type GallerySaved struct {
+	Gallery *models.Gallery
+	Created bool
+	// PreviousArticleID is the article the gallery was attached to before
+	// the update, if any.
+	PreviousArticleID *int64
+}
+
+// GalleryDeleted carries the gallery, with its images, as it was before
+// deletion.
+type GalleryDeleted struct {
+	Gallery *models.Gallery
+}
+
+// ImageUploaded is published for every photo added to a gallery, whether
+// uploaded one by one, imported from an archive or sent through the API.
+type ImageUploaded struct {
+	Gallery *models.Gallery
+	Image   *models.Image
+}
+
+// CommentCreated is published for every stored comment, including spam and
+// organizer replies; subscribers check the comment's status. Title and Path
+// name the page it was posted on.
+type CommentCreated struct {
+	Comment *models.Comment
+	Title   string
+	Path    string
+}
+
+// ImageChanged is published after a photo's caption was edited, it was
+// moved to another gallery or it was deleted. Image is the photo as it was
+// before the change.
+type ImageChanged struct {
+	Image   *models.Image
+	Deleted bool
+}
+
+// CommentsModerated is published after existing comments were approved,
+// marked as spam, edited or deleted.
+type CommentsModerated struct {
+	IDs []int64 // empty when all spam was deleted
+	// Paths are the public pages showing the comments, looked up before
+	// the change. Empty when unknown.
+	Paths []string
+}
+
+// SettingsChanged is published after the site settings were saved.
+type SettingsChanged struct{}
+
+// UserChanged is published after a user account was created, edited or
+// deleted.
+type UserChanged struct {
+	User    *models.User
+	Deleted bool
+}
+
+func (ArticleSaved) Name() string      { return "ArticleSaved" }
+func (ArticleDeleted) Name() string    { return "ArticleDeleted" }
+func (GallerySaved) Name() string      { return "GallerySaved" }
+func (GalleryDeleted) Name() string    { return "GalleryDeleted" }
+func (ImageUploaded) Name() string     { return "ImageUploaded" }
+func (ImageChanged) Name() string      { return "ImageChanged" }
+func (CommentCreated) Name() string    { return "CommentCreated" }
+func (CommentsModerated) Name() string { return "CommentsModerated" }
+func (SettingsChanged) Name() string   { return "SettingsChanged" }
+func (UserChanged) Name() string       { return "UserChanged" }
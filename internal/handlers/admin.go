@@ -1,25 +1,49 @@
 package handlers
 
 import (
-	"html/template"
+	"archive/zip"
+	"bytes"
+	"fmt"
 	"log"
 	"net/http"
+	netmail "net/mail"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/lukas-pastva/web-charon/internal/events"
+	"github.com/lukas-pastva/web-charon/internal/mail"
 	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/pagecache"
+	"github.com/lukas-pastva/web-charon/internal/security"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+	"github.com/lukas-pastva/web-charon/internal/webhook"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AdminHandler struct {
-	Articles    *models.ArticleStore
-	Galleries   *models.GalleryStore
-	Comments    *models.CommentStore
-	Settings    *models.SettingsStore
-	Users       *models.UserStore
-	Templates   map[string]*template.Template
-	StoragePath string
+	Articles  *models.ArticleStore
+	Galleries *models.GalleryStore
+	Comments  *models.CommentStore
+	Bans      *models.CommentBanStore
+	Settings  *models.SettingsStore
+	Users     *models.UserStore
+	Media     *models.MediaStore
+	Templates *Templates
+	Storage   storage.Storage
+	Mailer    *mail.Mailer
+	Tokens    *models.UserTokenStore
+	APITokens *models.APITokenStore
+	GonePaths *models.GoneStore
+	Webhooks  *webhook.Dispatcher
+	Events    *events.Bus
+	Cache     *pagecache.Cache
+	Errors    *ErrorPages
+	Locales   *Locales
+	Version   string
 }
 
 func (h *AdminHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
@@ -31,9 +55,19 @@ func (h *AdminHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		"ArticleCount": len(articles),
 		"GalleryCount": len(galleries),
 		"PendingCount": len(pending),
+		"Cache":        h.Cache.Stats(),
+		"CacheEnabled": h.Cache.Enabled(),
+		"CachePurged":  r.URL.Query().Get("purged") == "true",
 		"CurrentUser":  CurrentUser(r),
 	}
-	h.render(w, "dashboard.html", data)
+	h.render(w, r, "dashboard.html", data)
+}
+
+// Cache_Purge empties the page cache, e.g. after changing templates or
+// files the cache does not know about.
+func (h *AdminHandler) Cache_Purge(w http.ResponseWriter, r *http.Request) {
+	h.Cache.Invalidate()
+	http.Redirect(w, r, "/admin?purged=true", http.StatusSeeOther)
 }
 
 // --- Articles ---
@@ -41,14 +75,14 @@ func (h *AdminHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
 func (h *AdminHandler) Articles_List(w http.ResponseWriter, r *http.Request) {
 	articles, err := h.Articles.GetAll()
 	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, err)
 		return
 	}
-	h.render(w, "articles.html", map[string]interface{}{"Articles": articles, "CurrentUser": CurrentUser(r)})
+	h.render(w, r, "articles.html", map[string]interface{}{"Articles": articles, "CurrentUser": CurrentUser(r)})
 }
 
 func (h *AdminHandler) Articles_New(w http.ResponseWriter, r *http.Request) {
-	h.render(w, "article_form.html", map[string]interface{}{"Article": &models.Article{}, "IsNew": true, "CurrentUser": CurrentUser(r)})
+	h.render(w, r, "article_form.html", map[string]interface{}{"Article": &models.Article{}, "IsNew": true, "CurrentUser": CurrentUser(r)})
 }
 
 func (h *AdminHandler) Articles_Create(w http.ResponseWriter, r *http.Request) {
@@ -63,20 +97,25 @@ func (h *AdminHandler) Articles_Create(w http.ResponseWriter, r *http.Request) {
 	}
 	article.Published = r.FormValue("published") == "on"
 	article.CommentsEnabled = r.FormValue("comments_enabled") == "on"
+	article.CommentsClosed = r.FormValue("comments_closed") == "on"
 
 	if f, _, err := r.FormFile("cover_image"); err == nil {
 		f.Close()
-		filename, err := HandleUpload(r, "cover_image", h.StoragePath)
+		m, _, err := HandleUpload(r, "cover_image", h.Storage, h.Media)
 		if err == nil {
-			article.CoverImage = filename
+			article.CoverImage = m.Filename
 		}
 	}
 
 	if err := h.Articles.Create(article); err != nil {
 		log.Printf("error creating article: %v", err)
-		h.render(w, "article_form.html", map[string]interface{}{"Article": article, "IsNew": true, "Error": "Nepodařilo se vytvořit článek. Ujistěte se, že slug je unikátní.", "CurrentUser": CurrentUser(r)})
+		h.releaseUpload(article.CoverImage)
+		article.CoverImage = ""
+		h.render(w, r, "article_form.html", map[string]interface{}{"Article": article, "IsNew": true, "Error": T(r, "admin.error.article_create"), "CurrentUser": CurrentUser(r)})
 		return
 	}
+	h.unmarkGone("/articles/" + article.Slug)
+	h.articleSaved(article.ID, true, article.Published)
 
 	http.Redirect(w, r, "/admin/articles", http.StatusSeeOther)
 }
@@ -85,48 +124,78 @@ func (h *AdminHandler) Articles_Edit(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	article, err := h.Articles.GetByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
-	h.render(w, "article_form.html", map[string]interface{}{"Article": article, "IsNew": false, "CurrentUser": CurrentUser(r)})
+	h.render(w, r, "article_form.html", map[string]interface{}{"Article": article, "IsNew": false, "CurrentUser": CurrentUser(r)})
 }
 
 func (h *AdminHandler) Articles_Update(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	article, err := h.Articles.GetByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 
 	r.ParseMultipartForm(32 << 20)
 
+	wasPublished := article.PublishedAt != nil
 	article.Title = strings.TrimSpace(r.FormValue("title"))
 	article.Content = r.FormValue("content")
 	article.Excerpt = strings.TrimSpace(r.FormValue("excerpt"))
 	article.Published = r.FormValue("published") == "on"
 	article.CommentsEnabled = r.FormValue("comments_enabled") == "on"
+	article.CommentsClosed = r.FormValue("comments_closed") == "on"
 
+	previousCover := article.CoverImage
+	uploaded := false
 	if f, _, err := r.FormFile("cover_image"); err == nil {
 		f.Close()
-		filename, err := HandleUpload(r, "cover_image", h.StoragePath)
+		m, _, err := HandleUpload(r, "cover_image", h.Storage, h.Media)
 		if err == nil {
-			article.CoverImage = filename
+			article.CoverImage = m.Filename
+			uploaded = true
 		}
 	}
 
 	if err := h.Articles.Update(article); err != nil {
 		log.Printf("error updating article: %v", err)
-		h.render(w, "article_form.html", map[string]interface{}{"Article": article, "IsNew": false, "Error": "Nepodařilo se aktualizovat článek.", "CurrentUser": CurrentUser(r)})
+		if uploaded {
+			h.releaseUpload(article.CoverImage)
+			article.CoverImage = previousCover
+		}
+		h.render(w, r, "article_form.html", map[string]interface{}{"Article": article, "IsNew": false, "Error": T(r, "admin.error.article_update"), "CurrentUser": CurrentUser(r)})
 		return
 	}
 
+	// The new upload holds its own reference, so the old cover's reference
+	// goes away even if the same photo was uploaded again.
+	if uploaded {
+		h.releaseUpload(previousCover)
+	}
+	h.articleSaved(article.ID, false, article.Published && !wasPublished)
+
 	http.Redirect(w, r, "/admin/articles", http.StatusSeeOther)
 }
 
 func (h *AdminHandler) Articles_Delete(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	h.Articles.Delete(id)
+	article, err := h.Articles.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	if err := h.Articles.Delete(id); err != nil {
+		log.Printf("error deleting article: %v", err)
+	} else {
+		h.releaseUpload(article.CoverImage)
+		// Drafts never had a public URL worth answering 410 for.
+		if article.PublishedAt != nil {
+			h.markGone("/articles/" + article.Slug)
+		}
+		h.Events.Publish(events.ArticleDeleted{Article: article})
+	}
 	http.Redirect(w, r, "/admin/articles", http.StatusSeeOther)
 }
 
@@ -135,27 +204,29 @@ func (h *AdminHandler) Articles_Delete(w http.ResponseWriter, r *http.Request) {
 func (h *AdminHandler) Galleries_List(w http.ResponseWriter, r *http.Request) {
 	galleries, err := h.Galleries.GetAll()
 	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, err)
 		return
 	}
 	for i := range galleries {
 		images, _ := h.Galleries.GetImages(galleries[i].ID)
 		galleries[i].Images = images
 	}
-	h.render(w, "galleries.html", map[string]interface{}{"Galleries": galleries, "CurrentUser": CurrentUser(r)})
+	h.render(w, r, "galleries.html", map[string]interface{}{"Galleries": galleries, "CurrentUser": CurrentUser(r)})
 }
 
 func (h *AdminHandler) Galleries_New(w http.ResponseWriter, r *http.Request) {
 	articles, _ := h.Articles.GetAll()
-	h.render(w, "gallery_form.html", map[string]interface{}{"Gallery": &models.Gallery{}, "IsNew": true, "Articles": articles, "CurrentUser": CurrentUser(r)})
+	h.render(w, r, "gallery_form.html", map[string]interface{}{"Gallery": &models.Gallery{}, "IsNew": true, "Articles": articles, "CurrentUser": CurrentUser(r)})
 }
 
 func (h *AdminHandler) Galleries_Create(w http.ResponseWriter, r *http.Request) {
 	galleryTitle := strings.TrimSpace(r.FormValue("title"))
 	gallery := &models.Gallery{
-		Title:       galleryTitle,
-		Slug:        Slugify(galleryTitle),
-		Description: r.FormValue("description"),
+		Title:           galleryTitle,
+		Slug:            Slugify(galleryTitle),
+		Description:     r.FormValue("description"),
+		CommentsEnabled: r.FormValue("comments_enabled") == "on",
+		CommentsClosed:  r.FormValue("comments_closed") == "on",
 	}
 
 	if aid := r.FormValue("article_id"); aid != "" {
@@ -168,9 +239,11 @@ func (h *AdminHandler) Galleries_Create(w http.ResponseWriter, r *http.Request)
 	if err := h.Galleries.Create(gallery); err != nil {
 		log.Printf("error creating gallery: %v", err)
 		articles, _ := h.Articles.GetAll()
-		h.render(w, "gallery_form.html", map[string]interface{}{"Gallery": gallery, "IsNew": true, "Articles": articles, "Error": "Nepodařilo se vytvořit galerii. Ujistěte se, že slug je unikátní.", "CurrentUser": CurrentUser(r)})
+		h.render(w, r, "gallery_form.html", map[string]interface{}{"Gallery": gallery, "IsNew": true, "Articles": articles, "Error": T(r, "admin.error.gallery_create"), "CurrentUser": CurrentUser(r)})
 		return
 	}
+	h.unmarkGone("/gallery/" + gallery.Slug)
+	h.Events.Publish(events.GallerySaved{Gallery: gallery, Created: true})
 
 	http.Redirect(w, r, "/admin/galleries", http.StatusSeeOther)
 }
@@ -179,23 +252,35 @@ func (h *AdminHandler) Galleries_Edit(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	gallery, err := h.Galleries.GetByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 	articles, _ := h.Articles.GetAll()
-	h.render(w, "gallery_form.html", map[string]interface{}{"Gallery": gallery, "IsNew": false, "Articles": articles, "CurrentUser": CurrentUser(r)})
+	galleries, _ := h.Galleries.GetAll()
+	h.render(w, r, "gallery_form.html", map[string]interface{}{
+		"Gallery":     gallery,
+		"IsNew":       false,
+		"Articles":    articles,
+		"Galleries":   galleries,
+		"Duplicates":  h.duplicateUploads(r.URL.Query().Get("duplicates"), gallery.ID),
+		"Import":      archiveImportNoticeFrom(r.URL.Query()),
+		"CurrentUser": CurrentUser(r),
+	})
 }
 
 func (h *AdminHandler) Galleries_Update(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	gallery, err := h.Galleries.GetByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 
+	previousArticleID := gallery.ArticleID
 	gallery.Title = strings.TrimSpace(r.FormValue("title"))
 	gallery.Description = r.FormValue("description")
+	gallery.CommentsEnabled = r.FormValue("comments_enabled") == "on"
+	gallery.CommentsClosed = r.FormValue("comments_closed") == "on"
 	gallery.ArticleID = nil
 
 	if aid := r.FormValue("article_id"); aid != "" {
@@ -207,6 +292,8 @@ func (h *AdminHandler) Galleries_Update(w http.ResponseWriter, r *http.Request)
 
 	if err := h.Galleries.Update(gallery); err != nil {
 		log.Printf("error updating gallery: %v", err)
+	} else {
+		h.Events.Publish(events.GallerySaved{Gallery: gallery, PreviousArticleID: previousArticleID})
 	}
 
 	http.Redirect(w, r, "/admin/galleries", http.StatusSeeOther)
@@ -214,79 +301,536 @@ func (h *AdminHandler) Galleries_Update(w http.ResponseWriter, r *http.Request)
 
 func (h *AdminHandler) Galleries_Delete(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	h.Galleries.Delete(id)
+	gallery, err := h.Galleries.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	if err := h.Galleries.Delete(id); err != nil {
+		log.Printf("error deleting gallery: %v", err)
+	} else {
+		for _, img := range gallery.Images {
+			h.releaseUpload(img.Filename)
+		}
+		h.markGone("/gallery/" + gallery.Slug)
+		h.Events.Publish(events.GalleryDeleted{Gallery: gallery})
+	}
 	http.Redirect(w, r, "/admin/galleries", http.StatusSeeOther)
 }
 
 func (h *AdminHandler) Galleries_UploadImages(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	_, err := h.Galleries.GetByID(id)
+	gallery, err := h.Galleries.GetByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 
 	r.ParseMultipartForm(64 << 20)
 	files := r.MultipartForm.File["images"]
 
-	for i, fh := range files {
-		file, err := fh.Open()
-		if err != nil {
-			continue
-		}
-		file.Close()
-
-		filename, err := HandleUploadFromFileHeader(fh, h.StoragePath)
+	var duplicates []string
+	for _, fh := range files {
+		m, duplicate, err := HandleUploadFromFileHeader(fh, h.Storage, h.Media)
 		if err != nil {
 			log.Printf("upload error: %v", err)
 			continue
 		}
+		if duplicate {
+			duplicates = append(duplicates, m.Filename)
+		}
 
 		img := &models.Image{
 			GalleryID: id,
-			Filename:  filename,
-			Caption:   "",
-			SortOrder: i,
+			Filename:  m.Filename,
 		}
-		h.Galleries.AddImage(img)
+		if err := h.Galleries.AddImage(img); err != nil {
+			log.Printf("error adding image: %v", err)
+			h.releaseUpload(m.Filename)
+			continue
+		}
+		h.Events.Publish(events.ImageUploaded{Gallery: gallery, Image: img})
 	}
 
-	http.Redirect(w, r, "/admin/galleries/"+strconv.FormatInt(id, 10)+"/edit", http.StatusSeeOther)
+	target := "/admin/galleries/" + strconv.FormatInt(id, 10) + "/edit"
+	if len(duplicates) > 0 {
+		target += "?duplicates=" + url.QueryEscape(strings.Join(duplicates, ","))
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// Galleries_ImportArchive extracts the photos from an uploaded ZIP archive
+// into the gallery. Large archives are spooled to a temporary file by the
+// multipart parser, so only the entry being stored is held in memory.
+func (h *AdminHandler) Galleries_ImportArchive(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	gallery, err := h.Galleries.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	target := "/admin/galleries/" + strconv.FormatInt(id, 10) + "/edit"
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveUpload)
+	file, fh, err := r.FormFile("archive")
+	if err != nil {
+		log.Printf("archive upload error: %v", err)
+		http.Redirect(w, r, target+"?import_error="+url.QueryEscape(T(r, "admin.error.archive_upload")), http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, fh.Size)
+	if err != nil {
+		http.Redirect(w, r, target+"?import_error="+url.QueryEscape(T(r, "admin.error.archive_invalid")), http.StatusSeeOther)
+		return
+	}
+
+	result, err := importArchive(zr, id, h.Galleries, h.Storage, h.Media)
+	if result != nil {
+		for _, img := range result.Images {
+			h.Events.Publish(events.ImageUploaded{Gallery: gallery, Image: img})
+		}
+	}
+	if err != nil {
+		log.Printf("archive import error: %v", err)
+		msg := T(r, "admin.error.archive_failed")
+		if result != nil && result.Imported > 0 {
+			msg = T(r, "admin.error.archive_interrupted", "count", result.Imported)
+		}
+		http.Redirect(w, r, target+"?import_error="+url.QueryEscape(msg), http.StatusSeeOther)
+		return
+	}
+
+	q := url.Values{}
+	q.Set("imported", strconv.Itoa(result.Imported))
+	if len(result.Skipped) > 0 {
+		// Keep the redirect URL short; the count is always reported.
+		skipped := result.Skipped
+		if len(skipped) > 20 {
+			skipped = skipped[:20]
+		}
+		q.Set("skipped", strings.Join(skipped, ","))
+		q.Set("skipped_count", strconv.Itoa(len(result.Skipped)))
+	}
+	if len(result.Duplicates) > 0 {
+		q.Set("duplicates", strings.Join(result.Duplicates, ","))
+	}
+	http.Redirect(w, r, target+"?"+q.Encode(), http.StatusSeeOther)
 }
 
 func (h *AdminHandler) Images_Delete(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	img, err := h.Galleries.GetImageByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 	galleryID := img.GalleryID
-	h.Galleries.DeleteImage(id)
+	if err := h.Galleries.DeleteImage(id); err != nil {
+		log.Printf("error deleting image: %v", err)
+	} else {
+		h.releaseUpload(img.Filename)
+		if gallery, err := h.Galleries.GetByID(galleryID); err == nil {
+			h.markGone(photoPath(gallery, img))
+		}
+		h.Events.Publish(events.ImageChanged{Image: img, Deleted: true})
+	}
 	http.Redirect(w, r, "/admin/galleries/"+strconv.FormatInt(galleryID, 10)+"/edit", http.StatusSeeOther)
 }
 
+func (h *AdminHandler) Images_Update(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	img, err := h.Galleries.GetImageByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+
+	img.Caption = strings.TrimSpace(r.FormValue("caption"))
+	img.AltText = strings.TrimSpace(r.FormValue("alt_text"))
+
+	if err := h.Galleries.UpdateImage(img); err != nil {
+		log.Printf("error updating image: %v", err)
+	} else {
+		h.Events.Publish(events.ImageChanged{Image: img})
+	}
+	http.Redirect(w, r, "/admin/galleries/"+strconv.FormatInt(img.GalleryID, 10)+"/edit#image-"+strconv.FormatInt(img.ID, 10), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Images_Move(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	img, err := h.Galleries.GetImageByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+
+	targetID, _ := strconv.ParseInt(r.FormValue("gallery_id"), 10, 64)
+	if targetID > 0 && targetID != img.GalleryID {
+		if _, err := h.Galleries.GetByID(targetID); err != nil {
+			h.Errors.NotFound(w, r)
+			return
+		}
+		if err := h.Galleries.MoveImage(img.ID, targetID); err != nil {
+			log.Printf("error moving image: %v", err)
+		} else {
+			h.Events.Publish(events.ImageChanged{Image: img})
+		}
+	}
+	http.Redirect(w, r, "/admin/galleries/"+strconv.FormatInt(img.GalleryID, 10)+"/edit", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Galleries_Reorder(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	gallery, err := h.Galleries.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+
+	// The order arrives as a comma-separated list of image IDs, first to last.
+	var imageIDs []int64
+	for _, part := range strings.Split(r.FormValue("order"), ",") {
+		imageID, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err == nil && imageID > 0 {
+			imageIDs = append(imageIDs, imageID)
+		}
+	}
+
+	err = h.Galleries.ReorderImages(id, imageIDs)
+	if err != nil {
+		log.Printf("error reordering images: %v", err)
+	} else {
+		h.Events.Publish(events.GallerySaved{Gallery: gallery})
+	}
+
+	// Drag-and-drop saves in the background and only needs a status code.
+	if r.Header.Get("X-Requested-With") == "fetch" {
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/admin/galleries/"+strconv.FormatInt(id, 10)+"/edit", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Galleries_SetCover(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	gallery, err := h.Galleries.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+
+	var coverID *int64
+	if imageID, err := strconv.ParseInt(r.FormValue("image_id"), 10, 64); err == nil && imageID > 0 {
+		img, err := h.Galleries.GetImageByID(imageID)
+		if err != nil || img.GalleryID != id {
+			h.Errors.NotFound(w, r)
+			return
+		}
+		coverID = &img.ID
+	}
+
+	if err := h.Galleries.SetCover(id, coverID); err != nil {
+		log.Printf("error setting gallery cover: %v", err)
+	} else {
+		gallery.CoverImageID = coverID
+		h.Events.Publish(events.GallerySaved{Gallery: gallery})
+	}
+	http.Redirect(w, r, "/admin/galleries/"+strconv.FormatInt(id, 10)+"/edit", http.StatusSeeOther)
+}
+
+// duplicateUpload describes an uploaded photo whose content was already
+// stored, together with the other galleries that contain it.
+type duplicateUpload struct {
+	Filename  string
+	Galleries []models.Gallery
+}
+
+func (h *AdminHandler) duplicateUploads(param string, currentGalleryID int64) []duplicateUpload {
+	var duplicates []duplicateUpload
+	for _, filename := range strings.Split(param, ",") {
+		if !contentAddressedName.MatchString(filename) {
+			continue
+		}
+		galleries, err := h.Galleries.GetByImageFilename(filename)
+		if err != nil {
+			log.Printf("error looking up duplicate upload: %v", err)
+			continue
+		}
+		d := duplicateUpload{Filename: filename}
+		for _, g := range galleries {
+			if g.ID != currentGalleryID {
+				d.Galleries = append(d.Galleries, g)
+			}
+		}
+		duplicates = append(duplicates, d)
+	}
+	return duplicates
+}
+
 // --- Comments ---
 
+const commentsPerPage = 30
+
 func (h *AdminHandler) Comments_List(w http.ResponseWriter, r *http.Request) {
-	comments, err := h.Comments.GetAll()
+	q := r.URL.Query()
+	filter := models.CommentFilter{
+		Status:  q.Get("status"),
+		Query:   strings.TrimSpace(q.Get("q")),
+		Visitor: q.Get("visitor"),
+	}
+	switch filter.Status {
+	case models.CommentPending, models.CommentApproved, models.CommentSpam:
+	default:
+		filter.Status = ""
+	}
+	if !isVisitorHash(filter.Visitor) {
+		filter.Visitor = ""
+	}
+	filter.Target, _ = models.ParseCommentTarget(q.Get("target"))
+	if from, err := time.ParseInLocation("2006-01-02", q.Get("from"), time.Local); err == nil {
+		filter.From = from
+	}
+	if to, err := time.ParseInLocation("2006-01-02", q.Get("to"), time.Local); err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	comments, total, err := h.Comments.Search(filter, commentsPerPage, (page-1)*commentsPerPage)
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error listing comments: %w", err))
+		return
+	}
+	totalPages := (total + commentsPerPage - 1) / commentsPerPage
+	articles, _ := h.Articles.GetAll()
+	galleries, _ := h.Galleries.GetAll()
+	spamCount, _ := h.Comments.CountByStatus(models.CommentSpam)
+	pendingCount, _ := h.Comments.CountByStatus(models.CommentPending)
+
+	// Links to other pages keep the current filter.
+	pageURL := func(p int) string {
+		v := url.Values{}
+		for key, values := range q {
+			if key != "page" {
+				v[key] = values
+			}
+		}
+		if p > 1 {
+			v.Set("page", strconv.Itoa(p))
+		}
+		if len(v) == 0 {
+			return "/admin/comments"
+		}
+		return "/admin/comments?" + v.Encode()
+	}
+
+	h.render(w, r, "comments.html", map[string]interface{}{
+		"Comments":     comments,
+		"Articles":     articles,
+		"Galleries":    galleries,
+		"Filter":       filter,
+		"Target":       filter.Target.String(),
+		"From":         q.Get("from"),
+		"To":           q.Get("to"),
+		"Total":        total,
+		"Page":         page,
+		"TotalPages":   totalPages,
+		"PrevURL":      pageURL(page - 1),
+		"NextURL":      pageURL(page + 1),
+		"HasPrev":      page > 1,
+		"HasNext":      page < totalPages,
+		"ReturnURL":    pageURL(page),
+		"ShowSpam":     filter.Status == models.CommentSpam,
+		"SpamCount":    spamCount,
+		"PendingCount": pendingCount,
+		"CurrentUser":  CurrentUser(r),
+	})
+}
+
+// Comments_Bulk applies one moderation action to all selected comments.
+func (h *AdminHandler) Comments_Bulk(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	var ids []int64
+	for _, v := range r.PostForm["ids"] {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	paths := h.commentPaths(ids)
+	var err error
+	switch r.PostFormValue("action") {
+	case "approve":
+		err = h.Comments.SetStatusMany(ids, models.CommentApproved)
+	case "spam":
+		err = h.Comments.SetStatusMany(ids, models.CommentSpam)
+	case "delete":
+		err = h.Comments.DeleteMany(ids)
+	}
+	if err != nil {
+		log.Printf("error in bulk comment action: %v", err)
+	} else if len(ids) > 0 {
+		h.Events.Publish(events.CommentsModerated{IDs: ids, Paths: paths})
+	}
+	http.Redirect(w, r, commentsReturnURL(r), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Comments_Edit(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	comment, err := h.Comments.GetForModeration(id)
 	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.NotFound(w, r)
 		return
 	}
-	h.render(w, "comments.html", map[string]interface{}{"Comments": comments, "CurrentUser": CurrentUser(r)})
+	h.render(w, r, "comment_form.html", map[string]interface{}{
+		"Comment":     comment,
+		"ReturnURL":   commentsReturnURL(r),
+		"CurrentUser": CurrentUser(r),
+	})
+}
+
+func (h *AdminHandler) Comments_Update(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	comment, err := h.Comments.GetForModeration(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	authorName := strings.TrimSpace(r.FormValue("author_name"))
+	content := strings.TrimSpace(r.FormValue("content"))
+	if authorName == "" || content == "" {
+		comment.AuthorName, comment.Content = authorName, content
+		h.render(w, r, "comment_form.html", map[string]interface{}{
+			"Comment":     comment,
+			"ReturnURL":   commentsReturnURL(r),
+			"Error":       T(r, "admin.error.comment_fields"),
+			"CurrentUser": CurrentUser(r),
+		})
+		return
+	}
+	paths := h.commentPaths([]int64{id})
+	if err := h.Comments.UpdateText(id, authorName, content); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error updating comment: %w", err))
+		return
+	}
+	if r.FormValue("approve") != "" {
+		h.Comments.Approve(id)
+	}
+	h.Events.Publish(events.CommentsModerated{IDs: []int64{id}, Paths: paths})
+	http.Redirect(w, r, commentsReturnURL(r), http.StatusSeeOther)
 }
 
 func (h *AdminHandler) Comments_Approve(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	h.Comments.Approve(id)
-	http.Redirect(w, r, "/admin/comments", http.StatusSeeOther)
+	paths := h.commentPaths([]int64{id})
+	if err := h.Comments.Approve(id); err == nil {
+		h.Events.Publish(events.CommentsModerated{IDs: []int64{id}, Paths: paths})
+	}
+	http.Redirect(w, r, commentsReturnURL(r), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Comments_Spam(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	paths := h.commentPaths([]int64{id})
+	if err := h.Comments.MarkSpam(id); err == nil {
+		h.Events.Publish(events.CommentsModerated{IDs: []int64{id}, Paths: paths})
+	}
+	http.Redirect(w, r, commentsReturnURL(r), http.StatusSeeOther)
 }
 
 func (h *AdminHandler) Comments_Delete(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
-	h.Comments.Delete(id)
-	http.Redirect(w, r, "/admin/comments", http.StatusSeeOther)
+	paths := h.commentPaths([]int64{id})
+	if err := h.Comments.Delete(id); err == nil {
+		h.Events.Publish(events.CommentsModerated{IDs: []int64{id}, Paths: paths})
+	}
+	http.Redirect(w, r, commentsReturnURL(r), http.StatusSeeOther)
+}
+
+// Comments_Reply posts an organizer reply under a comment. The reply is
+// published immediately, and so is the comment being answered.
+func (h *AdminHandler) Comments_Reply(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	parent, err := h.Comments.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	content := strings.TrimSpace(r.FormValue("content"))
+	if content == "" {
+		http.Redirect(w, r, commentsReturnURL(r), http.StatusSeeOther)
+		return
+	}
+
+	if _, err := h.postReply(parent, CurrentUser(r), content); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error creating reply: %w", err))
+		return
+	}
+	http.Redirect(w, r, commentsReturnURL(r), http.StatusSeeOther)
+}
+
+// postReply publishes user's reply under parent, approving parent first.
+func (h *AdminHandler) postReply(parent *models.Comment, user *models.User, content string) (*models.Comment, error) {
+	reply := &models.Comment{
+		UserID:     &user.ID,
+		AuthorName: user.DisplayName(),
+		Content:    content,
+		Status:     models.CommentApproved,
+	}
+	reply.SetTarget(parent.Target())
+	reply.SetParent(parent)
+	if !parent.Approved() {
+		if err := h.Comments.Approve(parent.ID); err != nil {
+			log.Printf("error approving comment: %v", err)
+		}
+	}
+	if err := h.Comments.Create(reply); err != nil {
+		return nil, err
+	}
+	if c, err := h.Comments.GetForModeration(reply.ID); err == nil {
+		h.Events.Publish(events.CommentCreated{Comment: reply, Title: c.TargetTitle, Path: c.TargetURL()})
+	} else {
+		log.Printf("error loading reply %d: %v", reply.ID, err)
+	}
+	return reply, nil
+}
+
+func (h *AdminHandler) Comments_DeleteSpam(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.Comments.DeleteSpam(); err != nil {
+		log.Printf("error deleting spam comments: %v", err)
+	} else {
+		h.Events.Publish(events.CommentsModerated{})
+	}
+	http.Redirect(w, r, "/admin/comments?status=spam", http.StatusSeeOther)
+}
+
+// commentPaths returns the public pages showing the comments, for
+// CommentsModerated. Look them up before the comments change, as deleted
+// ones cannot be found afterwards.
+func (h *AdminHandler) commentPaths(ids []int64) []string {
+	paths, err := h.Comments.TargetURLs(ids)
+	if err != nil {
+		log.Printf("error looking up comment pages: %v", err)
+	}
+	return paths
+}
+
+// commentsReturnURL keeps the moderator on the filtered page the action
+// came from. Only moderation URLs are accepted to avoid open redirects.
+func commentsReturnURL(r *http.Request) string {
+	ret := r.FormValue("return")
+	if ret == "/admin/comments" || strings.HasPrefix(ret, "/admin/comments?") {
+		return ret
+	}
+	return "/admin/comments"
 }
 
 // --- Settings ---
@@ -294,39 +838,324 @@ func (h *AdminHandler) Comments_Delete(w http.ResponseWriter, r *http.Request) {
 func (h *AdminHandler) Settings_Show(w http.ResponseWriter, r *http.Request) {
 	settings, err := h.Settings.GetAll()
 	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, err)
 		return
 	}
 	saved := r.URL.Query().Get("saved") == "true"
-	h.render(w, "settings.html", map[string]interface{}{"Settings": settings, "Saved": saved, "CurrentUser": CurrentUser(r)})
+	h.render(w, r, "settings.html", map[string]interface{}{
+		"Settings":     settings,
+		"Saved":        saved,
+		"Languages":    h.Locales.Bundle.Languages(),
+		"SiteLanguage": h.Locales.Site(),
+		"CurrentUser":  CurrentUser(r),
+	})
 }
 
 func (h *AdminHandler) Settings_Update(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
-	commentsEnabled := "false"
-	if r.FormValue("comments_enabled") == "on" {
-		commentsEnabled = "true"
+	// A hidden "off" input precedes the checkbox, so the field is only
+	// present when the form actually shows the switch.
+	if values, ok := r.PostForm["comments_enabled"]; ok {
+		commentsEnabled := "false"
+		if values[len(values)-1] == "on" {
+			commentsEnabled = "true"
+		}
+		h.Settings.Set("comments_enabled", commentsEnabled)
 	}
-	h.Settings.Set("comments_enabled", commentsEnabled)
+	if _, ok := r.PostForm["comments_auto_close_days"]; ok {
+		days, err := strconv.Atoi(strings.TrimSpace(r.PostFormValue("comments_auto_close_days")))
+		if err != nil || days < 0 {
+			days = 0
+		}
+		h.Settings.Set("comments_auto_close_days", strconv.Itoa(days))
+	}
+	switch r.PostFormValue("feed_content") {
+	case "full", "excerpt":
+		h.Settings.Set("feed_content", r.PostFormValue("feed_content"))
+	}
+	if lang := r.PostFormValue("site_language"); h.Locales.Bundle.Has(lang) {
+		h.Settings.Set(siteLanguageSetting, lang)
+	}
+	h.Events.Publish(events.SettingsChanged{})
 	http.Redirect(w, r, "/admin/settings?saved=true", http.StatusSeeOther)
 }
 
+// --- Storage (admin-only) ---
+
+func (h *AdminHandler) Storage_Show(w http.ResponseWriter, r *http.Request) {
+	report, err := h.storageReport()
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error building storage report: %w", err))
+		return
+	}
+	removed, _ := strconv.Atoi(r.URL.Query().Get("removed"))
+	h.render(w, r, "storage.html", map[string]interface{}{
+		"Report":      report,
+		"Removed":     removed,
+		"HasRemoved":  r.URL.Query().Has("removed"),
+		"CurrentUser": CurrentUser(r),
+	})
+}
+
+func (h *AdminHandler) Storage_GC(w http.ResponseWriter, r *http.Request) {
+	report, err := h.storageReport()
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error building storage report: %w", err))
+		return
+	}
+	removed, err := storage.RemoveOrphans(h.Storage, report)
+	if err != nil {
+		log.Printf("storage gc: %v", err)
+	}
+	for _, name := range removed {
+		if err := h.Media.DeleteByFilename(name); err != nil {
+			log.Printf("storage gc: forget media %s: %v", name, err)
+		}
+	}
+	log.Printf("storage gc: removed %d orphaned files", len(removed))
+	http.Redirect(w, r, "/admin/storage?removed="+strconv.Itoa(len(removed)), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) storageReport() (*storage.GCReport, error) {
+	referenced, err := ReferencedUploads(h.Articles, h.Galleries)
+	if err != nil {
+		return nil, err
+	}
+	return storage.FindOrphans(h.Storage, referenced, storage.DefaultGracePeriod)
+}
+
+// --- Outgoing mail (admin-only) ---
+
+func (h *AdminHandler) Mail_Show(w http.ResponseWriter, r *http.Request) {
+	messages, err := h.Mailer.Outbox.Recent(100)
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error loading outbox: %w", err))
+		return
+	}
+	h.render(w, r, "mail.html", map[string]interface{}{
+		"Messages":    messages,
+		"Enabled":     h.Mailer.Enabled(),
+		"CurrentUser": CurrentUser(r),
+	})
+}
+
+func (h *AdminHandler) Mail_Retry(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err := h.Mailer.Outbox.Retry(id); err != nil {
+		log.Printf("error retrying mail %d: %v", id, err)
+	}
+	h.Mailer.Wake()
+	http.Redirect(w, r, "/admin/mail", http.StatusSeeOther)
+}
+
+// --- Webhooks (admin-only) ---
+
+// webhookEventLabels are the catalog keys describing the webhook events in
+// the administration.
+var webhookEventLabels = map[string]string{
+	models.EventArticlePublished: "webhook_event.article_published",
+	models.EventArticleDeleted:   "webhook_event.article_deleted",
+	models.EventGalleryCreated:   "webhook_event.gallery_created",
+	models.EventGalleryDeleted:   "webhook_event.gallery_deleted",
+	models.EventCommentPending:   "webhook_event.comment_pending",
+	models.EventPing:             "webhook_event.ping",
+}
+
+// webhookLogSize is how many deliveries the webhook pages show.
+const webhookLogSize = 50
+
+func (h *AdminHandler) Webhooks_List(w http.ResponseWriter, r *http.Request) {
+	hooks, err := h.Webhooks.Hooks.GetAll()
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error loading webhooks: %w", err))
+		return
+	}
+	deliveries, err := h.Webhooks.Deliveries.Recent(0, webhookLogSize)
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error loading webhook deliveries: %w", err))
+		return
+	}
+	h.render(w, r, "webhooks.html", map[string]interface{}{
+		"Webhooks":    hooks,
+		"Deliveries":  deliveries,
+		"EventLabels": webhookEventLabels,
+		"CurrentUser": CurrentUser(r),
+	})
+}
+
+func (h *AdminHandler) Webhooks_New(w http.ResponseWriter, r *http.Request) {
+	h.renderWebhookForm(w, r, &models.Webhook{Active: true, Secret: webhook.NewSecret()}, map[string]interface{}{"IsNew": true})
+}
+
+func (h *AdminHandler) Webhooks_Create(w http.ResponseWriter, r *http.Request) {
+	hook := &models.Webhook{}
+	if msg := webhookFromForm(r, hook); msg != "" {
+		h.renderWebhookForm(w, r, hook, map[string]interface{}{"IsNew": true, "Error": msg})
+		return
+	}
+	if err := h.Webhooks.Hooks.Create(hook); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error creating webhook: %w", err))
+		return
+	}
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Webhooks_Edit(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	hook, err := h.Webhooks.Hooks.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	h.renderWebhookForm(w, r, hook, map[string]interface{}{
+		"Saved":  r.URL.Query().Get("saved") == "true",
+		"Tested": r.URL.Query().Get("tested") == "true",
+	})
+}
+
+func (h *AdminHandler) Webhooks_Update(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	hook, err := h.Webhooks.Hooks.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	if msg := webhookFromForm(r, hook); msg != "" {
+		h.renderWebhookForm(w, r, hook, map[string]interface{}{"Error": msg})
+		return
+	}
+	if err := h.Webhooks.Hooks.Update(hook); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error updating webhook: %w", err))
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d/edit?saved=true", hook.ID), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Webhooks_Delete(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err := h.Webhooks.Hooks.Delete(id); err != nil {
+		log.Printf("error deleting webhook %d: %v", id, err)
+	}
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// Webhooks_Test queues a ping so the receiver can be checked from the
+// delivery log, even while the webhook is switched off.
+func (h *AdminHandler) Webhooks_Test(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	hook, err := h.Webhooks.Hooks.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	if err := h.Webhooks.SendTest(hook, T(r, "webhooks.test_message")); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error queueing webhook test: %w", err))
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d/edit?tested=true#deliveries", hook.ID), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Webhooks_RetryDelivery(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err := h.Webhooks.Deliveries.Retry(id); err != nil {
+		log.Printf("error retrying webhook delivery %d: %v", id, err)
+	}
+	h.Webhooks.Wake()
+	ret := r.FormValue("return")
+	if !strings.HasPrefix(ret, "/admin/webhooks") {
+		ret = "/admin/webhooks"
+	}
+	http.Redirect(w, r, ret, http.StatusSeeOther)
+}
+
+func (h *AdminHandler) renderWebhookForm(w http.ResponseWriter, r *http.Request, hook *models.Webhook, data map[string]interface{}) {
+	if hook.ID != 0 {
+		deliveries, err := h.Webhooks.Deliveries.Recent(hook.ID, webhookLogSize)
+		if err != nil {
+			log.Printf("error loading webhook deliveries: %v", err)
+		}
+		data["Deliveries"] = deliveries
+	}
+	data["Webhook"] = hook
+	data["Events"] = models.WebhookEvents
+	data["EventLabels"] = webhookEventLabels
+	data["CurrentUser"] = CurrentUser(r)
+	h.render(w, r, "webhook_form.html", data)
+}
+
+// webhookFromForm copies the submitted form onto hook and returns an error
+// message for invalid input. An empty secret gets a generated one.
+func webhookFromForm(r *http.Request, hook *models.Webhook) string {
+	r.ParseForm()
+	hook.Name = strings.TrimSpace(r.FormValue("name"))
+	hook.URL = strings.TrimSpace(r.FormValue("url"))
+	hook.Secret = strings.TrimSpace(r.FormValue("secret"))
+	hook.Active = r.FormValue("active") == "on"
+	hook.Events = nil
+	for _, event := range models.WebhookEvents {
+		if r.FormValue("event_"+event) == "on" {
+			hook.Events = append(hook.Events, event)
+		}
+	}
+	if hook.Secret == "" {
+		hook.Secret = webhook.NewSecret()
+	}
+
+	u, err := url.Parse(hook.URL)
+	switch {
+	case hook.Name == "" || len(hook.Name) > 100:
+		return T(r, "admin.error.webhook_name")
+	case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(hook.URL) > 1000:
+		return T(r, "admin.error.webhook_url")
+	case len(hook.Secret) > 255:
+		return T(r, "admin.error.webhook_secret")
+	case len(hook.Events) == 0:
+		return T(r, "admin.error.webhook_events")
+	}
+	return ""
+}
+
+// articleSaved publishes ArticleSaved with the article as stored, including
+// the publication date the database assigned.
+func (h *AdminHandler) articleSaved(id int64, created, firstPublished bool) {
+	article, err := h.Articles.GetByID(id)
+	if err != nil {
+		log.Printf("error loading saved article %d: %v", id, err)
+		return
+	}
+	h.Events.Publish(events.ArticleSaved{Article: article, Created: created, FirstPublished: firstPublished})
+}
+
+// releaseUpload drops the reference held by a removed or replaced record and
+// deletes the file once nothing else uses it.
+func (h *AdminHandler) releaseUpload(filename string) {
+	if err := ReleaseUpload(h.Storage, h.Media, filename); err != nil {
+		log.Printf("error releasing upload: %v", err)
+	}
+}
+
 // --- Users (admin-only) ---
 
 func (h *AdminHandler) Users_List(w http.ResponseWriter, r *http.Request) {
 	users, err := h.Users.GetAll()
 	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, err)
 		return
 	}
 	currentUser := CurrentUser(r)
 	firstAdminID, _ := h.Users.GetFirstAdminID()
 	isInitialAdmin := currentUser != nil && currentUser.ID == firstAdminID
-	h.render(w, "users.html", map[string]interface{}{"Users": users, "CurrentUser": currentUser, "IsInitialAdmin": isInitialAdmin})
+	h.render(w, r, "users.html", map[string]interface{}{
+		"Users":          users,
+		"CurrentUser":    currentUser,
+		"IsInitialAdmin": isInitialAdmin,
+		"Invited":        r.URL.Query().Get("invited"),
+		"MailEnabled":    h.Mailer.Enabled(),
+	})
 }
 
 func (h *AdminHandler) Users_New(w http.ResponseWriter, r *http.Request) {
-	h.render(w, "user_form.html", map[string]interface{}{"User": &models.User{}, "IsNew": true, "CurrentUser": CurrentUser(r)})
+	h.render(w, r, "user_form.html", map[string]interface{}{"User": &models.User{}, "IsNew": true, "MailEnabled": h.Mailer.Enabled(), "CurrentUser": CurrentUser(r)})
 }
 
 func (h *AdminHandler) Users_Create(w http.ResponseWriter, r *http.Request) {
@@ -334,62 +1163,133 @@ func (h *AdminHandler) Users_Create(w http.ResponseWriter, r *http.Request) {
 
 	nickname := strings.TrimSpace(r.FormValue("nickname"))
 	password := r.FormValue("password")
-	if nickname == "" || password == "" {
-		h.render(w, "user_form.html", map[string]interface{}{
-			"User":        &models.User{Name: r.FormValue("name"), Surname: r.FormValue("surname"), Nickname: nickname, IsAdmin: r.FormValue("is_admin") == "on"},
+	email, emailOK := parseEmail(r.FormValue("email"))
+	formUser := &models.User{
+		Name:           r.FormValue("name"),
+		Surname:        r.FormValue("surname"),
+		Nickname:       nickname,
+		Email:          strings.TrimSpace(r.FormValue("email")),
+		NotifyComments: r.FormValue("notify_comments") == "on",
+		IsAdmin:        r.FormValue("is_admin") == "on",
+	}
+	formError := func(msg string) {
+		h.render(w, r, "user_form.html", map[string]interface{}{
+			"User":        formUser,
 			"IsNew":       true,
-			"Error":       "Přezdívka a heslo jsou povinné.",
+			"Error":       msg,
+			"MailEnabled": h.Mailer.Enabled(),
 			"CurrentUser": CurrentUser(r),
 		})
+	}
+	// Without a password the user is invited by email to choose one.
+	invite := password == ""
+	switch {
+	case nickname == "":
+		formError(T(r, "admin.error.nickname_required"))
+		return
+	case !emailOK:
+		formError(T(r, "admin.error.invalid_email"))
+		return
+	case invite && email == "":
+		formError(T(r, "admin.error.password_or_email"))
+		return
+	case invite && !h.Mailer.Enabled():
+		formError(T(r, "admin.error.mail_disabled"))
 		return
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
-		return
+	hash := []byte{}
+	if !invite {
+		var err error
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			h.Errors.InternalError(w, r, err)
+			return
+		}
 	}
 
 	user := &models.User{
-		Name:         strings.TrimSpace(r.FormValue("name")),
-		Surname:      strings.TrimSpace(r.FormValue("surname")),
-		Nickname:     nickname,
-		PasswordHash: string(hash),
-		IsAdmin:      r.FormValue("is_admin") == "on",
+		Name:           strings.TrimSpace(r.FormValue("name")),
+		Surname:        strings.TrimSpace(r.FormValue("surname")),
+		Nickname:       nickname,
+		Email:          email,
+		NotifyComments: formUser.NotifyComments,
+		PasswordHash:   string(hash),
+		IsAdmin:        formUser.IsAdmin,
 	}
 
 	if err := h.Users.Create(user); err != nil {
 		log.Printf("error creating user: %v", err)
-		h.render(w, "user_form.html", map[string]interface{}{"User": user, "IsNew": true, "Error": "Nepodařilo se vytvořit uživatele. Přezdívka může být již obsazena.", "CurrentUser": CurrentUser(r)})
+		formError(T(r, "admin.error.user_create"))
 		return
 	}
+	h.Events.Publish(events.UserChanged{User: user})
+
+	if invite {
+		if err := SendUserToken(h.Tokens, h.Mailer, user, models.TokenInvite); err != nil {
+			log.Printf("error sending invite to %s: %v", user.Nickname, err)
+		}
+	} else if err := h.Mailer.Queue(user, "welcome", map[string]interface{}{
+		"Name":     user.DisplayName(),
+		"Nickname": user.Nickname,
+	}); err != nil {
+		log.Printf("error queueing welcome email: %v", err)
+	}
 
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
+// Users_Invite sends a new invite link to a user who has not set a
+// password yet. Earlier links stop working.
+func (h *AdminHandler) Users_Invite(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	user, err := h.Users.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	if user.IsAdmin {
+		firstAdminID, _ := h.Users.GetFirstAdminID()
+		if currentUser := CurrentUser(r); currentUser == nil || currentUser.ID != firstAdminID {
+			h.Errors.Error(w, r, http.StatusForbidden, T(r, "admin.error.initial_admin_only"))
+			return
+		}
+	}
+	if !user.InvitePending() {
+		h.Errors.Error(w, r, http.StatusBadRequest, T(r, "admin.error.password_already_set"))
+		return
+	}
+	if err := SendUserToken(h.Tokens, h.Mailer, user, models.TokenInvite); err != nil {
+		log.Printf("error sending invite to %s: %v", user.Nickname, err)
+		h.Errors.Error(w, r, http.StatusBadRequest, T(r, "admin.error.invite_failed"))
+		return
+	}
+	http.Redirect(w, r, "/admin/users?invited="+url.QueryEscape(user.Nickname), http.StatusSeeOther)
+}
+
 func (h *AdminHandler) Users_Edit(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	user, err := h.Users.GetByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 	currentUser := CurrentUser(r)
 	if user.IsAdmin {
 		firstAdminID, _ := h.Users.GetFirstAdminID()
 		if currentUser == nil || currentUser.ID != firstAdminID {
-			http.Error(w, "Iba pôvodný administrátor môže upravovať účty iných administrátorov.", http.StatusForbidden)
+			h.Errors.Error(w, r, http.StatusForbidden, T(r, "admin.error.initial_admin_only"))
 			return
 		}
 	}
-	h.render(w, "user_form.html", map[string]interface{}{"User": user, "IsNew": false, "CurrentUser": currentUser})
+	h.render(w, r, "user_form.html", map[string]interface{}{"User": user, "IsNew": false, "CurrentUser": currentUser})
 }
 
 func (h *AdminHandler) Users_Update(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	user, err := h.Users.GetByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 
@@ -397,7 +1297,7 @@ func (h *AdminHandler) Users_Update(w http.ResponseWriter, r *http.Request) {
 	if user.IsAdmin {
 		firstAdminID, _ := h.Users.GetFirstAdminID()
 		if currentUser == nil || currentUser.ID != firstAdminID {
-			http.Error(w, "Iba pôvodný administrátor môže upravovať účty iných administrátorov.", http.StatusForbidden)
+			h.Errors.Error(w, r, http.StatusForbidden, T(r, "admin.error.initial_admin_only"))
 			return
 		}
 	}
@@ -408,11 +1308,19 @@ func (h *AdminHandler) Users_Update(w http.ResponseWriter, r *http.Request) {
 	user.Surname = strings.TrimSpace(r.FormValue("surname"))
 	user.Nickname = strings.TrimSpace(r.FormValue("nickname"))
 	user.IsAdmin = r.FormValue("is_admin") == "on"
+	user.NotifyComments = r.FormValue("notify_comments") == "on"
+	email, emailOK := parseEmail(r.FormValue("email"))
+	if !emailOK {
+		user.Email = strings.TrimSpace(r.FormValue("email"))
+		h.render(w, r, "user_form.html", map[string]interface{}{"User": user, "IsNew": false, "Error": T(r, "admin.error.invalid_email"), "CurrentUser": currentUser})
+		return
+	}
+	user.Email = email
 
 	if password := r.FormValue("password"); password != "" {
 		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
-			http.Error(w, "Interní chyba serveru", 500)
+			h.Errors.InternalError(w, r, err)
 			return
 		}
 		user.PasswordHash = string(hash)
@@ -420,9 +1328,10 @@ func (h *AdminHandler) Users_Update(w http.ResponseWriter, r *http.Request) {
 
 	if err := h.Users.Update(user); err != nil {
 		log.Printf("error updating user: %v", err)
-		h.render(w, "user_form.html", map[string]interface{}{"User": user, "IsNew": false, "Error": "Nepodařilo se aktualizovat uživatele.", "CurrentUser": CurrentUser(r)})
+		h.render(w, r, "user_form.html", map[string]interface{}{"User": user, "IsNew": false, "Error": T(r, "admin.error.user_update"), "CurrentUser": currentUser})
 		return
 	}
+	h.Events.Publish(events.UserChanged{User: user})
 
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
@@ -431,22 +1340,26 @@ func (h *AdminHandler) Users_Delete(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
 	currentUser := CurrentUser(r)
 	if currentUser != nil && currentUser.ID == id {
-		http.Error(w, "Nemůžete smazat sami sebe", http.StatusBadRequest)
+		h.Errors.Error(w, r, http.StatusBadRequest, T(r, "admin.error.delete_self"))
 		return
 	}
 	target, err := h.Users.GetByID(id)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 	if target.IsAdmin {
 		firstAdminID, _ := h.Users.GetFirstAdminID()
 		if currentUser == nil || currentUser.ID != firstAdminID {
-			http.Error(w, "Iba pôvodný administrátor môže mazať účty iných administrátorov.", http.StatusForbidden)
+			h.Errors.Error(w, r, http.StatusForbidden, T(r, "admin.error.initial_admin_delete"))
 			return
 		}
 	}
-	h.Users.Delete(id)
+	if err := h.Users.Delete(id); err != nil {
+		log.Printf("error deleting user: %v", err)
+	} else {
+		h.Events.Publish(events.UserChanged{User: target, Deleted: true})
+	}
 	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
@@ -455,7 +1368,7 @@ func (h *AdminHandler) Users_Delete(w http.ResponseWriter, r *http.Request) {
 func (h *AdminHandler) Profile_Show(w http.ResponseWriter, r *http.Request) {
 	user := CurrentUser(r)
 	saved := r.URL.Query().Get("saved") == "true"
-	h.render(w, "profile.html", map[string]interface{}{"User": user, "Saved": saved, "CurrentUser": user})
+	h.renderProfile(w, r, map[string]interface{}{"User": user, "Saved": saved, "CurrentUser": user})
 }
 
 func (h *AdminHandler) Profile_Update(w http.ResponseWriter, r *http.Request) {
@@ -469,17 +1382,30 @@ func (h *AdminHandler) Profile_Update(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.Users.GetByID(currentUser.ID)
 	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, err)
 		return
 	}
 
 	user.Name = strings.TrimSpace(r.FormValue("name"))
 	user.Surname = strings.TrimSpace(r.FormValue("surname"))
+	user.NotifyComments = r.FormValue("notify_comments") == "on"
+	// An empty language follows the site; unknown ones are ignored.
+	if lang := r.FormValue("language"); lang == "" || h.Locales.Bundle.Has(lang) {
+		user.Language = lang
+	}
+	email, emailOK := parseEmail(r.FormValue("email"))
+	if !emailOK {
+		user.Email = strings.TrimSpace(r.FormValue("email"))
+		h.renderProfile(w, r, map[string]interface{}{"User": user, "Error": T(r, "admin.error.invalid_email"), "CurrentUser": user})
+		return
+	}
+	user.Email = email
 
-	if password := r.FormValue("password"); password != "" {
+	password := r.FormValue("password")
+	if password != "" {
 		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
-			http.Error(w, "Interní chyba serveru", 500)
+			h.Errors.InternalError(w, r, err)
 			return
 		}
 		user.PasswordHash = string(hash)
@@ -487,23 +1413,143 @@ func (h *AdminHandler) Profile_Update(w http.ResponseWriter, r *http.Request) {
 
 	if err := h.Users.Update(user); err != nil {
 		log.Printf("error updating profile: %v", err)
-		h.render(w, "profile.html", map[string]interface{}{"User": user, "Error": "Nepodařilo se aktualizovat profil.", "CurrentUser": user})
+		h.renderProfile(w, r, map[string]interface{}{"User": user, "Error": T(r, "admin.error.profile_update"), "CurrentUser": user})
 		return
 	}
+	h.Events.Publish(events.UserChanged{User: user})
 
+	// The session is tied to the password hash, so a new password ends
+	// this session as well as any other.
+	if password != "" {
+		http.Redirect(w, r, "/admin/login?password=changed", http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/admin/profile?saved=true", http.StatusSeeOther)
 }
 
-func (h *AdminHandler) render(w http.ResponseWriter, name string, data interface{}) {
-	t, ok := h.Templates[name]
-	if !ok {
-		log.Printf("admin template not found: %s", name)
-		http.Error(w, "Interní chyba serveru", 500)
+// apiTokenLifetimes are the expiry choices offered for new API tokens, in
+// days; 0 means the token never expires.
+var apiTokenLifetimes = []int{30, 90, 365, 0}
+
+// apiScopeLabels are the catalog keys describing the API token scopes on
+// the profile page.
+var apiScopeLabels = map[string]string{
+	models.ScopeArticles:  "api_scope.articles",
+	models.ScopeGalleries: "api_scope.galleries",
+	models.ScopeComments:  "api_scope.comments",
+}
+
+// Profile_CreateToken issues a personal access token for the admin API. The
+// token is shown only in this response; afterwards just its start is known.
+func (h *AdminHandler) Profile_CreateToken(w http.ResponseWriter, r *http.Request) {
+	user := CurrentUser(r)
+	r.ParseForm()
+
+	token := &models.APIToken{UserID: user.ID, Name: strings.TrimSpace(r.FormValue("name"))}
+	for _, scope := range models.APIScopes {
+		if r.Form.Get("scope_"+scope) == "on" {
+			token.Scopes = append(token.Scopes, scope)
+		}
+	}
+	days, err := strconv.Atoi(r.FormValue("expires"))
+	if err != nil {
+		days = -1
+	}
+
+	data := map[string]interface{}{"User": user, "CurrentUser": user}
+	switch {
+	case token.Name == "":
+		data["TokenError"] = T(r, "admin.error.token_name")
+	case len(token.Name) > 100:
+		data["TokenError"] = T(r, "admin.error.token_name_length")
+	case len(token.Scopes) == 0:
+		data["TokenError"] = T(r, "admin.error.token_scopes")
+	case !slices.Contains(apiTokenLifetimes, days):
+		data["TokenError"] = T(r, "admin.error.token_lifetime")
+	}
+	if data["TokenError"] != nil {
+		h.renderProfile(w, r, data)
+		return
+	}
+
+	plain, err := h.APITokens.Create(token, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		h.Errors.InternalError(w, r, err)
+		return
+	}
+	data["NewToken"] = plain
+	data["NewTokenName"] = token.Name
+	h.renderProfile(w, r, data)
+}
+
+func (h *AdminHandler) Profile_DeleteToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err := h.APITokens.Delete(CurrentUser(r).ID, id); err != nil {
+		h.Errors.InternalError(w, r, err)
 		return
 	}
-	err := t.ExecuteTemplate(w, name, data)
+	http.Redirect(w, r, "/admin/profile#api-tokens", http.StatusSeeOther)
+}
+
+// renderProfile adds the user's API tokens to the profile page data.
+func (h *AdminHandler) renderProfile(w http.ResponseWriter, r *http.Request, data map[string]interface{}) {
+	tokens, err := h.APITokens.GetByUser(CurrentUser(r).ID)
 	if err != nil {
-		log.Printf("admin template error (%s): %v", name, err)
-		http.Error(w, "Interní chyba serveru", 500)
+		log.Printf("error loading api tokens: %v", err)
+	}
+	data["APITokens"] = tokens
+	data["APIScopes"] = models.APIScopes
+	data["APIScopeLabels"] = apiScopeLabels
+	data["TokenLifetimes"] = apiTokenLifetimes
+	data["Languages"] = h.Locales.Bundle.Languages()
+	data["SiteLanguageName"] = h.Locales.Bundle.Localizer(h.Locales.Site()).T("language.name")
+	data["Now"] = time.Now()
+	h.render(w, r, "profile.html", data)
+}
+
+// parseEmail normalizes an optional email address from a form. An empty
+// value is valid and means the user gets no email.
+func parseEmail(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", true
+	}
+	addr, err := netmail.ParseAddress(value)
+	if err != nil || addr.Name != "" {
+		return "", false
+	}
+	return addr.Address, true
+}
+
+// markGone records the public URL of deleted content so that it answers
+// 410 Gone from now on.
+func (h *AdminHandler) markGone(path string) {
+	if err := h.GonePaths.Add(path); err != nil {
+		log.Printf("error recording gone path %s: %v", path, err)
+	}
+}
+
+// unmarkGone forgets a gone URL that new content has taken over.
+func (h *AdminHandler) unmarkGone(path string) {
+	if err := h.GonePaths.Remove(path); err != nil {
+		log.Printf("error forgetting gone path %s: %v", path, err)
+	}
+}
+
+func (h *AdminHandler) render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	t, ok := h.Templates.Lookup(r, name)
+	if !ok {
+		h.Errors.InternalError(w, r, fmt.Errorf("admin template not found: %s", name))
+		return
+	}
+	if m, ok := data.(map[string]interface{}); ok {
+		m["Version"] = h.Version
+		m["Nonce"] = security.Nonce(r)
+	}
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("admin template error (%s): %w", name, err))
+		return
 	}
+	buf.WriteTo(w)
 }
@@ -0,0 +1,738 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lukas-pastva/web-charon/internal/events"
+	"github.com/lukas-pastva/web-charon/internal/models"
+)
+
+const apiTokenContextKey contextKey = "api_token"
+
+// maxAPIBody caps JSON request bodies; photos go through multipart uploads.
+const maxAPIBody = 1 << 20
+
+// AdminAPIHandler serves the admin JSON API under /api/v1/admin. Requests
+// authenticate with a personal access token and act as its owner through
+// the same stores and rules as the HTML administration.
+type AdminAPIHandler struct {
+	Admin  *AdminHandler
+	Public *APIHandler // shared representations of articles and galleries
+	Tokens *models.APITokenStore
+}
+
+// --- Response types ---
+
+type apiAdminArticle struct {
+	apiArticle
+	Published       bool      `json:"published"`
+	CommentsEnabled bool      `json:"comments_enabled"`
+	CommentsClosed  bool      `json:"comments_closed"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type apiAdminGallery struct {
+	apiGallery
+	CommentsEnabled bool            `json:"comments_enabled"`
+	CommentsClosed  bool            `json:"comments_closed"`
+	Images          []apiAdminImage `json:"images"`
+}
+
+type apiAdminImage struct {
+	apiImage
+	AltText string `json:"alt_text"`
+}
+
+type apiUploadResult struct {
+	Images     []apiAdminImage `json:"images"`
+	Duplicates []string        `json:"duplicates"` // already stored content
+	Failed     []string        `json:"failed"`     // rejected files
+}
+
+type apiAdminComment struct {
+	ID         int64           `json:"id"`
+	Status     string          `json:"status"`
+	Target     apiCommentOwner `json:"target"`
+	ParentID   *int64          `json:"parent_id"`
+	AuthorName string          `json:"author_name"`
+	Official   bool            `json:"official"`
+	Content    string          `json:"content"`
+	SpamReason string          `json:"spam_reason,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+type apiCommentOwner struct {
+	Kind  string `json:"kind"` // article, gallery or image
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// --- Request types ---
+
+// Absent fields are left unchanged by PATCH requests.
+type apiArticleInput struct {
+	Title           *string `json:"title"`
+	Content         *string `json:"content"`
+	Excerpt         *string `json:"excerpt"`
+	Published       *bool   `json:"published"`
+	CommentsEnabled *bool   `json:"comments_enabled"`
+	CommentsClosed  *bool   `json:"comments_closed"`
+}
+
+type apiGalleryInput struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	// ArticleID links the gallery to an article; 0 removes the link.
+	ArticleID       *int64 `json:"article_id"`
+	CommentsEnabled *bool  `json:"comments_enabled"`
+	CommentsClosed  *bool  `json:"comments_closed"`
+}
+
+type apiImageInput struct {
+	Caption *string `json:"caption"`
+	AltText *string `json:"alt_text"`
+}
+
+type apiReplyInput struct {
+	Content string `json:"content"`
+}
+
+// --- Authentication ---
+
+// RequireToken authenticates the bearer token and puts its owner in the
+// request context, where CurrentUser finds it as for a logged-in session.
+func (h *AdminAPIHandler) RequireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || strings.TrimSpace(raw) == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="charon"`)
+			writeAPIError(w, r, http.StatusUnauthorized, T(r, "api.error.token_missing"))
+			return
+		}
+		token, err := h.Tokens.Authenticate(strings.TrimSpace(raw))
+		if err != nil && !errors.Is(err, models.ErrTokenInvalid) {
+			apiInternalError(w, r, fmt.Errorf("error checking api token: %w", err))
+			return
+		}
+		var user *models.User
+		if err == nil {
+			user, err = h.Admin.Users.GetByID(token.UserID)
+		}
+		if err != nil || user.InvitePending() {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="charon", error="invalid_token"`)
+			writeAPIError(w, r, http.StatusUnauthorized, T(r, "api.error.token_invalid"))
+			return
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		ctx = context.WithValue(ctx, apiTokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireScope refuses tokens that were not granted scope.
+func (h *AdminAPIHandler) RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, _ := r.Context().Value(apiTokenContextKey).(*models.APIToken)
+			if token == nil || !token.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="charon", error="insufficient_scope", scope=%q`, scope))
+				writeAPIError(w, r, http.StatusForbidden, T(r, "api.error.scope", "scope", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// --- Articles ---
+
+func (h *AdminAPIHandler) Articles_List(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := parseAPIPage(w, r)
+	if !ok {
+		return
+	}
+	articles, err := h.Admin.Articles.GetAll()
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error listing articles: %w", err))
+		return
+	}
+	start := min((page-1)*perPage, len(articles))
+	end := min(start+perPage, len(articles))
+	data := make([]apiAdminArticle, 0, end-start)
+	for _, a := range articles[start:end] {
+		data = append(data, h.article(&a))
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiList{Data: data, Pagination: apiPageInfo(h.Public.BaseURL, r, page, perPage, len(articles))})
+}
+
+func (h *AdminAPIHandler) Article_Show(w http.ResponseWriter, r *http.Request) {
+	article, ok := h.loadArticle(w, r)
+	if !ok {
+		return
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiItem{Data: h.article(article)})
+}
+
+// Articles_Create mirrors AdminHandler.Articles_Create: the slug comes from
+// the title. Comments are enabled unless the request says otherwise.
+func (h *AdminAPIHandler) Articles_Create(w http.ResponseWriter, r *http.Request) {
+	var in apiArticleInput
+	if !decodeAPIBody(w, r, &in) {
+		return
+	}
+	article := &models.Article{CommentsEnabled: true}
+	in.apply(article)
+	article.Slug = Slugify(article.Title)
+	if article.Title == "" || article.Slug == "" {
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.article_title"))
+		return
+	}
+	if _, err := h.Admin.Articles.GetBySlug(article.Slug); err == nil {
+		writeAPIError(w, r, http.StatusConflict, T(r, "api.error.article_slug"))
+		return
+	}
+	if err := h.Admin.Articles.Create(article); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error creating article: %w", err))
+		return
+	}
+	h.Admin.unmarkGone("/articles/" + article.Slug)
+
+	created, err := h.Admin.Articles.GetByID(article.ID)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error loading article: %w", err))
+		return
+	}
+	h.Admin.Events.Publish(events.ArticleSaved{Article: created, Created: true, FirstPublished: created.Published})
+	w.Header().Set("Location", "/api/v1/admin/articles/"+strconv.FormatInt(created.ID, 10))
+	writeAPIJSON(w, r, http.StatusCreated, apiItem{Data: h.article(created)})
+}
+
+func (h *AdminAPIHandler) Articles_Update(w http.ResponseWriter, r *http.Request) {
+	article, ok := h.loadArticle(w, r)
+	if !ok {
+		return
+	}
+	var in apiArticleInput
+	if !decodeAPIBody(w, r, &in) {
+		return
+	}
+	wasPublished := article.PublishedAt != nil
+	in.apply(article)
+	if article.Title == "" {
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.article_title"))
+		return
+	}
+	if err := h.Admin.Articles.Update(article); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error updating article: %w", err))
+		return
+	}
+	updated, err := h.Admin.Articles.GetByID(article.ID)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error loading article: %w", err))
+		return
+	}
+	h.Admin.Events.Publish(events.ArticleSaved{Article: updated, FirstPublished: updated.Published && !wasPublished})
+	writeAPIJSON(w, r, http.StatusOK, apiItem{Data: h.article(updated)})
+}
+
+func (h *AdminAPIHandler) Articles_Delete(w http.ResponseWriter, r *http.Request) {
+	article, ok := h.loadArticle(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Admin.Articles.Delete(article.ID); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error deleting article: %w", err))
+		return
+	}
+	h.Admin.releaseUpload(article.CoverImage)
+	if article.PublishedAt != nil {
+		h.Admin.markGone("/articles/" + article.Slug)
+	}
+	h.Admin.Events.Publish(events.ArticleDeleted{Article: article})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminAPIHandler) loadArticle(w http.ResponseWriter, r *http.Request) (*models.Article, bool) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	article, err := h.Admin.Articles.GetByID(id)
+	return article, found(w, r, err, T(r, "api.error.article_not_found"))
+}
+
+func (in apiArticleInput) apply(a *models.Article) {
+	if in.Title != nil {
+		a.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Content != nil {
+		a.Content = *in.Content
+	}
+	if in.Excerpt != nil {
+		a.Excerpt = strings.TrimSpace(*in.Excerpt)
+	}
+	if in.Published != nil {
+		a.Published = *in.Published
+	}
+	if in.CommentsEnabled != nil {
+		a.CommentsEnabled = *in.CommentsEnabled
+	}
+	if in.CommentsClosed != nil {
+		a.CommentsClosed = *in.CommentsClosed
+	}
+}
+
+func (h *AdminAPIHandler) article(a *models.Article) apiAdminArticle {
+	out := apiAdminArticle{
+		apiArticle:      h.Public.article(*a),
+		Published:       a.Published,
+		CommentsEnabled: a.CommentsEnabled,
+		CommentsClosed:  a.CommentsClosed,
+		CreatedAt:       a.CreatedAt,
+	}
+	out.Content = a.Content
+	return out
+}
+
+// --- Galleries ---
+
+func (h *AdminAPIHandler) Galleries_List(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := parseAPIPage(w, r)
+	if !ok {
+		return
+	}
+	galleries, total, err := h.Admin.Galleries.GetPaginated(perPage, (page-1)*perPage)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error listing galleries: %w", err))
+		return
+	}
+	data := make([]apiAdminGallery, 0, len(galleries))
+	for _, g := range galleries {
+		images, err := h.Admin.Galleries.GetImages(g.ID)
+		if err != nil {
+			apiInternalError(w, r, fmt.Errorf("error loading gallery images: %w", err))
+			return
+		}
+		g.Images = images
+		data = append(data, h.gallery(&g))
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiList{Data: data, Pagination: apiPageInfo(h.Public.BaseURL, r, page, perPage, total)})
+}
+
+func (h *AdminAPIHandler) Gallery_Show(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := h.loadGallery(w, r)
+	if !ok {
+		return
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiItem{Data: h.gallery(gallery)})
+}
+
+func (h *AdminAPIHandler) Galleries_Create(w http.ResponseWriter, r *http.Request) {
+	var in apiGalleryInput
+	if !decodeAPIBody(w, r, &in) {
+		return
+	}
+	gallery := &models.Gallery{CommentsEnabled: true}
+	if !h.applyGallery(w, r, in, gallery) {
+		return
+	}
+	gallery.Slug = Slugify(gallery.Title)
+	if gallery.Title == "" || gallery.Slug == "" {
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.gallery_title"))
+		return
+	}
+	if _, err := h.Admin.Galleries.GetBySlug(gallery.Slug); err == nil {
+		writeAPIError(w, r, http.StatusConflict, T(r, "api.error.gallery_slug"))
+		return
+	}
+	if err := h.Admin.Galleries.Create(gallery); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error creating gallery: %w", err))
+		return
+	}
+	h.Admin.unmarkGone("/gallery/" + gallery.Slug)
+
+	created, err := h.Admin.Galleries.GetByID(gallery.ID)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error loading gallery: %w", err))
+		return
+	}
+	h.Admin.Events.Publish(events.GallerySaved{Gallery: created, Created: true})
+	w.Header().Set("Location", "/api/v1/admin/galleries/"+strconv.FormatInt(created.ID, 10))
+	writeAPIJSON(w, r, http.StatusCreated, apiItem{Data: h.gallery(created)})
+}
+
+func (h *AdminAPIHandler) Galleries_Update(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := h.loadGallery(w, r)
+	if !ok {
+		return
+	}
+	previousArticleID := gallery.ArticleID
+	var in apiGalleryInput
+	if !decodeAPIBody(w, r, &in) || !h.applyGallery(w, r, in, gallery) {
+		return
+	}
+	if gallery.Title == "" {
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.gallery_title"))
+		return
+	}
+	if err := h.Admin.Galleries.Update(gallery); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error updating gallery: %w", err))
+		return
+	}
+	updated, err := h.Admin.Galleries.GetByID(gallery.ID)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error loading gallery: %w", err))
+		return
+	}
+	h.Admin.Events.Publish(events.GallerySaved{Gallery: updated, PreviousArticleID: previousArticleID})
+	writeAPIJSON(w, r, http.StatusOK, apiItem{Data: h.gallery(updated)})
+}
+
+func (h *AdminAPIHandler) Galleries_Delete(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := h.loadGallery(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Admin.Galleries.Delete(gallery.ID); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error deleting gallery: %w", err))
+		return
+	}
+	for _, img := range gallery.Images {
+		h.Admin.releaseUpload(img.Filename)
+	}
+	h.Admin.markGone("/gallery/" + gallery.Slug)
+	h.Admin.Events.Publish(events.GalleryDeleted{Gallery: gallery})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Galleries_UploadImages adds the photos sent as multipart "images" fields,
+// like the upload form in the administration.
+func (h *AdminAPIHandler) Galleries_UploadImages(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := h.loadGallery(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.multipart"))
+		return
+	}
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.multipart"))
+		return
+	}
+
+	result := apiUploadResult{Duplicates: []string{}, Failed: []string{}}
+	var added []int64
+	for _, fh := range files {
+		m, duplicate, err := HandleUploadFromFileHeader(fh, h.Admin.Storage, h.Admin.Media)
+		if err != nil {
+			log.Printf("upload error: %v", err)
+			result.Failed = append(result.Failed, fh.Filename)
+			continue
+		}
+		if duplicate {
+			result.Duplicates = append(result.Duplicates, m.Filename)
+		}
+		img := &models.Image{GalleryID: gallery.ID, Filename: m.Filename}
+		if err := h.Admin.Galleries.AddImage(img); err != nil {
+			log.Printf("error adding image: %v", err)
+			h.Admin.releaseUpload(m.Filename)
+			result.Failed = append(result.Failed, fh.Filename)
+			continue
+		}
+		h.Admin.Events.Publish(events.ImageUploaded{Gallery: gallery, Image: img})
+		added = append(added, img.ID)
+	}
+
+	updated, err := h.Admin.Galleries.GetByID(gallery.ID)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error loading gallery: %w", err))
+		return
+	}
+	result.Images = []apiAdminImage{}
+	for i, img := range updated.Images {
+		for _, id := range added {
+			if img.ID == id {
+				result.Images = append(result.Images, h.image(updated, i))
+			}
+		}
+	}
+	status := http.StatusCreated
+	if len(added) == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+	writeAPIJSON(w, r, status, apiItem{Data: result})
+}
+
+func (h *AdminAPIHandler) Images_Update(w http.ResponseWriter, r *http.Request) {
+	gallery, index, ok := h.loadImage(w, r)
+	if !ok {
+		return
+	}
+	var in apiImageInput
+	if !decodeAPIBody(w, r, &in) {
+		return
+	}
+	img := &gallery.Images[index]
+	if in.Caption != nil {
+		img.Caption = strings.TrimSpace(*in.Caption)
+	}
+	if in.AltText != nil {
+		img.AltText = strings.TrimSpace(*in.AltText)
+	}
+	if err := h.Admin.Galleries.UpdateImage(img); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error updating image: %w", err))
+		return
+	}
+	h.Admin.Events.Publish(events.ImageChanged{Image: img})
+	writeAPIJSON(w, r, http.StatusOK, apiItem{Data: h.image(gallery, index)})
+}
+
+func (h *AdminAPIHandler) Images_Delete(w http.ResponseWriter, r *http.Request) {
+	gallery, index, ok := h.loadImage(w, r)
+	if !ok {
+		return
+	}
+	img := &gallery.Images[index]
+	if err := h.Admin.Galleries.DeleteImage(img.ID); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error deleting image: %w", err))
+		return
+	}
+	h.Admin.releaseUpload(img.Filename)
+	h.Admin.markGone(photoPath(gallery, img))
+	h.Admin.Events.Publish(events.ImageChanged{Image: img, Deleted: true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminAPIHandler) loadGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, bool) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	gallery, err := h.Admin.Galleries.GetByID(id)
+	return gallery, found(w, r, err, T(r, "api.error.gallery_not_found"))
+}
+
+// loadImage finds the photo named in the URL together with its gallery.
+func (h *AdminAPIHandler) loadImage(w http.ResponseWriter, r *http.Request) (*models.Gallery, int, bool) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	img, err := h.Admin.Galleries.GetImageByID(id)
+	if !found(w, r, err, T(r, "api.error.photo_not_found")) {
+		return nil, 0, false
+	}
+	gallery, err := h.Admin.Galleries.GetByID(img.GalleryID)
+	if !found(w, r, err, T(r, "api.error.photo_not_found")) {
+		return nil, 0, false
+	}
+	for i := range gallery.Images {
+		if gallery.Images[i].ID == id {
+			return gallery, i, true
+		}
+	}
+	writeAPIError(w, r, http.StatusNotFound, T(r, "api.error.photo_not_found"))
+	return nil, 0, false
+}
+
+// applyGallery copies the request fields onto g, answering 400 for a
+// missing article.
+func (h *AdminAPIHandler) applyGallery(w http.ResponseWriter, r *http.Request, in apiGalleryInput, g *models.Gallery) bool {
+	if in.Title != nil {
+		g.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Description != nil {
+		g.Description = *in.Description
+	}
+	if in.CommentsEnabled != nil {
+		g.CommentsEnabled = *in.CommentsEnabled
+	}
+	if in.CommentsClosed != nil {
+		g.CommentsClosed = *in.CommentsClosed
+	}
+	if in.ArticleID != nil {
+		g.ArticleID = nil
+		if id := *in.ArticleID; id > 0 {
+			if _, err := h.Admin.Articles.GetByID(id); err != nil {
+				writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.article_id"))
+				return false
+			}
+			g.ArticleID = &id
+		}
+	}
+	return true
+}
+
+func (h *AdminAPIHandler) gallery(g *models.Gallery) apiAdminGallery {
+	out := apiAdminGallery{
+		apiGallery:      h.Public.gallery(g),
+		CommentsEnabled: g.CommentsEnabled,
+		CommentsClosed:  g.CommentsClosed,
+		Images:          make([]apiAdminImage, 0, len(g.Images)),
+	}
+	for i := range g.Images {
+		out.Images = append(out.Images, h.image(g, i))
+	}
+	return out
+}
+
+func (h *AdminAPIHandler) image(g *models.Gallery, i int) apiAdminImage {
+	return apiAdminImage{apiImage: h.Public.image(g, i), AltText: g.Images[i].AltText}
+}
+
+// --- Comments ---
+
+// Comments_List returns the moderation queue: pending comments by default,
+// or those with ?status=approved or ?status=spam.
+func (h *AdminAPIHandler) Comments_List(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := parseAPIPage(w, r)
+	if !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.CommentPending
+	case models.CommentPending, models.CommentApproved, models.CommentSpam:
+	default:
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.status"))
+		return
+	}
+	comments, total, err := h.Admin.Comments.Search(models.CommentFilter{Status: status}, perPage, (page-1)*perPage)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error listing comments: %w", err))
+		return
+	}
+	data := make([]apiAdminComment, 0, len(comments))
+	for i := range comments {
+		data = append(data, h.comment(&comments[i]))
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiList{Data: data, Pagination: apiPageInfo(h.Public.BaseURL, r, page, perPage, total)})
+}
+
+func (h *AdminAPIHandler) Comments_Approve(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, h.Admin.Comments.Approve)
+}
+
+func (h *AdminAPIHandler) Comments_Spam(w http.ResponseWriter, r *http.Request) {
+	h.moderate(w, r, h.Admin.Comments.MarkSpam)
+}
+
+func (h *AdminAPIHandler) Comments_Delete(w http.ResponseWriter, r *http.Request) {
+	comment, ok := h.loadComment(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Admin.Comments.Delete(comment.ID); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error deleting comment: %w", err))
+		return
+	}
+	h.Admin.Events.Publish(events.CommentsModerated{IDs: []int64{comment.ID}, Paths: []string{comment.TargetURL()}})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Comments_Reply posts an organizer reply as the token's owner, approving
+// the comment being answered.
+func (h *AdminAPIHandler) Comments_Reply(w http.ResponseWriter, r *http.Request) {
+	parent, ok := h.loadComment(w, r)
+	if !ok {
+		return
+	}
+	var in apiReplyInput
+	if !decodeAPIBody(w, r, &in) {
+		return
+	}
+	content := strings.TrimSpace(in.Content)
+	if content == "" {
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.reply_content"))
+		return
+	}
+	reply, err := h.Admin.postReply(parent, CurrentUser(r), content)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error creating reply: %w", err))
+		return
+	}
+	created, err := h.Admin.Comments.GetForModeration(reply.ID)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error loading reply: %w", err))
+		return
+	}
+	writeAPIJSON(w, r, http.StatusCreated, apiItem{Data: h.comment(created)})
+}
+
+// moderate applies a status change and returns the updated comment.
+func (h *AdminAPIHandler) moderate(w http.ResponseWriter, r *http.Request, action func(id int64) error) {
+	comment, ok := h.loadComment(w, r)
+	if !ok {
+		return
+	}
+	if err := action(comment.ID); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error moderating comment: %w", err))
+		return
+	}
+	h.Admin.Events.Publish(events.CommentsModerated{IDs: []int64{comment.ID}, Paths: []string{comment.TargetURL()}})
+	updated, err := h.Admin.Comments.GetForModeration(comment.ID)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error loading comment: %w", err))
+		return
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiItem{Data: h.comment(updated)})
+}
+
+func (h *AdminAPIHandler) loadComment(w http.ResponseWriter, r *http.Request) (*models.Comment, bool) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	comment, err := h.Admin.Comments.GetForModeration(id)
+	return comment, found(w, r, err, T(r, "api.error.comment_not_found"))
+}
+
+func (h *AdminAPIHandler) comment(c *models.Comment) apiAdminComment {
+	t := c.Target()
+	return apiAdminComment{
+		ID:     c.ID,
+		Status: c.Status,
+		Target: apiCommentOwner{
+			Kind:  t.Kind,
+			ID:    t.ID,
+			Title: c.TargetTitle,
+			URL:   h.Public.BaseURL + c.TargetURL(),
+		},
+		ParentID:   c.ParentID,
+		AuthorName: c.AuthorName,
+		Official:   c.IsOfficial(),
+		Content:    c.Content,
+		SpamReason: c.SpamReason,
+		CreatedAt:  c.CreatedAt,
+	}
+}
+
+// --- Helpers ---
+
+// found answers 404 with message for a missing record and 500 for any
+// other lookup error. It reports whether the handler can go on.
+func found(w http.ResponseWriter, r *http.Request, err error, message string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, sql.ErrNoRows):
+		writeAPIError(w, r, http.StatusNotFound, message)
+	default:
+		apiInternalError(w, r, err)
+	}
+	return false
+}
+
+// decodeAPIBody reads a JSON request body into v, answering 400 for
+// malformed JSON or unknown fields.
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.body", "error", err.Error()))
+		return false
+	}
+	return true
+}
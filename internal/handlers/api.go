@@ -0,0 +1,482 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+)
+
+const (
+	apiDefaultPerPage = 10
+	apiMaxPerPage     = 50
+)
+
+// APIHandler serves the public read-only JSON API under /api/v1: published
+// articles, galleries with their photos and approved comments.
+type APIHandler struct {
+	Articles  *models.ArticleStore
+	Galleries *models.GalleryStore
+	Comments  *models.CommentStore
+	Storage   storage.Storage
+	BaseURL   string
+	// CORSOrigins lists the origins browsers may call the API from; "*"
+	// allows any. Empty disables CORS.
+	CORSOrigins []string
+}
+
+// --- Response types ---
+
+type apiList struct {
+	Data       interface{}   `json:"data"`
+	Pagination apiPagination `json:"pagination"`
+}
+
+type apiPagination struct {
+	Page       int    `json:"page"`
+	PerPage    int    `json:"per_page"`
+	Total      int    `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	Next       string `json:"next,omitempty"`
+	Prev       string `json:"prev,omitempty"`
+}
+
+type apiItem struct {
+	Data interface{} `json:"data"`
+}
+
+type apiErrorBody struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type apiArticle struct {
+	ID          int64      `json:"id"`
+	Slug        string     `json:"slug"`
+	Title       string     `json:"title"`
+	URL         string     `json:"url"`
+	Excerpt     string     `json:"excerpt"`
+	Content     string     `json:"content,omitempty"` // plain text, detail only
+	CoverImage  *string    `json:"cover_image"`
+	GallerySlug *string    `json:"gallery_slug,omitempty"`
+	PublishedAt *time.Time `json:"published_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type apiGallery struct {
+	ID          int64      `json:"id"`
+	Slug        string     `json:"slug"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	URL         string     `json:"url"`
+	ArticleID   *int64     `json:"article_id"`
+	CoverImage  *string    `json:"cover_image"`
+	ImageCount  int        `json:"image_count"`
+	Images      []apiImage `json:"images,omitempty"` // detail only
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+type apiImage struct {
+	ID       int64  `json:"id"`
+	URL      string `json:"url"`      // the image file
+	PageURL  string `json:"page_url"` // the photo's page with comments
+	Caption  string `json:"caption"`
+	Alt      string `json:"alt"`
+	Position int    `json:"position"`
+}
+
+type apiComment struct {
+	ID         int64        `json:"id"`
+	ParentID   *int64       `json:"parent_id"`
+	AuthorName string       `json:"author_name"`
+	Official   bool         `json:"official"`
+	Content    string       `json:"content"` // plain text
+	CreatedAt  time.Time    `json:"created_at"`
+	Replies    []apiComment `json:"replies"`
+}
+
+// --- Articles ---
+
+func (h *APIHandler) Articles_List(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := parseAPIPage(w, r)
+	if !ok {
+		return
+	}
+	articles, total, err := h.Articles.GetPublishedPaginated(perPage, (page-1)*perPage)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error listing articles: %w", err))
+		return
+	}
+	data := make([]apiArticle, 0, len(articles))
+	for _, a := range articles {
+		data = append(data, h.article(a))
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiList{Data: data, Pagination: apiPageInfo(h.BaseURL, r, page, perPage, total)})
+}
+
+func (h *APIHandler) Article_Show(w http.ResponseWriter, r *http.Request) {
+	article, ok := h.publishedArticle(w, r)
+	if !ok {
+		return
+	}
+	data := h.article(*article)
+	data.Content = article.Content
+	if g, err := h.Galleries.GetByArticleID(article.ID); err == nil {
+		data.GallerySlug = &g.Slug
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiItem{Data: data})
+}
+
+func (h *APIHandler) Article_Comments(w http.ResponseWriter, r *http.Request) {
+	article, ok := h.publishedArticle(w, r)
+	if !ok {
+		return
+	}
+	h.comments(w, r, models.ArticleTarget(article.ID), article.CommentsEnabled)
+}
+
+func (h *APIHandler) publishedArticle(w http.ResponseWriter, r *http.Request) (*models.Article, bool) {
+	article, err := h.Articles.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		apiInternalError(w, r, fmt.Errorf("error loading article: %w", err))
+		return nil, false
+	}
+	if err != nil || !article.Published {
+		writeAPIError(w, r, http.StatusNotFound, T(r, "api.error.article_not_found"))
+		return nil, false
+	}
+	return article, true
+}
+
+func (h *APIHandler) article(a models.Article) apiArticle {
+	out := apiArticle{
+		ID:          a.ID,
+		Slug:        a.Slug,
+		Title:       a.Title,
+		URL:         h.BaseURL + "/articles/" + a.Slug,
+		Excerpt:     a.Excerpt,
+		PublishedAt: a.PublishedAt,
+		UpdatedAt:   a.UpdatedAt,
+	}
+	if a.CoverImage != "" {
+		u := h.uploadURL(a.CoverImage, a.Published)
+		out.CoverImage = &u
+	}
+	return out
+}
+
+// --- Galleries ---
+
+func (h *APIHandler) Galleries_List(w http.ResponseWriter, r *http.Request) {
+	page, perPage, ok := parseAPIPage(w, r)
+	if !ok {
+		return
+	}
+	galleries, total, err := h.Galleries.GetPaginated(perPage, (page-1)*perPage)
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error listing galleries: %w", err))
+		return
+	}
+	data := make([]apiGallery, 0, len(galleries))
+	for _, g := range galleries {
+		images, err := h.Galleries.GetImages(g.ID)
+		if err != nil {
+			apiInternalError(w, r, fmt.Errorf("error loading gallery images: %w", err))
+			return
+		}
+		g.Images = images
+		data = append(data, h.gallery(&g))
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiList{Data: data, Pagination: apiPageInfo(h.BaseURL, r, page, perPage, total)})
+}
+
+func (h *APIHandler) Gallery_Show(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := h.loadGallery(w, r)
+	if !ok {
+		return
+	}
+	data := h.gallery(gallery)
+	data.Images = make([]apiImage, 0, len(gallery.Images))
+	for i := range gallery.Images {
+		data.Images = append(data.Images, h.image(gallery, i))
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiItem{Data: data})
+}
+
+func (h *APIHandler) Gallery_Comments(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := h.loadGallery(w, r)
+	if !ok {
+		return
+	}
+	h.comments(w, r, models.GalleryTarget(gallery.ID), gallery.CommentsEnabled)
+}
+
+func (h *APIHandler) Photo_Comments(w http.ResponseWriter, r *http.Request) {
+	gallery, ok := h.loadGallery(w, r)
+	if !ok {
+		return
+	}
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	for _, img := range gallery.Images {
+		if img.ID == id {
+			h.comments(w, r, models.ImageTarget(img.ID), gallery.CommentsEnabled)
+			return
+		}
+	}
+	writeAPIError(w, r, http.StatusNotFound, T(r, "api.error.photo_not_found"))
+}
+
+func (h *APIHandler) loadGallery(w http.ResponseWriter, r *http.Request) (*models.Gallery, bool) {
+	gallery, err := h.Galleries.GetBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, r, http.StatusNotFound, T(r, "api.error.gallery_not_found"))
+		return nil, false
+	}
+	if err != nil {
+		apiInternalError(w, r, fmt.Errorf("error loading gallery: %w", err))
+		return nil, false
+	}
+	return gallery, true
+}
+
+func (h *APIHandler) gallery(g *models.Gallery) apiGallery {
+	out := apiGallery{
+		ID:          g.ID,
+		Slug:        g.Slug,
+		Title:       g.Title,
+		Description: g.Description,
+		URL:         h.BaseURL + "/gallery/" + g.Slug,
+		ArticleID:   g.ArticleID,
+		ImageCount:  len(g.Images),
+		CreatedAt:   g.CreatedAt,
+		UpdatedAt:   g.UpdatedAt,
+	}
+	if cover := g.Cover(); cover != nil {
+		u := h.uploadURL(cover.Filename, true)
+		out.CoverImage = &u
+	}
+	return out
+}
+
+// image describes the photo at index i of the gallery.
+func (h *APIHandler) image(g *models.Gallery, i int) apiImage {
+	img := &g.Images[i]
+	return apiImage{
+		ID:       img.ID,
+		URL:      h.uploadURL(img.Filename, true),
+		PageURL:  h.BaseURL + photoPath(g, img),
+		Caption:  img.Caption,
+		Alt:      img.Alt(),
+		Position: i + 1,
+	}
+}
+
+// --- Comments ---
+
+// comments lists the approved comments on a target as reply threads. Pages
+// count top-level threads, so a thread is never split across pages.
+func (h *APIHandler) comments(w http.ResponseWriter, r *http.Request, target models.CommentTarget, enabled bool) {
+	page, perPage, ok := parseAPIPage(w, r)
+	if !ok {
+		return
+	}
+	var threads []*models.Comment
+	if enabled {
+		comments, err := h.Comments.GetByTarget(target, true)
+		if err != nil {
+			apiInternalError(w, r, fmt.Errorf("error loading comments for %s: %w", target, err))
+			return
+		}
+		threads = models.ThreadComments(comments)
+	}
+	total := len(threads)
+	start := min((page-1)*perPage, total)
+	end := min(start+perPage, total)
+	data := make([]apiComment, 0, end-start)
+	for _, c := range threads[start:end] {
+		data = append(data, apiCommentTree(c))
+	}
+	writeAPIJSON(w, r, http.StatusOK, apiList{Data: data, Pagination: apiPageInfo(h.BaseURL, r, page, perPage, total)})
+}
+
+func apiCommentTree(c *models.Comment) apiComment {
+	out := apiComment{
+		ID:         c.ID,
+		ParentID:   c.ParentID,
+		AuthorName: c.AuthorName,
+		Official:   c.IsOfficial(),
+		Content:    c.Content,
+		CreatedAt:  c.CreatedAt,
+		Replies:    make([]apiComment, 0, len(c.Replies)),
+	}
+	for _, reply := range c.Replies {
+		out.Replies = append(out.Replies, apiCommentTree(reply))
+	}
+	return out
+}
+
+// --- Plumbing ---
+
+// CORS lets the configured origins call the API from a browser and answers
+// preflight requests.
+func (h *APIHandler) CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(h.CORSOrigins) > 0 {
+			w.Header().Add("Vary", "Origin")
+		}
+		origin := r.Header.Get("Origin")
+		if allowed := h.allowedOrigin(origin); allowed != "" {
+			w.Header().Set("Access-Control-Allow-Origin", allowed)
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Request-Id")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PATCH, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-None-Match")
+				w.Header().Set("Access-Control-Max-Age", "86400")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *APIHandler) allowedOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, o := range h.CORSOrigins {
+		if o == "*" {
+			return "*"
+		}
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// NotFound and MethodNotAllowed keep unknown API routes in JSON.
+func (h *APIHandler) NotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, r, http.StatusNotFound, T(r, "api.error.not_found"))
+}
+
+func (h *APIHandler) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, r, http.StatusMethodNotAllowed, T(r, "api.error.method"))
+}
+
+// The helpers below are shared by the public and the admin API.
+
+// writeAPIError writes the JSON error body used by every API response that
+// fails.
+func writeAPIError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	body, _ := json.Marshal(apiErrorBody{Error: apiError{
+		Status:    status,
+		Code:      strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+		Message:   message,
+		RequestID: middleware.GetReqID(r.Context()),
+	}})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func apiInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("[%s] %s %s: %v", middleware.GetReqID(r.Context()), r.Method, r.URL.Path, err)
+	writeAPIError(w, r, http.StatusInternalServerError, T(r, "error.internal_plain"))
+}
+
+// writeAPIJSON sends v. Successful reads carry an ETag so clients can
+// revalidate with If-None-Match instead of downloading unchanged content.
+func writeAPIJSON(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		apiInternalError(w, r, fmt.Errorf("error encoding response: %w", err))
+		return
+	}
+	if status == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		serveETag(w, r, "application/json; charset=utf-8", buf.Bytes(), time.Time{})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// parseAPIPage reads ?page= and ?per_page=, answering 400 for bad values.
+func parseAPIPage(w http.ResponseWriter, r *http.Request) (page, perPage int, ok bool) {
+	page, perPage = 1, apiDefaultPerPage
+	q := r.URL.Query()
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.page"))
+			return 0, 0, false
+		}
+		page = n
+	}
+	if v := q.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			writeAPIError(w, r, http.StatusBadRequest, T(r, "api.error.per_page", "max", apiMaxPerPage))
+			return 0, 0, false
+		}
+		perPage = n
+	}
+	return page, perPage, true
+}
+
+// apiPageInfo describes the page with links to its neighbours, which keep
+// any other query parameters such as filters.
+func apiPageInfo(baseURL string, r *http.Request, page, perPage, total int) apiPagination {
+	p := apiPagination{
+		Page:       page,
+		PerPage:    perPage,
+		Total:      total,
+		TotalPages: (total + perPage - 1) / perPage,
+	}
+	link := func(n int) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(n))
+		return baseURL + r.URL.Path + "?" + q.Encode()
+	}
+	if page < p.TotalPages {
+		p.Next = link(page + 1)
+	}
+	if page > 1 {
+		p.Prev = link(min(page-1, max(p.TotalPages, 1)))
+	}
+	return p
+}
+
+// uploadURL returns the address of an uploaded file, which is the public
+// storage address when there is one. Files that are not public, such as
+// covers of drafts in the admin API, stay under /uploads where access is
+// checked.
+func (h *APIHandler) uploadURL(filename string, public bool) string {
+	if !public {
+		return h.BaseURL + "/uploads/" + filename
+	}
+	return storage.AbsoluteURL(h.Storage, h.BaseURL, filename)
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+)
+
+// Limits for ZIP imports. The archive itself is bounded by the request size;
+// the uncompressed limits protect against zip bombs, whose headers may lie
+// about the real entry size.
+const (
+	maxArchiveUpload     = 2 << 30 // 2 GiB request body
+	maxArchiveEntries    = 2000
+	maxArchiveEntrySize  = 64 << 20 // 64 MiB per photo
+	maxArchiveTotalBytes = 4 << 30  // 4 GiB uncompressed
+)
+
+var errArchiveEntryTooLarge = errors.New("archive entry exceeds size limit")
+
+// archiveImportResult summarises a ZIP import.
+type archiveImportResult struct {
+	Imported   int
+	Images     []*models.Image
+	Skipped    []string
+	Duplicates []string
+}
+
+// importArchive extracts every image in the ZIP file into the gallery. Entry
+// names are never used as paths: files are stored under their content hash,
+// so a malicious name cannot escape the storage directory. Such names are
+// rejected anyway to keep the import report honest.
+func importArchive(zr *zip.Reader, galleryID int64, galleries *models.GalleryStore, store storage.Storage, media *models.MediaStore) (*archiveImportResult, error) {
+	if len(zr.File) > maxArchiveEntries {
+		return nil, fmt.Errorf("archive has %d entries, at most %d are allowed", len(zr.File), maxArchiveEntries)
+	}
+
+	files := make([]*zip.File, 0, len(zr.File))
+	for _, f := range zr.File {
+		files = append(files, f)
+	}
+	// Cameras and phones number their photos, so name order is shooting order.
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	result := &archiveImportResult{}
+	var total uint64
+	for _, f := range files {
+		if f.FileInfo().IsDir() || isArchiveJunk(f.Name) {
+			continue
+		}
+		if !safeArchivePath(f.Name) {
+			result.Skipped = append(result.Skipped, f.Name)
+			continue
+		}
+		if !allowedUploadExt[strings.ToLower(filepath.Ext(f.Name))] {
+			result.Skipped = append(result.Skipped, f.Name)
+			continue
+		}
+		if f.UncompressedSize64 > maxArchiveEntrySize {
+			result.Skipped = append(result.Skipped, f.Name)
+			continue
+		}
+		total += f.UncompressedSize64
+		if total > maxArchiveTotalBytes {
+			return result, fmt.Errorf("archive exceeds %d bytes uncompressed", maxArchiveTotalBytes)
+		}
+
+		m, duplicate, err := StoreUpload(store, media, f.Name, func() (io.ReadCloser, error) {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			return &limitedReadCloser{rc: rc, remaining: maxArchiveEntrySize}, nil
+		})
+		if err != nil {
+			log.Printf("archive import: %s: %v", f.Name, err)
+			result.Skipped = append(result.Skipped, f.Name)
+			continue
+		}
+		if duplicate {
+			result.Duplicates = append(result.Duplicates, m.Filename)
+		}
+
+		img := &models.Image{GalleryID: galleryID, Filename: m.Filename}
+		if err := galleries.AddImage(img); err != nil {
+			log.Printf("archive import: add image: %v", err)
+			if err := ReleaseUpload(store, media, m.Filename); err != nil {
+				log.Printf("archive import: %v", err)
+			}
+			result.Skipped = append(result.Skipped, f.Name)
+			continue
+		}
+		result.Imported++
+		result.Images = append(result.Images, img)
+	}
+	return result, nil
+}
+
+// archiveImportNotice is the import summary shown on the gallery form after
+// the redirect.
+type archiveImportNotice struct {
+	Imported     int
+	Skipped      []string
+	SkippedCount int
+	Error        string
+}
+
+func archiveImportNoticeFrom(q url.Values) *archiveImportNotice {
+	if msg := q.Get("import_error"); msg != "" {
+		return &archiveImportNotice{Error: msg}
+	}
+	if !q.Has("imported") {
+		return nil
+	}
+	n := &archiveImportNotice{}
+	n.Imported, _ = strconv.Atoi(q.Get("imported"))
+	if skipped := q.Get("skipped"); skipped != "" {
+		n.Skipped = strings.Split(skipped, ",")
+		n.SkippedCount, _ = strconv.Atoi(q.Get("skipped_count"))
+	}
+	return n
+}
+
+// safeArchivePath rejects absolute paths and parent directory references
+// (the "zip slip" pattern).
+func safeArchivePath(name string) bool {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, ":") {
+		return false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// isArchiveJunk reports metadata files added by desktop archivers.
+func isArchiveJunk(name string) bool {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(base, ".") || base == "Thumbs.db"
+}
+
+// limitedReadCloser fails once more than the allowed number of bytes is read.
+type limitedReadCloser struct {
+	rc        io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errArchiveEntryTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.rc.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errArchiveEntryTooLarge
+	}
+	return n, err
+}
+
+func (l *limitedReadCloser) Close() error {
+	return l.rc.Close()
+}
+
+// writeGalleryArchive streams the gallery's original files as a ZIP archive.
+// Photos are already compressed, so entries are stored without deflating,
+// and each file is copied straight from storage to the response.
+func writeGalleryArchive(w http.ResponseWriter, gallery *models.Gallery, store storage.Storage) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, gallery.Slug))
+
+	zw := zip.NewWriter(w)
+	for i, img := range gallery.Images {
+		name := Slugify(img.Caption)
+		if name == "" {
+			name = "foto"
+		}
+		header := &zip.FileHeader{
+			Name:     fmt.Sprintf("%03d-%s%s", i+1, name, strings.ToLower(filepath.Ext(img.Filename))),
+			Method:   zip.Store,
+			Modified: img.CreatedAt,
+		}
+		rc, _, err := store.Get(img.Filename)
+		if err != nil {
+			log.Printf("gallery archive %s: read %s: %v", gallery.Slug, img.Filename, err)
+			continue
+		}
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			rc.Close()
+			log.Printf("gallery archive %s: %v", gallery.Slug, err)
+			return
+		}
+		_, err = io.Copy(entry, rc)
+		rc.Close()
+		if err != nil {
+			// The client most likely went away; the archive cannot be finished.
+			log.Printf("gallery archive %s: write %s: %v", gallery.Slug, img.Filename, err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("gallery archive %s: %v", gallery.Slug, err)
+	}
+}
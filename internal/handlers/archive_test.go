@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSafeArchivePath(t *testing.T) {
+	tests := []struct {
+		name string
+		safe bool
+	}{
+		{"photo.jpg", true},
+		{"trip/day 1/photo.jpg", true},
+		{"a..b.jpg", true},
+		{"..photo.jpg", true},
+		{"", false},
+		{"../x.jpg", false},
+		{"/abs.jpg", false},
+		{"a/../../b.jpg", false},
+		{"a/..", false},
+		{"..\\x.jpg", false},
+		{"\\abs.jpg", false},
+		{"a\\..\\..\\b.jpg", false},
+		{"C:\\photos\\x.jpg", false},
+		{"C:x.jpg", false},
+	}
+	for _, tt := range tests {
+		if got := safeArchivePath(tt.name); got != tt.safe {
+			t.Errorf("safeArchivePath(%q) = %v, want %v", tt.name, got, tt.safe)
+		}
+	}
+}
+
+// rawEntry is a stored ZIP entry whose header may claim a different size
+// than its content, as in a zip bomb.
+type rawEntry struct {
+	name     string
+	content  string
+	declared uint64
+}
+
+func zipArchive(t *testing.T, entries []rawEntry) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.CreateRaw(&zip.FileHeader{
+			Name:               e.name,
+			Method:             zip.Store,
+			CRC32:              crc32.ChecksumIEEE([]byte(e.content)),
+			CompressedSize64:   uint64(len(e.content)),
+			UncompressedSize64: e.declared,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, e.content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+// The archives below are rejected before anything is stored, so the
+// import runs without a database or storage.
+func TestImportArchiveLimits(t *testing.T) {
+	t.Run("unsafe and oversized entries", func(t *testing.T) {
+		zr := zipArchive(t, []rawEntry{
+			{name: "../x.jpg"},
+			{name: "/abs.jpg"},
+			{name: "a/../../b.jpg"},
+			{name: "..\\evil.jpg"},
+			{name: "notes.txt"},
+			{name: "big.jpg", declared: maxArchiveEntrySize + 1},
+			{name: "__MACOSX/._big.jpg"},
+		})
+		result, err := importArchive(zr, 1, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"../x.jpg", "/abs.jpg", "a/../../b.jpg", "..\\evil.jpg", "notes.txt", "big.jpg"}
+		slices.Sort(want)
+		if got := slices.Sorted(slices.Values(result.Skipped)); !slices.Equal(got, want) || result.Imported != 0 {
+			t.Errorf("imported %d, skipped %v, want none imported and %v skipped", result.Imported, got, want)
+		}
+	})
+
+	t.Run("too many entries", func(t *testing.T) {
+		entries := make([]rawEntry, maxArchiveEntries+1)
+		for i := range entries {
+			entries[i] = rawEntry{name: strconv.Itoa(i) + ".txt"}
+		}
+		if _, err := importArchive(zipArchive(t, entries), 1, nil, nil, nil); err == nil {
+			t.Error("archive with too many entries was accepted")
+		}
+	})
+
+	t.Run("too large in total", func(t *testing.T) {
+		// Each entry claims just under the per-photo limit; their content
+		// does not match, so none of them gets stored.
+		log.SetOutput(io.Discard)
+		t.Cleanup(func() { log.SetOutput(os.Stderr) })
+		n := maxArchiveTotalBytes/maxArchiveEntrySize + 1
+		entries := make([]rawEntry, n)
+		for i := range entries {
+			entries[i] = rawEntry{name: strconv.Itoa(i) + ".jpg", declared: maxArchiveEntrySize}
+		}
+		if _, err := importArchive(zipArchive(t, entries), 1, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "uncompressed") {
+			t.Errorf("archive over the total limit: err = %v", err)
+		}
+	})
+}
+
+// TestLimitedReadCloser checks the guard against entries whose header
+// understates how much they expand to.
+func TestLimitedReadCloser(t *testing.T) {
+	const limit = 1000
+	for _, size := range []int{0, limit - 1, limit} {
+		l := &limitedReadCloser{rc: io.NopCloser(bytes.NewReader(make([]byte, size))), remaining: limit}
+		if data, err := io.ReadAll(l); err != nil || len(data) != size {
+			t.Errorf("%d bytes: read %d, err %v", size, len(data), err)
+		}
+	}
+	for _, size := range []int{limit + 1, 10 * limit} {
+		l := &limitedReadCloser{rc: io.NopCloser(bytes.NewReader(make([]byte, size))), remaining: limit}
+		if data, err := io.ReadAll(l); !errors.Is(err, errArchiveEntryTooLarge) || len(data) > limit+1 {
+			t.Errorf("%d bytes: read %d, err %v, want errArchiveEntryTooLarge", size, len(data), err)
+		}
+	}
+}
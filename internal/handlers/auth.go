@@ -1,19 +1,22 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/lukas-pastva/web-charon/internal/mail"
 	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/security"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -21,10 +24,19 @@ type contextKey string
 
 const userContextKey contextKey = "user"
 
+// signedInCookie marks the browser of a signed-in user outside /admin,
+// where the session cookie is not sent: such browsers skip the page cache
+// and may see uploads that are not public yet.
+const signedInCookie = "charon_nocache"
+
 type AuthHandler struct {
 	Users         *models.UserStore
-	Templates     map[string]*template.Template
+	Tokens        *models.UserTokenStore
+	Mailer        *mail.Mailer
+	Templates     *Templates
 	SessionSecret []byte
+	Errors        *ErrorPages
+	Version       string
 }
 
 // CurrentUser extracts the authenticated user from request context.
@@ -34,7 +46,14 @@ func CurrentUser(r *http.Request) *models.User {
 }
 
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
-	h.renderLogin(w, "")
+	data := map[string]interface{}{}
+	switch r.URL.Query().Get("password") {
+	case "set":
+		data["Notice"] = T(r, "login.password_set")
+	case "changed":
+		data["Notice"] = T(r, "login.password_changed")
+	}
+	h.renderPage(w, r, "login", data)
 }
 
 func (h *AuthHandler) LoginPost(w http.ResponseWriter, r *http.Request) {
@@ -43,17 +62,17 @@ func (h *AuthHandler) LoginPost(w http.ResponseWriter, r *http.Request) {
 
 	user, err := h.Users.GetByNickname(nickname)
 	if err != nil {
-		h.renderLogin(w, "Neplatné uživatelské jméno nebo heslo.")
+		h.renderLogin(w, r, T(r, "login.invalid"))
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
-		h.renderLogin(w, "Neplatné uživatelské jméno nebo heslo.")
+		h.renderLogin(w, r, T(r, "login.invalid"))
 		return
 	}
 
 	value := fmt.Sprintf("%d:%s", user.ID, time.Now().Format(time.RFC3339))
-	sig := h.sign(value)
+	sig := h.sessionSignature(value, user)
 	cookie := &http.Cookie{
 		Name:     "charon_session",
 		Value:    value + "|" + sig,
@@ -63,6 +82,15 @@ func (h *AuthHandler) LoginPost(w http.ResponseWriter, r *http.Request) {
 		MaxAge:   86400 * 7,
 	}
 	http.SetCookie(w, cookie)
+	expires := strconv.FormatInt(time.Now().Add(7*24*time.Hour).Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     signedInCookie,
+		Value:    expires + "|" + h.sign(signedInCookie+"|"+expires),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   86400 * 7,
+	})
 	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
@@ -75,9 +103,32 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteStrictMode,
 		MaxAge:   -1,
 	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     signedInCookie,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
 	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
 }
 
+// SignedIn reports whether the request comes from a browser that signed in
+// to the administration, so editors always see their latest changes.
+func (h *AuthHandler) SignedIn(r *http.Request) bool {
+	cookie, err := r.Cookie(signedInCookie)
+	if err != nil {
+		return false
+	}
+	expires, sig, ok := strings.Cut(cookie.Value, "|")
+	if !ok || !hmac.Equal([]byte(sig), []byte(h.sign(signedInCookie+"|"+expires))) {
+		return false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	return err == nil && time.Now().Unix() < unix
+}
+
 func (h *AuthHandler) RequireAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("charon_session")
@@ -101,7 +152,7 @@ func (h *AuthHandler) RequireAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := CurrentUser(r)
 		if user == nil || !user.IsAdmin {
-			http.Error(w, "Přístup odepřen", http.StatusForbidden)
+			h.Errors.Error(w, r, http.StatusForbidden, T(r, "error.forbidden"))
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -116,9 +167,6 @@ func (h *AuthHandler) userFromSession(raw string) *models.User {
 	}
 	value := raw[:idx]
 	sig := raw[idx+1:]
-	if sig != h.sign(value) {
-		return nil
-	}
 
 	// Extract user ID from "id:timestamp"
 	parts := strings.SplitN(value, ":", 2)
@@ -134,23 +182,178 @@ func (h *AuthHandler) userFromSession(raw string) *models.User {
 	if err != nil {
 		return nil
 	}
+	if !hmac.Equal([]byte(sig), []byte(h.sessionSignature(value, user))) {
+		return nil
+	}
 	return user
 }
 
+// sessionSignature covers the user's password hash as well, so changing or
+// resetting the password logs out every other session.
+func (h *AuthHandler) sessionSignature(value string, user *models.User) string {
+	return h.sign(value + "|" + user.PasswordHash)
+}
+
 func (h *AuthHandler) sign(value string) string {
 	mac := hmac.New(sha256.New, h.SessionSecret)
 	mac.Write([]byte(value))
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
-func (h *AuthHandler) renderLogin(w http.ResponseWriter, errMsg string) {
-	data := map[string]interface{}{
-		"Error": errMsg,
+// --- Password reset and invites ---
+
+const (
+	inviteTTL = 7 * 24 * time.Hour
+	resetTTL  = time.Hour
+	// resetLimit caps reset emails per user and hour so the form cannot be
+	// used to flood someone's inbox.
+	resetLimit = 3
+
+	minPasswordLength = 6
+)
+
+func (h *AuthHandler) Forgot(w http.ResponseWriter, r *http.Request) {
+	h.renderPage(w, r, "forgot", map[string]interface{}{})
+}
+
+// ForgotPost emails a reset link to the account matching the nickname or
+// email address. The response is the same whether or not an account was
+// found, so the form does not reveal who has an account.
+func (h *AuthHandler) ForgotPost(w http.ResponseWriter, r *http.Request) {
+	login := strings.TrimSpace(r.FormValue("login"))
+	if login == "" {
+		h.renderPage(w, r, "forgot", map[string]interface{}{"Error": T(r, "login.forgot_required")})
+		return
 	}
-	t := h.Templates["login.html"]
-	err := t.ExecuteTemplate(w, "login.html", data)
+
+	var users []models.User
+	if strings.Contains(login, "@") {
+		users, _ = h.Users.GetByEmail(login)
+	} else if u, err := h.Users.GetByNickname(login); err == nil {
+		users = append(users, *u)
+	}
+	for i := range users {
+		u := &users[i]
+		if u.Email == "" {
+			continue
+		}
+		if n, err := h.Tokens.CountRecent(u.ID, models.TokenReset, time.Hour); err != nil || n >= resetLimit {
+			log.Printf("password reset for %s throttled", u.Nickname)
+			continue
+		}
+		if err := SendUserToken(h.Tokens, h.Mailer, u, models.TokenReset); err != nil {
+			log.Printf("error sending password reset to %s: %v", u.Nickname, err)
+		}
+	}
+	h.Tokens.DeleteExpired()
+
+	h.renderPage(w, r, "forgot", map[string]interface{}{"Sent": true})
+}
+
+// SetPassword shows the form for choosing a new password from an invite
+// or reset link.
+func (h *AuthHandler) SetPassword(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	t, user, err := h.lookupToken(token)
 	if err != nil {
-		log.Printf("login template error: %v", err)
-		http.Error(w, "Interní chyba serveru", 500)
+		h.renderPage(w, r, "invalid", map[string]interface{}{})
+		return
 	}
+	h.renderPage(w, r, "password", map[string]interface{}{"Token": token, "User": user, "Invite": t.Purpose == models.TokenInvite})
+}
+
+func (h *AuthHandler) SetPasswordPost(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+	t, user, err := h.lookupToken(token)
+	if err != nil {
+		h.renderPage(w, r, "invalid", map[string]interface{}{})
+		return
+	}
+
+	password := r.FormValue("password")
+	errMsg := ""
+	switch {
+	case len([]rune(password)) < minPasswordLength:
+		errMsg = T(r, "login.password_too_short", "count", minPasswordLength)
+	case password != r.FormValue("password_confirm"):
+		errMsg = T(r, "login.password_mismatch")
+	}
+	if errMsg != "" {
+		h.renderPage(w, r, "password", map[string]interface{}{"Token": token, "User": user, "Invite": t.Purpose == models.TokenInvite, "Error": errMsg})
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		h.Errors.InternalError(w, r, err)
+		return
+	}
+	if err := h.Tokens.Consume(t); err != nil {
+		h.renderPage(w, r, "invalid", map[string]interface{}{})
+		return
+	}
+	if err := h.Users.SetPassword(user.ID, string(hash)); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error setting password: %w", err))
+		return
+	}
+	log.Printf("user %s set a new password via %s link", user.Nickname, t.Purpose)
+	http.Redirect(w, r, "/admin/login?password=set", http.StatusSeeOther)
+}
+
+func (h *AuthHandler) lookupToken(token string) (*models.UserToken, *models.User, error) {
+	t, err := h.Tokens.Lookup(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := h.Users.GetByID(t.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, user, nil
+}
+
+// SendUserToken issues an invite or reset token for the user and emails
+// the link to them.
+func SendUserToken(tokens *models.UserTokenStore, mailer *mail.Mailer, user *models.User, purpose string) error {
+	if !mailer.Enabled() {
+		return fmt.Errorf("email is not configured")
+	}
+	if user.Email == "" {
+		return fmt.Errorf("user %s has no email address", user.Nickname)
+	}
+	ttl, name := resetTTL, "password_reset"
+	if purpose == models.TokenInvite {
+		ttl, name = inviteTTL, "invite"
+	}
+	token, err := tokens.Create(user.ID, purpose, ttl)
+	if err != nil {
+		return err
+	}
+	return mailer.QueueSensitive(user, name, map[string]interface{}{
+		"Name":     user.DisplayName(),
+		"Nickname": user.Nickname,
+		"Token":    token,
+		"Days":     int(ttl.Hours() / 24),
+	})
+}
+
+// renderPage renders one of the self-contained pages in login.html: the
+// login form, "forgot", "password" or "invalid".
+func (h *AuthHandler) renderPage(w http.ResponseWriter, r *http.Request, mode string, data map[string]interface{}) {
+	data["Mode"] = mode
+	data["MailEnabled"] = h.Mailer.Enabled()
+	data["MinPasswordLength"] = minPasswordLength
+	data["Version"] = h.Version
+	data["Nonce"] = security.Nonce(r)
+	t, _ := h.Templates.Lookup(r, "login.html")
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, "login.html", data); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("login template error: %w", err))
+		return
+	}
+	buf.WriteTo(w)
+}
+
+func (h *AuthHandler) renderLogin(w http.ResponseWriter, r *http.Request, errMsg string) {
+	h.renderPage(w, r, "login", map[string]interface{}{"Error": errMsg})
 }
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	charon "github.com/lukas-pastva/web-charon"
+	"github.com/lukas-pastva/web-charon/internal/database"
+	"github.com/lukas-pastva/web-charon/internal/i18n"
+	"github.com/lukas-pastva/web-charon/internal/mail"
+	"github.com/lukas-pastva/web-charon/internal/models"
+)
+
+// testDB connects to the MariaDB database in CHARON_TEST_DSN and applies
+// the migrations. Tests needing a database are skipped without it.
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("CHARON_TEST_DSN")
+	if dsn == "" {
+		t.Skip("CHARON_TEST_DSN is not set")
+	}
+	db, err := database.Connect(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := database.Migrate(db, charon.MigrationsFS); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type discardSender struct{}
+
+func (discardSender) Send(*mail.Message) error { return nil }
+
+func TestForgotPostThrottlesResetEmails(t *testing.T) {
+	db := testDB(t)
+	users := &models.UserStore{DB: db}
+	user := &models.User{
+		Nickname: fmt.Sprintf("reset-test-%d", time.Now().UnixNano()),
+		Email:    fmt.Sprintf("reset-test-%d@example.com", time.Now().UnixNano()),
+	}
+	if err := users.Create(user); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM users WHERE id = ?", user.ID)
+		db.Exec("DELETE FROM outbox WHERE to_address = ?", user.Email)
+	})
+
+	bundle, err := i18n.Load(charon.LocalesFS, "locales", "sk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	renderer, err := mail.NewRenderer(charon.MailTemplatesFS, "templates/mail", bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := template.Must(template.New("login.html").Parse("{{.Mode}}"))
+	h := &AuthHandler{
+		Users:     users,
+		Tokens:    &models.UserTokenStore{DB: db},
+		Mailer:    mail.NewMailer(&models.OutboxStore{DB: db}, renderer, discardSender{}, "charon@example.com", "http://localhost"),
+		Templates: &Templates{Sets: map[string]map[string]*template.Template{"sk": {"login.html": login}}, Fallback: "sk"},
+	}
+
+	sent := func() int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM outbox WHERE to_address = ?", user.Email).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	for i := 1; i <= resetLimit+1; i++ {
+		form := url.Values{"login": {user.Nickname}}
+		req := httptest.NewRequest(http.MethodPost, "/admin/forgot", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.ForgotPost(httptest.NewRecorder(), req)
+
+		want := min(i, resetLimit)
+		if got := sent(); got != want {
+			t.Fatalf("after request %d: %d reset emails queued, want %d", i, got, want)
+		}
+	}
+
+	var valid int
+	if err := db.QueryRow("SELECT COUNT(*) FROM user_tokens WHERE user_id = ? AND used_at IS NULL AND expires_at > NOW()", user.ID).Scan(&valid); err != nil {
+		t.Fatal(err)
+	}
+	if valid != 1 {
+		t.Errorf("%d reset links work, want only the latest", valid)
+	}
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lukas-pastva/web-charon/internal/events"
+	"github.com/lukas-pastva/web-charon/internal/models"
+)
+
+const (
+	visitorSaltKey     = "visitor_hash_salt"
+	maxUserAgentLength = 255
+)
+
+// VisitorSalt returns the secret used to hash commenter addresses,
+// creating it on first use. It is stored in the settings so hashes stay
+// comparable across restarts.
+func VisitorSalt(settings *models.SettingsStore) ([]byte, error) {
+	if v, err := settings.Get(visitorSaltKey); err == nil && v != "" {
+		return hex.DecodeString(v)
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if err := settings.Set(visitorSaltKey, hex.EncodeToString(salt)); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// visitorHash identifies a commenter's address without storing it.
+func visitorHash(salt []byte, ip string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+func isVisitorHash(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// ipNetwork truncates an address to its /24 (IPv4) or /48 (IPv6) network,
+// which is enough to ban a troll's provider range but not to identify a
+// household.
+func ipNetwork(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(48, 128)), Mask: net.CIDRMask(48, 128)}).String()
+}
+
+// BanList is the set of comment bans prepared for matching.
+type BanList struct {
+	networks []*net.IPNet
+	visitors map[string]bool
+	words    []string
+	names    []string
+}
+
+// LoadBanList reads all bans from the store.
+func LoadBanList(store *models.CommentBanStore) (*BanList, error) {
+	bans, err := store.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list := &BanList{visitors: make(map[string]bool)}
+	for _, b := range bans {
+		switch b.Kind {
+		case models.BanIP:
+			if n := parseIPRange(b.Pattern); n != nil {
+				list.networks = append(list.networks, n)
+			}
+		case models.BanVisitor:
+			list.visitors[b.Pattern] = true
+		case models.BanWord:
+			list.words = append(list.words, strings.ToLower(b.Pattern))
+		case models.BanName:
+			list.names = append(list.names, strings.ToLower(b.Pattern))
+		}
+	}
+	return list, nil
+}
+
+// Match returns why a submission is banned, or "" when it is not.
+func (b *BanList) Match(ip, visitor, authorName, content string) string {
+	if b.visitors[visitor] {
+		return "ban: visitor"
+	}
+	if parsed := net.ParseIP(ip); parsed != nil {
+		for _, n := range b.networks {
+			if n.Contains(parsed) {
+				return "ban: " + n.String()
+			}
+		}
+	}
+	name := strings.ToLower(strings.TrimSpace(authorName))
+	for _, pattern := range b.names {
+		if matchNamePattern(pattern, name) {
+			return "ban: name " + pattern
+		}
+	}
+	if word := matchBlocklist(b.words, authorName+"\n"+content); word != "" {
+		return "ban: " + word
+	}
+	return ""
+}
+
+// matchNamePattern matches a whole name against a pattern where "*" stands
+// for any text, e.g. "troll*".
+func matchNamePattern(pattern, name string) bool {
+	// path.Match treats "/" specially, which author names may contain.
+	pattern = strings.ReplaceAll(pattern, "/", "?")
+	name = strings.ReplaceAll(name, "/", "?")
+	ok, err := path.Match(escapeNamePattern(pattern), name)
+	return err == nil && ok
+}
+
+// escapeNamePattern keeps only "*" as a wildcard.
+func escapeNamePattern(pattern string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(pattern)
+}
+
+// parseIPRange accepts a single address or a CIDR range.
+func parseIPRange(s string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	bits := 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 32
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
+
+// normalizeBan validates a ban entered in the admin and returns its
+// canonical pattern, or an error code for the ban page.
+func normalizeBan(kind, pattern string) (string, string) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return "", "empty"
+	}
+	switch kind {
+	case models.BanIP:
+		n := parseIPRange(pattern)
+		if n == nil {
+			return "", "invalid_ip"
+		}
+		return n.String(), ""
+	case models.BanWord, models.BanName:
+		return strings.ToLower(pattern), ""
+	}
+	return "", "invalid_kind"
+}
+
+// banErrors maps the error codes of normalizeBan to catalog keys.
+var banErrors = map[string]string{
+	"empty":        "bans.error.empty",
+	"invalid_ip":   "bans.error.invalid_ip",
+	"invalid_kind": "bans.error.invalid_kind",
+}
+
+// ImportLegacyBlocklist moves the words from the old blocklist setting
+// into comment bans.
+func ImportLegacyBlocklist(settings *models.SettingsStore, bans *models.CommentBanStore) error {
+	setting, err := settings.Get(commentBlocklistKey)
+	if err != nil || setting == "" {
+		return nil
+	}
+	for _, word := range ParseBlocklist(setting) {
+		if err := bans.Create(&models.CommentBan{Kind: models.BanWord, Pattern: word}); err != nil {
+			return err
+		}
+	}
+	log.Printf("imported comment blocklist into comment bans")
+	return settings.Set(commentBlocklistKey, "")
+}
+
+// --- Admin ---
+
+func (h *AdminHandler) Bans_List(w http.ResponseWriter, r *http.Request) {
+	bans, err := h.Bans.GetAll()
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error loading comment bans: %w", err))
+		return
+	}
+	byKind := make(map[string][]models.CommentBan)
+	for _, b := range bans {
+		byKind[b.Kind] = append(byKind[b.Kind], b)
+	}
+	errMsg := ""
+	if key, ok := banErrors[r.URL.Query().Get("error")]; ok {
+		errMsg = T(r, key)
+	}
+	h.render(w, r, "bans.html", map[string]interface{}{
+		"Bans":        byKind,
+		"Error":       errMsg,
+		"Saved":       r.URL.Query().Get("saved") == "true",
+		"CurrentUser": CurrentUser(r),
+	})
+}
+
+func (h *AdminHandler) Bans_Create(w http.ResponseWriter, r *http.Request) {
+	kind := r.FormValue("kind")
+	pattern, errCode := normalizeBan(kind, r.FormValue("pattern"))
+	if errCode != "" {
+		http.Redirect(w, r, "/admin/bans?error="+errCode, http.StatusSeeOther)
+		return
+	}
+	ban := &models.CommentBan{Kind: kind, Pattern: pattern, Note: truncate(strings.TrimSpace(r.FormValue("note")), 255)}
+	if err := h.Bans.Create(ban); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error creating comment ban: %w", err))
+		return
+	}
+	http.Redirect(w, r, "/admin/bans?saved=true", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) Bans_Delete(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err := h.Bans.Delete(id); err != nil {
+		log.Printf("error deleting comment ban: %v", err)
+	}
+	http.Redirect(w, r, "/admin/bans", http.StatusSeeOther)
+}
+
+// Comments_Ban blocks the author of a comment, by their hashed address or
+// by their whole network, and moves the comment to spam.
+func (h *AdminHandler) Comments_Ban(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	comment, err := h.Comments.GetByID(id)
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	ban := &models.CommentBan{
+		Kind:    models.BanVisitor,
+		Pattern: comment.IPHash,
+		Note:    truncate(T(r, "bans.visitor_note", "id", comment.ID, "name", comment.AuthorName), 255),
+	}
+	if r.FormValue("scope") == "network" {
+		ban.Kind, ban.Pattern = models.BanIP, comment.IPNetwork
+	}
+	if ban.Pattern == "" {
+		h.Errors.Error(w, r, http.StatusBadRequest, T(r, "bans.error.no_address"))
+		return
+	}
+	if err := h.Bans.Create(ban); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error creating comment ban: %w", err))
+		return
+	}
+	if !comment.IsOfficial() {
+		paths := h.commentPaths([]int64{id})
+		if err := h.Comments.MarkSpam(id); err == nil {
+			h.Events.Publish(events.CommentsModerated{IDs: []int64{id}, Paths: paths})
+		}
+	}
+	http.Redirect(w, r, commentsReturnURL(r), http.StatusSeeOther)
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import "testing"
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // "" when the input is rejected
+	}{
+		{"192.0.2.7", "192.0.2.7/32"},
+		{"192.0.2.0/24", "192.0.2.0/24"},
+		{"192.0.2.7/24", "192.0.2.0/24"},
+		{"::ffff:192.0.2.7", "192.0.2.7/32"},
+		{"::ffff:192.0.2.0/120", "192.0.2.0/24"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8:1::/48", "2001:db8:1::/48"},
+		{"2001:db8:1:2::/48", "2001:db8:1::/48"},
+		{"", ""},
+		{"192.0.2", ""},
+		{"192.0.2.0/33", ""},
+		{"example.com", ""},
+	}
+	for _, tt := range tests {
+		n := parseIPRange(tt.in)
+		got := ""
+		if n != nil {
+			got = n.String()
+		}
+		if got != tt.want {
+			t.Errorf("parseIPRange(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMatchNamePattern(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"troll", "troll", true},
+		{"troll", "trolling", false},
+		{"troll*", "trolling", true},
+		{"troll*", "troll", true},
+		{"*troll*", "the troll king", true},
+		{"*troll", "troll king", false},
+		{"a*b", "a/x/b", true},
+		{"a/b", "a/b", true},
+		{"a/b", "a?b", true},
+		{"*/b", "a/b", true},
+		{"[abc]", "[abc]", true},
+		{"[abc]", "a", false},
+		{"[x", "[x", true},
+		{"x]", "x]", true},
+		{"a?c", "abc", true},
+		{`a\*`, `a\b`, true},
+		{`\`, `\`, true},
+	}
+	for _, tt := range tests {
+		if got := matchNamePattern(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchNamePattern(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBanListMatch(t *testing.T) {
+	visitor := visitorHash([]byte("salt"), "198.51.100.9")
+	list := &BanList{
+		visitors: map[string]bool{visitor: true},
+		words:    []string{"casino"},
+		names:    []string{"troll*", "[admin]"},
+	}
+	for _, s := range []string{"192.0.2.7", "203.0.113.0/24", "2001:db8:1::/48", "::ffff:198.18.0.0/112"} {
+		list.networks = append(list.networks, parseIPRange(s))
+	}
+
+	tests := []struct {
+		desc                         string
+		ip, visitor, author, content string
+		want                         string
+	}{
+		{"clean", "192.0.2.8", "", "Jana", "Pekne fotky", ""},
+		{"banned visitor", "198.51.100.9", visitor, "Jana", "", "ban: visitor"},
+		{"single address", "192.0.2.7", "", "Jana", "", "ban: 192.0.2.7/32"},
+		{"single address is not a network", "192.0.2.70", "", "Jana", "", ""},
+		{"address in network", "203.0.113.200", "", "Jana", "", "ban: 203.0.113.0/24"},
+		{"IPv4-mapped address", "::ffff:192.0.2.7", "", "Jana", "", "ban: 192.0.2.7/32"},
+		{"IPv4-mapped address in network", "::ffff:203.0.113.5", "", "Jana", "", "ban: 203.0.113.0/24"},
+		{"IPv4-mapped network", "198.18.3.4", "", "Jana", "", "ban: 198.18.0.0/16"},
+		{"IPv6 network", "2001:db8:1:ffff::1", "", "Jana", "", "ban: 2001:db8:1::/48"},
+		{"IPv6 outside network", "2001:db8:2::1", "", "Jana", "", ""},
+		{"invalid address", "unknown", "", "Jana", "", ""},
+		{"name glob", "", "", "  TrollFace ", "", "ban: name troll*"},
+		{"name brackets are literal", "", "", "[Admin]", "", "ban: name [admin]"},
+		{"name bracket class does not match", "", "", "a", "", ""},
+		{"word in content", "", "", "Jana", "Best CASINO online", "ban: casino"},
+		{"word in name", "", "", "casino king", "Ahoj", "ban: casino"},
+	}
+	for _, tt := range tests {
+		if got := list.Match(tt.ip, tt.visitor, tt.author, tt.content); got != tt.want {
+			t.Errorf("%s: Match = %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// pageValidator collects what a public page is rendered from, so browsers
+// revalidating an unchanged page get 304 Not Modified instead of the whole
+// page. Last-Modified is the newest timestamp seen; the ETag also covers
+// changes without one, such as deleted comments or reordered photos.
+type pageValidator struct {
+	modified time.Time
+	hash     hash.Hash
+}
+
+func newPageValidator(version string) *pageValidator {
+	v := &pageValidator{hash: sha256.New()}
+	fmt.Fprintln(v.hash, version)
+	return v
+}
+
+// add records content last changed at modified (zero if unknown) and the
+// values the page shows of it.
+func (v *pageValidator) add(modified time.Time, values ...interface{}) {
+	if modified.After(v.modified) {
+		v.modified = modified
+	}
+	// Nullable IDs would otherwise be printed as addresses.
+	for i, value := range values {
+		if p, ok := value.(*int64); ok {
+			values[i] = "-"
+			if p != nil {
+				values[i] = *p
+			}
+		}
+	}
+	fmt.Fprintln(v.hash, values...)
+}
+
+func (v *pageValidator) etag() string {
+	return `W/"` + hex.EncodeToString(v.hash.Sum(nil)[:12]) + `"`
+}
+
+// notModified sets the validators and answers a matching conditional
+// request with 304. It returns true when nothing more must be written.
+func (v *pageValidator) notModified(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", v.etag())
+	if !v.modified.IsZero() {
+		w.Header().Set("Last-Modified", v.modified.UTC().Format(http.TimeFormat))
+	}
+	if !notModifiedSince(r, v.etag(), v.modified) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// notModifiedSince reports whether the client's copy identified by etag, or
+// last modified at modified, is current. If-None-Match takes precedence.
+func notModifiedSince(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagMatches(inm, etag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return !modified.Truncate(time.Second).After(ims)
+}
+
+// etagMatches compares the If-None-Match list weakly, as RFC 9110 requires
+// for GET.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
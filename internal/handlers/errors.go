@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/security"
+)
+
+// ErrorPages renders the themed error pages shared by the public site and
+// the administration: 404 with "did you mean" suggestions, 410 for deleted
+// content and 500 with a request ID visitors can quote when reporting it.
+type ErrorPages struct {
+	Public    *Templates
+	Admin     *Templates
+	Articles  *models.ArticleStore
+	Galleries *models.GalleryStore
+	GonePaths *models.GoneStore
+	BaseURL   string
+	Version   string
+}
+
+// errorSuggestion is a page offered on a 404 whose slug resembles the URL.
+type errorSuggestion struct {
+	Title string
+	Path  string
+}
+
+const maxErrorSuggestions = 3
+
+// NotFound answers 410 Gone when the path belonged to deleted content and
+// 404 otherwise. It is also the router's NotFound handler.
+func (e *ErrorPages) NotFound(w http.ResponseWriter, r *http.Request) {
+	gone, err := e.GonePaths.Contains(r.URL.Path)
+	if err != nil {
+		log.Printf("error looking up gone path %s: %v", r.URL.Path, err)
+	}
+	if gone {
+		e.Gone(w, r)
+		return
+	}
+	data := map[string]interface{}{}
+	if !strings.HasPrefix(r.URL.Path, "/admin") {
+		data["Suggestions"] = e.suggest(r.URL.Path)
+	}
+	e.render(w, r, http.StatusNotFound, data)
+}
+
+// Gone renders the 410 page for content that was deleted on purpose.
+func (e *ErrorPages) Gone(w http.ResponseWriter, r *http.Request) {
+	e.render(w, r, http.StatusGone, map[string]interface{}{})
+}
+
+// InternalError logs err together with the request ID and renders the 500
+// page showing that ID.
+func (e *ErrorPages) InternalError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == nil {
+		err = fmt.Errorf("internal error")
+	}
+	log.Printf("[%s] %s %s: %v", middleware.GetReqID(r.Context()), r.Method, r.URL.Path, err)
+	e.render(w, r, http.StatusInternalServerError, map[string]interface{}{})
+}
+
+// Error renders the themed page for any other status with a message for the
+// visitor, e.g. 403 or 400.
+func (e *ErrorPages) Error(w http.ResponseWriter, r *http.Request, status int, message string) {
+	e.render(w, r, status, map[string]interface{}{"Message": message})
+}
+
+// RequestID tags every request with a short random ID. It is stored where
+// chi's Logger picks it up and returned in the X-Request-Id header.
+func (e *ErrorPages) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id := hex.EncodeToString(b)
+		w.Header().Set("X-Request-Id", id)
+		ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Recoverer turns a panicking handler into the themed 500 page.
+func (e *ErrorPages) Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			e.InternalError(w, r, fmt.Errorf("panic: %v\n%s", rec, debug.Stack()))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// render picks the admin layout for signed-in users inside /admin and the
+// public layout everywhere else.
+func (e *ErrorPages) render(w http.ResponseWriter, r *http.Request, status int, data map[string]interface{}) {
+	data["Status"] = status
+	data["StatusText"] = http.StatusText(status)
+	data["RequestID"] = middleware.GetReqID(r.Context())
+	data["Path"] = r.URL.Path
+	data["Version"] = e.Version
+	data["Nonce"] = security.Nonce(r)
+
+	t, _ := e.Public.Lookup(r, "error.html")
+	name := "error.html"
+	if user := CurrentUser(r); user != nil && strings.HasPrefix(r.URL.Path, "/admin") {
+		t, _ = e.Admin.Lookup(r, "error.html")
+		data["CurrentUser"] = user
+	} else {
+		data["BaseURL"] = e.BaseURL
+	}
+
+	var buf bytes.Buffer
+	if t == nil {
+		log.Printf("error template not found")
+	} else if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		log.Printf("error template error: %v", err)
+		buf.Reset()
+	}
+
+	w.Header().Del("Content-Length")
+	w.Header().Set("Cache-Control", "no-store")
+	if buf.Len() == 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
+
+// suggest finds published articles and galleries whose slug resembles the
+// requested one.
+func (e *ErrorPages) suggest(path string) []errorSuggestion {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	slug := parts[len(parts)-1]
+	if len(parts) >= 2 && (parts[0] == "articles" || parts[0] == "gallery") {
+		slug = parts[1]
+	}
+	slug = Slugify(slug)
+	if slug == "" {
+		return nil
+	}
+
+	type candidate struct {
+		errorSuggestion
+		distance float64
+	}
+	var candidates []candidate
+	consider := func(title, candidateSlug, path string) {
+		if d, ok := slugDistance(slug, candidateSlug); ok {
+			candidates = append(candidates, candidate{errorSuggestion{title, path}, d})
+		}
+	}
+	if parts[0] != "gallery" {
+		articles, err := e.Articles.GetPublished()
+		if err != nil {
+			log.Printf("error loading articles for suggestions: %v", err)
+		}
+		for _, a := range articles {
+			consider(a.Title, a.Slug, "/articles/"+a.Slug)
+		}
+	}
+	if parts[0] != "articles" {
+		galleries, err := e.Galleries.GetAll()
+		if err != nil {
+			log.Printf("error loading galleries for suggestions: %v", err)
+		}
+		for _, g := range galleries {
+			consider(g.Title, g.Slug, "/gallery/"+g.Slug)
+		}
+	}
+
+	// Insertion sort: the candidate lists are short.
+	for i := 1; i < len(candidates); i++ {
+		for j := i; j > 0 && candidates[j].distance < candidates[j-1].distance; j-- {
+			candidates[j], candidates[j-1] = candidates[j-1], candidates[j]
+		}
+	}
+	var out []errorSuggestion
+	for i := 0; i < len(candidates) && i < maxErrorSuggestions; i++ {
+		out = append(out, candidates[i].errorSuggestion)
+	}
+	return out
+}
+
+// slugDistance compares two slugs and reports whether they are similar
+// enough to suggest one for the other. The distance is the Levenshtein
+// distance relative to the longer slug; a slug containing the other counts
+// as close.
+func slugDistance(a, b string) (float64, bool) {
+	if a == "" || b == "" {
+		return 0, false
+	}
+	if strings.Contains(a, b) || strings.Contains(b, a) {
+		return 0.1, len(a) >= 3 && len(b) >= 3
+	}
+	longest := utf8.RuneCountInString(a)
+	if n := utf8.RuneCountInString(b); n > longest {
+		longest = n
+	}
+	d := float64(levenshtein(a, b)) / float64(longest)
+	return d, d <= 0.4
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+)
+
+const (
+	feedTitle     = "Motoklub Charon"
+	feedItemLimit = 20
+	// feedSummaryLength caps the summary of articles without an excerpt.
+	feedSummaryLength = 300
+)
+
+// feedItem is one article as shown in every feed format.
+type feedItem struct {
+	Title     string
+	URL       string
+	Summary   string // plain text
+	Content   string // HTML, empty when the feed carries excerpts only
+	Published time.Time
+	Updated   time.Time
+	Image     *feedImage
+}
+
+// feedImage is a cover image published as an enclosure.
+type feedImage struct {
+	URL  string
+	Type string
+	Size int64
+}
+
+// feedItems returns the latest published articles, newest first, and the
+// time the newest of them was last changed.
+func (h *PublicHandler) feedItems() ([]feedItem, time.Time, error) {
+	articles, err := h.Articles.GetPublished()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	published := func(a models.Article) time.Time {
+		if a.PublishedAt != nil {
+			return *a.PublishedAt
+		}
+		return a.CreatedAt
+	}
+	sort.SliceStable(articles, func(i, j int) bool {
+		return published(articles[i]).After(published(articles[j]))
+	})
+	if len(articles) > feedItemLimit {
+		articles = articles[:feedItemLimit]
+	}
+
+	full := true
+	if v, err := h.Settings.Get("feed_content"); err == nil && v == "excerpt" {
+		full = false
+	}
+
+	var items []feedItem
+	var modified time.Time
+	for _, a := range articles {
+		item := feedItem{
+			Title:     a.Title,
+			URL:       h.BaseURL + "/articles/" + a.Slug,
+			Summary:   a.Excerpt,
+			Published: published(a),
+			Updated:   a.UpdatedAt,
+		}
+		if item.Summary == "" {
+			item.Summary = feedSummary(a.Content)
+		}
+		if a.CoverImage != "" {
+			item.Image = h.feedImage(a.CoverImage)
+		}
+		if full {
+			var b strings.Builder
+			if item.Image != nil {
+				b.WriteString(`<p><img src="` + template.HTMLEscapeString(item.Image.URL) + `" alt="` + template.HTMLEscapeString(a.Title) + `"></p>`)
+			}
+			b.WriteString("<p>" + strings.ReplaceAll(template.HTMLEscapeString(a.Content), "\n", "<br>") + "</p>")
+			item.Content = b.String()
+		}
+		if item.Updated.After(modified) {
+			modified = item.Updated
+		}
+		items = append(items, item)
+	}
+	return items, modified, nil
+}
+
+// feedImage describes an uploaded cover. Size and type come from the media
+// table; files uploaded before it existed are looked up in storage.
+func (h *PublicHandler) feedImage(filename string) *feedImage {
+	img := &feedImage{URL: storage.AbsoluteURL(h.Storage, h.BaseURL, filename)}
+	if m, err := h.Media.GetByFilename(filename); err == nil {
+		img.Type, img.Size = m.ContentType, m.Size
+	} else if info, err := h.Storage.Stat(filename); err == nil {
+		img.Type, img.Size = info.ContentType, info.Size
+	}
+	if img.Type == "" {
+		img.Type = mime.TypeByExtension(path.Ext(filename))
+	}
+	if img.Type == "" {
+		img.Type = "application/octet-stream"
+	}
+	return img
+}
+
+// feedSummary shortens article text to a summary on a word boundary.
+func feedSummary(content string) string {
+	text := strings.Join(strings.Fields(content), " ")
+	if len([]rune(text)) <= feedSummaryLength {
+		return text
+	}
+	text = truncate(text, feedSummaryLength)
+	if i := strings.LastIndex(text, " "); i > 0 {
+		text = text[:i]
+	}
+	return text + "…"
+}
+
+// --- RSS 2.0 ---
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Self          rssLink   `xml:"atom:link"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	GUID        string        `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Content     string        `xml:"content:encoded,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func (h *PublicHandler) Feed_RSS(w http.ResponseWriter, r *http.Request) {
+	items, modified, err := h.feedItems()
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error building feed: %w", err))
+		return
+	}
+	feed := rssFeed{
+		Version:   "2.0",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		Channel: rssChannel{
+			Title:       feedTitle,
+			Link:        h.BaseURL + "/",
+			Description: T(r, "site.description"),
+			Language:    requestLanguage(r),
+			Self:        rssLink{Href: h.BaseURL + "/feed.xml", Rel: "self", Type: "application/rss+xml"},
+		},
+	}
+	if !modified.IsZero() {
+		feed.Channel.LastBuildDate = modified.Format(time.RFC1123Z)
+	}
+	for _, it := range items {
+		item := rssItem{
+			Title:       it.Title,
+			Link:        it.URL,
+			GUID:        it.URL,
+			PubDate:     it.Published.Format(time.RFC1123Z),
+			Description: it.Summary,
+			Content:     it.Content,
+		}
+		if it.Image != nil {
+			item.Enclosure = &rssEnclosure{URL: it.Image.URL, Length: it.Image.Size, Type: it.Image.Type}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	h.serveXMLFeed(w, r, "application/rss+xml; charset=utf-8", feed, modified)
+}
+
+// --- Atom ---
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang    string      `xml:"xml:lang,attr"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   atomText   `xml:"summary"`
+	Content   *atomText  `xml:"content,omitempty"`
+}
+
+func (h *PublicHandler) Feed_Atom(w http.ResponseWriter, r *http.Request) {
+	items, modified, err := h.feedItems()
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error building feed: %w", err))
+		return
+	}
+	feed := atomFeed{
+		Lang:  requestLanguage(r),
+		Title: feedTitle,
+		ID:    h.BaseURL + "/",
+		Links: []atomLink{
+			{Href: h.BaseURL + "/atom.xml", Rel: "self", Type: "application/atom+xml"},
+			{Href: h.BaseURL + "/", Rel: "alternate", Type: "text/html"},
+		},
+		Author: atomAuthor{Name: feedTitle},
+	}
+	// Atom requires an update time even for an empty feed.
+	updated := modified
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+	feed.Updated = updated.Format(time.RFC3339)
+	for _, it := range items {
+		entry := atomEntry{
+			Title:     it.Title,
+			ID:        it.URL,
+			Links:     []atomLink{{Href: it.URL, Rel: "alternate", Type: "text/html"}},
+			Published: it.Published.Format(time.RFC3339),
+			Updated:   it.Updated.Format(time.RFC3339),
+			Summary:   atomText{Type: "text", Body: it.Summary},
+		}
+		if it.Content != "" {
+			entry.Content = &atomText{Type: "html", Body: it.Content}
+		}
+		if it.Image != nil {
+			entry.Links = append(entry.Links, atomLink{Href: it.Image.URL, Rel: "enclosure", Type: it.Image.Type, Length: it.Image.Size})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	h.serveXMLFeed(w, r, "application/atom+xml; charset=utf-8", feed, modified)
+}
+
+// --- JSON Feed 1.1 ---
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Description string         `json:"description"`
+	Language    string         `json:"language"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url"`
+	Title         string               `json:"title"`
+	ContentHTML   string               `json:"content_html,omitempty"`
+	ContentText   string               `json:"content_text,omitempty"`
+	Summary       string               `json:"summary"`
+	Image         string               `json:"image,omitempty"`
+	DatePublished string               `json:"date_published"`
+	DateModified  string               `json:"date_modified"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	URL      string `json:"url"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size_in_bytes,omitempty"`
+}
+
+func (h *PublicHandler) Feed_JSON(w http.ResponseWriter, r *http.Request) {
+	items, modified, err := h.feedItems()
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error building feed: %w", err))
+		return
+	}
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       feedTitle,
+		HomePageURL: h.BaseURL + "/",
+		FeedURL:     h.BaseURL + "/feed.json",
+		Description: T(r, "site.description"),
+		Language:    requestLanguage(r),
+		Items:       []jsonFeedItem{},
+	}
+	for _, it := range items {
+		item := jsonFeedItem{
+			ID:            it.URL,
+			URL:           it.URL,
+			Title:         it.Title,
+			ContentHTML:   it.Content,
+			Summary:       it.Summary,
+			DatePublished: it.Published.Format(time.RFC3339),
+			DateModified:  it.Updated.Format(time.RFC3339),
+		}
+		// Every item needs content; excerpt-only feeds repeat the summary.
+		if item.ContentHTML == "" {
+			item.ContentText = it.Summary
+		}
+		if it.Image != nil {
+			item.Image = it.Image.URL
+			item.Attachments = []jsonFeedAttachment{{URL: it.Image.URL, MimeType: it.Image.Type, Size: it.Image.Size}}
+		}
+		feed.Items = append(feed.Items, item)
+	}
+	body, err := json.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error encoding feed: %w", err))
+		return
+	}
+	serveETag(w, r, "application/feed+json; charset=utf-8", body, modified)
+}
+
+func (h *PublicHandler) serveXMLFeed(w http.ResponseWriter, r *http.Request, contentType string, feed interface{}, modified time.Time) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("error encoding feed: %w", err))
+		return
+	}
+	serveETag(w, r, contentType, buf.Bytes(), modified)
+}
+
+// serveFeed answers conditional requests: the ETag is derived from the body,
+// so it changes when an article is edited, unpublished or deleted, while
+// Last-Modified is the newest article update.
+func serveETag(w http.ResponseWriter, r *http.Request, contentType string, body []byte, modified time.Time) {
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(body))
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/lukas-pastva/web-charon/internal/events"
+	"github.com/lukas-pastva/web-charon/internal/i18n"
+	"github.com/lukas-pastva/web-charon/internal/models"
+)
+
+// siteLanguageSetting is the settings key of the public site's language.
+const siteLanguageSetting = "site_language"
+
+// Locales chooses the language of each request: the site language set in
+// Settings for public pages, the API and the login page, and the user's own
+// choice, if any, in the administration.
+type Locales struct {
+	Bundle   *i18n.Bundle
+	Settings *models.SettingsStore
+
+	// site is kept in memory since every request needs it; Subscribe
+	// reloads it when the settings change.
+	site atomic.Value
+}
+
+// NewLocales loads the site language from settings.
+func NewLocales(bundle *i18n.Bundle, settings *models.SettingsStore) *Locales {
+	l := &Locales{Bundle: bundle, Settings: settings}
+	l.load()
+	return l
+}
+
+func (l *Locales) load() {
+	lang, err := l.Settings.Get(siteLanguageSetting)
+	if err != nil || !l.Bundle.Has(lang) {
+		lang = l.Bundle.Fallback()
+	}
+	l.site.Store(lang)
+}
+
+// Subscribe reloads the site language whenever the settings change.
+func (l *Locales) Subscribe(bus *events.Bus) {
+	events.Subscribe(bus, "locales", events.Sync, func(events.SettingsChanged) error {
+		l.load()
+		return nil
+	})
+}
+
+// Site returns the language of the public site.
+func (l *Locales) Site() string {
+	return l.site.Load().(string)
+}
+
+// Middleware sets the site language for the request.
+func (l *Locales) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := i18n.WithLocalizer(r.Context(), l.Bundle.Localizer(l.Site()))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// UserMiddleware switches to the language the signed-in user chose. It runs
+// after RequireAuth.
+func (l *Locales) UserMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := CurrentUser(r); user != nil && user.Language != "" {
+			if !l.Bundle.Has(user.Language) {
+				log.Printf("user %s has unknown language %q", user.Nickname, user.Language)
+			} else {
+				r = r.WithContext(i18n.WithLocalizer(r.Context(), l.Bundle.Localizer(user.Language)))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// T translates key into the language of the request; see i18n.Localizer.T.
+func T(r *http.Request, key string, args ...interface{}) string {
+	return i18n.FromContext(r.Context()).T(key, args...)
+}
+
+// requestLanguage returns the language of the request, which selects the
+// template set to render.
+func requestLanguage(r *http.Request) string {
+	return i18n.FromContext(r.Context()).Lang()
+}
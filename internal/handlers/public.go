@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/xml"
 	"fmt"
-	"html/template"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
@@ -12,16 +13,29 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/lukas-pastva/web-charon/internal/events"
 	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/pagecache"
+	"github.com/lukas-pastva/web-charon/internal/storage"
 )
 
 type PublicHandler struct {
 	Articles  *models.ArticleStore
 	Galleries *models.GalleryStore
 	Comments  *models.CommentStore
-	Templates map[string]*template.Template
-	BaseURL   string
-	Version   string
+	Bans      *models.CommentBanStore
+	Settings  *models.SettingsStore
+	Media     *models.MediaStore
+	Spam      *SpamGuard
+	// VisitorSalt keys the hashes of commenter addresses.
+	VisitorSalt []byte
+	Storage     storage.Storage
+	Events      *events.Bus
+	Cache       *pagecache.Cache
+	Templates   *Templates
+	Errors      *ErrorPages
+	BaseURL     string
+	Version     string
 }
 
 func (h *PublicHandler) Home(w http.ResponseWriter, r *http.Request) {
@@ -46,7 +60,7 @@ func (h *PublicHandler) Home(w http.ResponseWriter, r *http.Request) {
 		"BaseURL":         h.BaseURL,
 		"CanonicalPath":   "/",
 	}
-	h.render(w, "home.html", data)
+	h.render(w, r, "home.html", data)
 }
 
 func (h *PublicHandler) Articles_List(w http.ResponseWriter, r *http.Request) {
@@ -59,7 +73,7 @@ func (h *PublicHandler) Articles_List(w http.ResponseWriter, r *http.Request) {
 
 	articles, total, err := h.Articles.GetPublishedPaginated(perPage, offset)
 	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, err)
 		return
 	}
 
@@ -81,43 +95,49 @@ func (h *PublicHandler) Articles_List(w http.ResponseWriter, r *http.Request) {
 		"BaseURL":       h.BaseURL,
 		"CanonicalPath": canonicalPath,
 	}
-	h.render(w, "articles.html", data)
+	h.render(w, r, "articles.html", data)
 }
 
 func (h *PublicHandler) Article_Show(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
 	article, err := h.Articles.GetBySlug(slug)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 	if !article.Published {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 
-	comments, _ := h.Comments.GetByArticleID(article.ID, true)
-
 	gallery, err := h.Galleries.GetByArticleID(article.ID)
 	if err == sql.ErrNoRows {
 		gallery = nil
 	}
 
+	v := newPageValidator(h.Version)
+	v.add(article.UpdatedAt, "article", article.ID)
+	if gallery != nil {
+		addGallery(v, gallery)
+	}
+
 	data := map[string]interface{}{
-		"Article":         article,
-		"Comments":        comments,
-		"Gallery":         gallery,
-		"CommentsEnabled": article.CommentsEnabled,
-		"BaseURL":         h.BaseURL,
-		"CanonicalPath":   "/articles/" + article.Slug,
+		"Article":       article,
+		"Gallery":       gallery,
+		"BaseURL":       h.BaseURL,
+		"CanonicalPath": "/articles/" + article.Slug,
 	}
-	h.render(w, "article.html", data)
+	h.addComments(r, data, h.articleCommentPage(r, article), v)
+	if v.notModified(w, r) {
+		return
+	}
+	h.render(w, r, "article.html", data)
 }
 
 func (h *PublicHandler) Gallery_List(w http.ResponseWriter, r *http.Request) {
 	galleries, err := h.Galleries.GetAll()
 	if err != nil {
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, err)
 		return
 	}
 
@@ -134,35 +154,235 @@ func (h *PublicHandler) Gallery_List(w http.ResponseWriter, r *http.Request) {
 		"BaseURL":       h.BaseURL,
 		"CanonicalPath": "/gallery",
 	}
-	h.render(w, "gallery.html", data)
+	h.render(w, r, "gallery.html", data)
 }
 
 func (h *PublicHandler) Gallery_Show(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
 	gallery, err := h.Galleries.GetBySlug(slug)
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
 		return
 	}
 
+	photoComments, err := h.Comments.CountApprovedByImage(gallery.ID)
+	if err != nil {
+		log.Printf("error counting photo comments: %v", err)
+	}
+
+	v := newPageValidator(h.Version)
+	addGallery(v, gallery)
+	v.add(time.Time{}, "photo comments", photoComments)
+
 	data := map[string]interface{}{
 		"Gallery":       gallery,
+		"PhotoComments": photoComments,
 		"BaseURL":       h.BaseURL,
 		"CanonicalPath": "/gallery/" + gallery.Slug,
 	}
-	h.render(w, "gallery_detail.html", data)
+	h.addComments(r, data, h.galleryCommentPage(r, gallery, nil), v)
+	if v.notModified(w, r) {
+		return
+	}
+	h.render(w, r, "gallery_detail.html", data)
 }
 
-func (h *PublicHandler) Comment_Submit(w http.ResponseWriter, r *http.Request) {
+// Photo_Show shows a single gallery photo with its own comments.
+func (h *PublicHandler) Photo_Show(w http.ResponseWriter, r *http.Request) {
+	gallery, index, ok := h.galleryPhoto(r)
+	if !ok {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	photo := &gallery.Images[index]
+
+	data := map[string]interface{}{
+		"Gallery":       gallery,
+		"Photo":         photo,
+		"Position":      index + 1,
+		"BaseURL":       h.BaseURL,
+		"CanonicalPath": photoPath(gallery, photo),
+	}
+	if index > 0 {
+		data["Prev"] = &gallery.Images[index-1]
+	}
+	if index+1 < len(gallery.Images) {
+		data["Next"] = &gallery.Images[index+1]
+	}
+	v := newPageValidator(h.Version)
+	addGallery(v, gallery)
+	h.addComments(r, data, h.galleryCommentPage(r, gallery, photo), v)
+	if v.notModified(w, r) {
+		return
+	}
+	h.render(w, r, "photo.html", data)
+}
+
+// addGallery records a gallery and its photos for the page validator.
+// Photo changes do not touch the gallery's UpdatedAt.
+func addGallery(v *pageValidator, g *models.Gallery) {
+	v.add(g.UpdatedAt, "gallery", g.ID, g.CoverImageID)
+	for _, img := range g.Images {
+		v.add(img.CreatedAt, "image", img.ID, img.Filename, img.Caption, img.AltText)
+	}
+}
+
+// galleryPhoto looks up the gallery and the position of the photo named in
+// the URL. Photos of other galleries are not found.
+func (h *PublicHandler) galleryPhoto(r *http.Request) (*models.Gallery, int, bool) {
+	gallery, err := h.Galleries.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		return nil, 0, false
+	}
+	id, _ := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	for i := range gallery.Images {
+		if gallery.Images[i].ID == id {
+			return gallery, i, true
+		}
+	}
+	return nil, 0, false
+}
+
+func photoPath(g *models.Gallery, img *models.Image) string {
+	return "/gallery/" + g.Slug + "/photos/" + strconv.FormatInt(img.ID, 10)
+}
+
+// Gallery_Download streams all photos of a gallery as a ZIP archive.
+func (h *PublicHandler) Gallery_Download(w http.ResponseWriter, r *http.Request) {
 	slug := chi.URLParam(r, "slug")
-	article, err := h.Articles.GetBySlug(slug)
+	gallery, err := h.Galleries.GetBySlug(slug)
+	if err != nil || len(gallery.Images) == 0 {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	writeGalleryArchive(w, gallery, h.Storage)
+}
+
+// commentPage describes a page visitors can comment on: an article, a
+// gallery or a single photo.
+type commentPage struct {
+	Target models.CommentTarget
+	Path   string // public URL of the page
+	Title  string // shown in notification emails
+	// Enabled shows the comment section at all; Closed explains why new
+	// comments are refused, or is "" when they are accepted.
+	Enabled bool
+	Closed  string
+}
+
+func (h *PublicHandler) articleCommentPage(r *http.Request, article *models.Article) commentPage {
+	closed := ""
+	if article.CommentsEnabled {
+		closed = h.commentsClosedReason(r, article.CommentsClosed, article.CommentsAutoClosed, "article")
+	}
+	return commentPage{
+		Target:  models.ArticleTarget(article.ID),
+		Path:    "/articles/" + article.Slug,
+		Title:   article.Title,
+		Enabled: article.CommentsEnabled,
+		Closed:  closed,
+	}
+}
+
+// galleryCommentPage returns the comment page of the gallery, or of one of
+// its photos when photo is not nil. Photos follow the gallery's switches.
+func (h *PublicHandler) galleryCommentPage(r *http.Request, gallery *models.Gallery, photo *models.Image) commentPage {
+	closed := ""
+	if gallery.CommentsEnabled {
+		closed = h.commentsClosedReason(r, gallery.CommentsClosed, gallery.CommentsAutoClosed, "gallery")
+	}
+	page := commentPage{
+		Target:  models.GalleryTarget(gallery.ID),
+		Path:    "/gallery/" + gallery.Slug,
+		Title:   gallery.Title,
+		Enabled: gallery.CommentsEnabled,
+		Closed:  closed,
+	}
+	if photo != nil {
+		page.Target = models.ImageTarget(photo.ID)
+		page.Path = photoPath(gallery, photo)
+	}
+	return page
+}
+
+// addComments adds the approved comments of the page and the state of its
+// comment form to the template data and to v. The comment token is left out
+// of v: a page answered with 304 keeps its old token, so the form fetches a
+// fresh one from Comment_Token.
+func (h *PublicHandler) addComments(r *http.Request, data map[string]interface{}, page commentPage, v *pageValidator) {
+	comments, err := h.Comments.GetByTarget(page.Target, true)
+	if err != nil {
+		log.Printf("error loading comments for %s: %v", page.Target, err)
+	}
+	v.add(time.Time{}, "comments", page.Enabled, page.Closed, r.URL.RawQuery, err != nil)
+	for _, c := range comments {
+		v.add(c.CreatedAt, "comment", c.ID, c.ParentID, c.UserID, c.AuthorName, c.Content)
+	}
+	data["Comments"] = models.ThreadComments(comments)
+	data["CommentCount"] = len(comments)
+	data["CommentsEnabled"] = page.Enabled
+	data["CommentsOpen"] = page.Closed == ""
+	data["CommentsClosed"] = page.Closed
+	// The token is single-use, so cached pages get a fresh one every time.
+	data["CommentToken"] = h.Cache.Fragment(r, "comment-token", page.Target.String())
+	data["CommentTarget"] = page.Target.String()
+	data["CommentNotice"] = commentNotice(r)
+	data["CommentAction"] = page.Path + "/comments"
+}
+
+// Comment_Token issues a fresh comment token for a page. The comment forms
+// fetch it when the visitor starts writing, since the token in the page may
+// have been used already.
+func (h *PublicHandler) Comment_Token(w http.ResponseWriter, r *http.Request) {
+	target, ok := models.ParseCommentTarget(r.URL.Query().Get("target"))
+	if !ok {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	io.WriteString(w, h.Spam.Token(target.String()))
+}
+
+func (h *PublicHandler) Comment_Submit(w http.ResponseWriter, r *http.Request) {
+	article, err := h.Articles.GetBySlug(chi.URLParam(r, "slug"))
 	if err != nil {
-		http.NotFound(w, r)
+		h.Errors.NotFound(w, r)
+		return
+	}
+	h.submitComment(w, r, h.articleCommentPage(r, article))
+}
+
+func (h *PublicHandler) Gallery_CommentSubmit(w http.ResponseWriter, r *http.Request) {
+	gallery, err := h.Galleries.GetBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		h.Errors.NotFound(w, r)
+		return
+	}
+	h.submitComment(w, r, h.galleryCommentPage(r, gallery, nil))
+}
+
+func (h *PublicHandler) Photo_CommentSubmit(w http.ResponseWriter, r *http.Request) {
+	gallery, index, ok := h.galleryPhoto(r)
+	if !ok {
+		h.Errors.NotFound(w, r)
 		return
 	}
+	h.submitComment(w, r, h.galleryCommentPage(r, gallery, &gallery.Images[index]))
+}
 
-	if !article.CommentsEnabled {
-		http.Error(w, "Komentáře jsou zakázány", http.StatusForbidden)
+// submitComment stores a visitor's comment on the page and redirects back
+// to the page's comment section.
+func (h *PublicHandler) submitComment(w http.ResponseWriter, r *http.Request, page commentPage) {
+	if !page.Enabled {
+		h.Errors.Error(w, r, http.StatusForbidden, T(r, "comments.disabled"))
+		return
+	}
+	back := func(status string) {
+		http.Redirect(w, r, page.Path+"?"+status+"#comments", http.StatusSeeOther)
+	}
+	if page.Closed != "" {
+		back("error=comments_closed")
 		return
 	}
 
@@ -170,23 +390,94 @@ func (h *PublicHandler) Comment_Submit(w http.ResponseWriter, r *http.Request) {
 	content := strings.TrimSpace(r.FormValue("content"))
 
 	if authorName == "" || content == "" {
-		http.Redirect(w, r, "/articles/"+slug+"?error=fields_required", http.StatusSeeOther)
+		back("error=fields_required")
 		return
 	}
 
+	verdict, reason := h.Spam.Check(r, page.Target.String(), authorName, content)
+	switch verdict {
+	case SpamDiscard:
+		log.Printf("comment discarded on %s from %s: %s", page.Path, clientIP(r), reason)
+		back("comment=pending")
+		return
+	case SpamReject:
+		log.Printf("comment rejected on %s from %s: %s", page.Path, clientIP(r), reason)
+		back("error=expired")
+		return
+	case SpamRateLimited:
+		log.Printf("comment rate limited on %s from %s", page.Path, clientIP(r))
+		back("error=rate_limited")
+		return
+	}
+
+	ip := clientIP(r)
 	comment := &models.Comment{
-		ArticleID:  article.ID,
 		AuthorName: authorName,
 		Content:    content,
-		Approved:   false,
+		Status:     models.CommentPending,
+		IPHash:     visitorHash(h.VisitorSalt, ip),
+		IPNetwork:  ipNetwork(ip),
+		UserAgent:  truncate(r.UserAgent(), maxUserAgentLength),
+	}
+	comment.SetTarget(page.Target)
+	if parentID, _ := strconv.ParseInt(r.FormValue("parent_id"), 10, 64); parentID > 0 {
+		parent, err := h.Comments.GetByID(parentID)
+		if err != nil || parent.Target() != page.Target || !parent.Approved() {
+			back("error=reply_unavailable")
+			return
+		}
+		comment.SetParent(parent)
+	}
+	// Banned visitors get the usual "awaiting moderation" message, so they
+	// do not notice the ban and switch names or networks.
+	if verdict == SpamAccept {
+		if bans, err := LoadBanList(h.Bans); err != nil {
+			log.Printf("error loading comment bans: %v", err)
+		} else if banned := bans.Match(ip, comment.IPHash, authorName, content); banned != "" {
+			verdict, reason = SpamFlag, banned
+		}
+	}
+	if verdict == SpamFlag {
+		comment.Status = models.CommentSpam
+		comment.SpamReason = reason
 	}
 	if err := h.Comments.Create(comment); err != nil {
-		log.Printf("error creating comment: %v", err)
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, fmt.Errorf("error creating comment: %w", err))
 		return
 	}
+	h.Events.Publish(events.CommentCreated{Comment: comment, Title: page.Title, Path: page.Path})
+
+	back("comment=pending")
+}
 
-	http.Redirect(w, r, "/articles/"+slug+"?comment=pending", http.StatusSeeOther)
+// commentsClosedReason explains why new comments are not accepted, or
+// returns "" when they are. Existing comments stay visible. kind names the
+// page in the messages: "article" or "gallery".
+func (h *PublicHandler) commentsClosedReason(r *http.Request, closed bool, autoClosed func(days int, now time.Time) bool, kind string) string {
+	if enabled, err := h.Settings.Get("comments_enabled"); err == nil && enabled == "false" {
+		return T(r, "comments.closed.disabled")
+	}
+	if closed {
+		return T(r, "comments.closed."+kind)
+	}
+	days, _ := h.Settings.Get("comments_auto_close_days")
+	if n, _ := strconv.Atoi(days); autoClosed(n, time.Now()) {
+		return T(r, "comments.auto_closed."+kind, "count", n)
+	}
+	return ""
+}
+
+// commentNotice maps the status parameters set by Comment_Submit to the
+// message shown above the comment form.
+func commentNotice(r *http.Request) map[string]string {
+	switch code := r.URL.Query().Get("error"); code {
+	case "fields_required", "expired", "rate_limited", "reply_unavailable", "comments_closed":
+		return map[string]string{"Class": "alert-error", "Text": T(r, "comments.notice."+code)}
+	}
+	if r.URL.Query().Get("comment") == "pending" {
+		return map[string]string{"Class": "alert-success", "Text": T(r, "comments.notice.pending")}
+	}
+	return nil
 }
 
 func (h *PublicHandler) Robots(w http.ResponseWriter, r *http.Request) {
@@ -250,19 +541,20 @@ func (h *PublicHandler) Sitemap(w http.ResponseWriter, r *http.Request) {
 	enc.Encode(sitemap)
 }
 
-func (h *PublicHandler) render(w http.ResponseWriter, name string, data interface{}) {
-	t, ok := h.Templates[name]
+func (h *PublicHandler) render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	t, ok := h.Templates.Lookup(r, name)
 	if !ok {
-		log.Printf("public template not found: %s", name)
-		http.Error(w, "Interní chyba serveru", 500)
+		h.Errors.InternalError(w, r, fmt.Errorf("public template not found: %s", name))
 		return
 	}
 	if m, ok := data.(map[string]interface{}); ok {
 		m["Version"] = h.Version
+		m["Nonce"] = h.Cache.Fragment(r, "csp-nonce", "")
 	}
-	err := t.ExecuteTemplate(w, name, data)
-	if err != nil {
-		log.Printf("template error (%s): %v", name, err)
-		http.Error(w, "Interní chyba serveru", 500)
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+		h.Errors.InternalError(w, r, fmt.Errorf("template error (%s): %w", name, err))
+		return
 	}
+	buf.WriteTo(w)
 }
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spam filter tuning. Humans need a while to read an article and write a
+// comment; bots post instantly or replay a scraped form much later.
+const (
+	commentMinDelay       = 3 * time.Second
+	commentTokenMaxAge    = 6 * time.Hour
+	commentIPLimit        = 3
+	commentIPWindow       = 10 * time.Minute
+	commentArticleLimit   = 20
+	commentArticleWindow  = time.Hour
+	commentMaxLinks       = 2
+	commentHoneypotField  = "website"
+	commentTokenField     = "comment_token"
+	commentBlocklistKey   = "comment_blocklist" // replaced by comment bans
+	commentMaxContentSize = 5000
+)
+
+// SpamVerdict is the outcome of checking a comment submission.
+type SpamVerdict int
+
+const (
+	// SpamAccept queues the comment for moderation.
+	SpamAccept SpamVerdict = iota
+	// SpamFlag stores the comment with the "spam" status.
+	SpamFlag
+	// SpamDiscard drops the submission while pretending it was accepted, so
+	// bots get no feedback.
+	SpamDiscard
+	// SpamReject refuses the submission with an error shown to the visitor.
+	SpamReject
+	// SpamRateLimited refuses the submission because too many comments were
+	// posted recently.
+	SpamRateLimited
+)
+
+var linkPattern = regexp.MustCompile(`(?i)https?://|www\.|\[url`)
+
+// SpamGuard implements the layered comment spam filter: a honeypot field,
+// a signed render-time token, per-IP and per-article rate limits, and a
+// link-count heuristic. Banned words, names and addresses are handled by
+// BanList.
+type SpamGuard struct {
+	key []byte
+
+	mu       sync.Mutex
+	used     map[string]time.Time // token nonce -> expiry
+	byIP     map[string][]time.Time
+	byTarget map[string][]time.Time
+}
+
+// NewSpamGuard returns a guard signing tokens with a key derived from secret.
+func NewSpamGuard(secret []byte) *SpamGuard {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("comment-token"))
+	return &SpamGuard{
+		key:      mac.Sum(nil),
+		used:     make(map[string]time.Time),
+		byIP:     make(map[string][]time.Time),
+		byTarget: make(map[string][]time.Time),
+	}
+}
+
+// Token returns a signed token for a comment form rendered now for target
+// (e.g. "article:12").
+func (g *SpamGuard) Token(target string) string {
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	payload := strconv.FormatInt(time.Now().Unix(), 10) + "." + hex.EncodeToString(nonce)
+	return payload + "." + g.sign(target, payload)
+}
+
+func (g *SpamGuard) sign(target, payload string) string {
+	mac := hmac.New(sha256.New, g.key)
+	mac.Write([]byte(target + "|" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check runs the filter over a submission and returns the verdict together
+// with a short reason for logs and the moderation queue.
+func (g *SpamGuard) Check(r *http.Request, target, authorName, content string) (SpamVerdict, string) {
+	if r.FormValue(commentHoneypotField) != "" {
+		return SpamDiscard, "honeypot"
+	}
+	if verdict, reason := g.checkToken(target, r.FormValue(commentTokenField)); verdict != SpamAccept {
+		return verdict, reason
+	}
+	if !g.allow(clientIP(r), target) {
+		return SpamRateLimited, "rate limit"
+	}
+
+	if len(content) > commentMaxContentSize {
+		return SpamFlag, "too long"
+	}
+	if n := len(linkPattern.FindAllStringIndex(content, -1)) + len(linkPattern.FindAllStringIndex(authorName, -1)); n > commentMaxLinks {
+		return SpamFlag, strconv.Itoa(n) + " links"
+	}
+	return SpamAccept, ""
+}
+
+func (g *SpamGuard) checkToken(target, token string) (SpamVerdict, string) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return SpamReject, "missing token"
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(g.sign(target, payload))) {
+		return SpamReject, "invalid token"
+	}
+	issued, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return SpamReject, "invalid token"
+	}
+	age := time.Since(time.Unix(issued, 0))
+	if age < commentMinDelay {
+		return SpamDiscard, "too fast"
+	}
+	if age > commentTokenMaxAge {
+		return SpamReject, "expired token"
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for nonce, expiry := range g.used {
+		if now.After(expiry) {
+			delete(g.used, nonce)
+		}
+	}
+	if _, seen := g.used[parts[1]]; seen {
+		return SpamDiscard, "replayed token"
+	}
+	g.used[parts[1]] = time.Unix(issued, 0).Add(commentTokenMaxAge)
+	return SpamAccept, ""
+}
+
+// allow records a submission and reports whether it stays within the per-IP
+// and per-target rate limits.
+func (g *SpamGuard) allow(ip, target string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	ipHits := recentHits(g.byIP[ip], now.Add(-commentIPWindow))
+	targetHits := recentHits(g.byTarget[target], now.Add(-commentArticleWindow))
+	if len(ipHits) >= commentIPLimit || len(targetHits) >= commentArticleLimit {
+		g.byIP[ip] = ipHits
+		g.byTarget[target] = targetHits
+		return false
+	}
+	g.byIP[ip] = append(ipHits, now)
+	g.byTarget[target] = append(targetHits, now)
+
+	// Forget visitors who have gone quiet so the maps do not grow forever.
+	if len(g.byIP) > 10000 {
+		for k, hits := range g.byIP {
+			if len(recentHits(hits, now.Add(-commentIPWindow))) == 0 {
+				delete(g.byIP, k)
+			}
+		}
+	}
+	return true
+}
+
+func recentHits(hits []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && hits[i].Before(since) {
+		i++
+	}
+	return hits[i:]
+}
+
+// ParseBlocklist splits the old blocklist setting into lowercase entries,
+// one per line.
+func ParseBlocklist(setting string) []string {
+	var words []string
+	for _, line := range strings.Split(setting, "\n") {
+		if w := strings.ToLower(strings.TrimSpace(line)); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+func matchBlocklist(words []string, text string) string {
+	text = strings.ToLower(text)
+	for _, w := range words {
+		if strings.Contains(text, w) {
+			return w
+		}
+	}
+	return ""
+}
+
+// clientIP returns the address of the client. When the app runs behind a
+// trusted proxy, the RealIP middleware has already rewritten RemoteAddr.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
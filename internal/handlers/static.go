@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lukas-pastva/web-charon/internal/compress"
+)
+
+// fingerprintedName matches static files whose name carries a hash of
+// their content, e.g. "logo-small-c71638ea.png".
+var fingerprintedName = regexp.MustCompile(`-[0-9a-f]{8}\.[a-z0-9]+$`)
+
+// StaticHandler serves the embedded static files. Fingerprinted files, by
+// name or by the "v" query parameter the templates add, are cached for a
+// year; the rest are revalidated by ETag. Text files are compressed once at
+// startup and sent in the best coding the client accepts.
+type StaticHandler struct {
+	version string
+	files   map[string]*staticFile
+}
+
+type staticFile struct {
+	contentType string
+	etag        string
+	// variants holds the body per content coding; "" is the original.
+	variants map[string][]byte
+}
+
+// NewStaticHandler reads and compresses every file of files up front; the
+// embedded files cannot change while the server runs.
+func NewStaticHandler(files fs.FS, version string) (*StaticHandler, error) {
+	h := &StaticHandler{version: version, files: make(map[string]*staticFile)}
+	err := fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		f := &staticFile{
+			contentType: mime.TypeByExtension(path.Ext(name)),
+			etag:        hex.EncodeToString(sum[:8]),
+			variants:    map[string][]byte{"": data},
+		}
+		if f.contentType == "" {
+			f.contentType = http.DetectContentType(data)
+		}
+		if compress.Compressible(f.contentType) {
+			for _, coding := range []string{compress.Brotli, compress.Gzip} {
+				packed, err := compress.Bytes(coding, data)
+				if err != nil {
+					return err
+				}
+				if len(packed) < len(data) {
+					f.variants[coding] = packed
+				}
+			}
+		}
+		h.files[name] = f
+		return nil
+	})
+	return h, err
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f, ok := h.files[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if fingerprintedName.MatchString(r.URL.Path) || (h.version != "" && r.URL.Query().Get("v") == h.version) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+
+	coding := ""
+	if len(f.variants) > 1 {
+		compress.AddVary(w.Header())
+		if c := compress.Negotiate(r); f.variants[c] != nil {
+			coding = c
+		}
+	}
+	w.Header().Set("Content-Type", f.contentType)
+	// Each coding is a different representation and needs its own ETag.
+	// ServeContent answers If-None-Match against this header.
+	if coding != "" {
+		w.Header().Set("Content-Encoding", coding)
+		w.Header().Set("ETag", `"`+f.etag+"-"+coding+`"`)
+	} else {
+		w.Header().Set("ETag", `"`+f.etag+`"`)
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(f.variants[coding]))
+}
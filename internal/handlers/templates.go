@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// Templates holds the parsed pages once per language, since the T template
+// function is bound to a language when the templates are parsed.
+type Templates struct {
+	// Sets maps a language to its pages by file name.
+	Sets map[string]map[string]*template.Template
+	// Fallback is the language of requests without one.
+	Fallback string
+}
+
+// Lookup returns the page in the language of the request.
+func (t *Templates) Lookup(r *http.Request, name string) (*template.Template, bool) {
+	set, ok := t.Sets[requestLanguage(r)]
+	if !ok {
+		set = t.Sets[t.Fallback]
+	}
+	tmpl, ok := set[name]
+	return tmpl, ok
+}
@@ -1,78 +1,154 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
-	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
-	"time"
+
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/storage"
 )
 
-func HandleUpload(r *http.Request, fieldName, storagePath string) (string, error) {
+var allowedUploadExt = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+
+// contentAddressedName matches filenames derived from a SHA-256 content hash.
+// Their content never changes, so they can be cached indefinitely.
+var contentAddressedName = regexp.MustCompile(`^[0-9a-f]{64}\.[a-z0-9]+$`)
+
+// IsContentAddressed reports whether filename was derived from a content hash.
+func IsContentAddressed(filename string) bool {
+	return contentAddressedName.MatchString(filename)
+}
+
+// HandleUpload stores the file posted in fieldName. The returned bool reports
+// whether identical content had already been uploaded before.
+func HandleUpload(r *http.Request, fieldName string, store storage.Storage, media *models.MediaStore) (*models.Media, bool, error) {
 	file, header, err := r.FormFile(fieldName)
 	if err != nil {
-		return "", fmt.Errorf("read form file: %w", err)
+		return nil, false, fmt.Errorf("read form file: %w", err)
 	}
-	defer file.Close()
+	file.Close()
+	return HandleUploadFromFileHeader(header, store, media)
+}
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	allowed := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
-	if !allowed[ext] {
-		return "", fmt.Errorf("file type %s not allowed", ext)
-	}
+func HandleUploadFromFileHeader(fh *multipart.FileHeader, store storage.Storage, media *models.MediaStore) (*models.Media, bool, error) {
+	return StoreUpload(store, media, fh.Filename, func() (io.ReadCloser, error) {
+		return fh.Open()
+	})
+}
 
-	if err := os.MkdirAll(storagePath, 0755); err != nil {
-		return "", fmt.Errorf("create upload dir: %w", err)
+// StoreUpload saves an uploaded file under the SHA-256 hash of its content and
+// takes a reference on the matching media record. Content that is already
+// stored is not written again; duplicate is true in that case. open is called
+// once to hash the content and, for new content, once more to store it.
+func StoreUpload(store storage.Storage, media *models.MediaStore, originalName string, open func() (io.ReadCloser, error)) (m *models.Media, duplicate bool, err error) {
+	ext := strings.ToLower(filepath.Ext(originalName))
+	if !allowedUploadExt[ext] {
+		return nil, false, fmt.Errorf("file type %s not allowed", ext)
 	}
 
-	filename := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-	destPath := filepath.Join(storagePath, filename)
-
-	dst, err := os.Create(destPath)
+	hash, size, err := hashUpload(open)
 	if err != nil {
-		return "", fmt.Errorf("create destination file: %w", err)
+		return nil, false, err
 	}
-	defer dst.Close()
 
-	if _, err := io.Copy(dst, file); err != nil {
-		return "", fmt.Errorf("save file: %w", err)
+	m, err = media.GetByHash(hash)
+	switch {
+	case err == nil:
+		duplicate = true
+	case err == sql.ErrNoRows:
+		m = &models.Media{
+			Hash:        hash,
+			Filename:    hash + ext,
+			ContentType: mime.TypeByExtension(ext),
+			Size:        size,
+		}
+		if err := putUpload(store, m, open); err != nil {
+			return nil, false, err
+		}
+	default:
+		return nil, false, fmt.Errorf("look up media: %w", err)
 	}
 
-	return filename, nil
+	created, err := media.Acquire(m)
+	if err != nil {
+		return nil, false, err
+	}
+	// The row found above was released and its file deleted before the
+	// reference was taken, so the file has to be stored after all.
+	if created && duplicate {
+		if err := putUpload(store, m, open); err != nil {
+			media.Release(m.Filename, func() error { return nil })
+			return nil, false, err
+		}
+		duplicate = false
+	}
+	return m, duplicate, nil
 }
 
-func HandleUploadFromFileHeader(fh *multipart.FileHeader, storagePath string) (string, error) {
-	file, err := fh.Open()
+func putUpload(store storage.Storage, m *models.Media, open func() (io.ReadCloser, error)) error {
+	rc, err := open()
 	if err != nil {
-		return "", fmt.Errorf("open file header: %w", err)
+		return fmt.Errorf("open upload: %w", err)
 	}
-	defer file.Close()
-
-	ext := strings.ToLower(filepath.Ext(fh.Filename))
-	allowed := map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
-	if !allowed[ext] {
-		return "", fmt.Errorf("file type %s not allowed", ext)
+	defer rc.Close()
+	if err := store.Put(m.Filename, rc, m.Size, m.ContentType); err != nil {
+		return fmt.Errorf("save file: %w", err)
 	}
+	return nil
+}
 
-	if err := os.MkdirAll(storagePath, 0755); err != nil {
-		return "", fmt.Errorf("create upload dir: %w", err)
+func hashUpload(open func() (io.ReadCloser, error)) (string, int64, error) {
+	rc, err := open()
+	if err != nil {
+		return "", 0, fmt.Errorf("open upload: %w", err)
 	}
-
-	filename := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-	destPath := filepath.Join(storagePath, filename)
-
-	dst, err := os.Create(destPath)
+	defer rc.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, rc)
 	if err != nil {
-		return "", fmt.Errorf("create destination file: %w", err)
+		return "", 0, fmt.Errorf("read upload: %w", err)
 	}
-	defer dst.Close()
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
 
-	if _, err := io.Copy(dst, file); err != nil {
-		return "", fmt.Errorf("save file: %w", err)
+// ReleaseUpload drops a reference to a stored file and deletes the file once
+// nothing uses it anymore.
+func ReleaseUpload(store storage.Storage, media *models.MediaStore, filename string) error {
+	if filename == "" {
+		return nil
+	}
+	if err := media.Release(filename, func() error { return store.Delete(filename) }); err != nil {
+		return fmt.Errorf("release %s: %w", filename, err)
 	}
+	return nil
+}
 
-	return filename, nil
+// ReferencedUploads returns the set of stored filenames that are still in use
+// by gallery images or article covers.
+func ReferencedUploads(articles *models.ArticleStore, galleries *models.GalleryStore) (map[string]bool, error) {
+	referenced := make(map[string]bool)
+	covers, err := articles.CoverImages()
+	if err != nil {
+		return nil, fmt.Errorf("list article covers: %w", err)
+	}
+	for _, name := range covers {
+		referenced[name] = true
+	}
+	filenames, err := galleries.ImageFilenames()
+	if err != nil {
+		return nil, fmt.Errorf("list image files: %w", err)
+	}
+	for _, name := range filenames {
+		referenced[name] = true
+	}
+	return referenced, nil
 }
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+)
+
+// inlineTypes are the upload types browsers may display. Anything else is
+// sent as a download so a stray file can never run as a page on our origin.
+var inlineTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+	"image/avif": true,
+}
+
+// UploadsHandler serves uploaded files from the configured storage backend.
+// Only files recorded in the media library or used by an article or gallery
+// are served; directories are never listed.
+type UploadsHandler struct {
+	Storage storage.Storage
+	Media   *models.MediaStore
+	// CanViewPrivate reports whether the request may download files that
+	// are not public yet, such as covers of unpublished articles.
+	CanViewPrivate func(r *http.Request) bool
+}
+
+func (h *UploadsHandler) Serve(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "*")
+	if !storage.ValidKey(key) || strings.HasPrefix(key, ".") {
+		http.NotFound(w, r)
+		return
+	}
+
+	access, err := h.Media.Access(key)
+	if err != nil {
+		log.Printf("error checking access to upload %s: %v", key, err)
+		http.Error(w, T(r, "error.internal_plain"), 500)
+		return
+	}
+	// Private files are reported missing rather than forbidden so their
+	// names cannot be probed.
+	private := !access.Public
+	if !access.Known || (private && (h.CanViewPrivate == nil || !h.CanViewPrivate(r))) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Backends with a public address (e.g. a public bucket or CDN) serve
+	// public files themselves; private ones always go through access checks.
+	if u := h.Storage.URL(key); u != r.URL.Path && !private {
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+
+	rc, info, err := h.Storage.Get(key)
+	if err == storage.ErrNotExist {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("error reading upload %s: %v", key, err)
+		http.Error(w, T(r, "error.internal_plain"), 500)
+		return
+	}
+	defer rc.Close()
+
+	// Content-addressed files never change under the same name. Older
+	// uploads keep their original names and are revalidated daily.
+	etag := ""
+	switch {
+	case private:
+		w.Header().Set("Cache-Control", "private, no-cache")
+	case contentAddressedName.MatchString(key):
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	if contentAddressedName.MatchString(key) {
+		etag = `"` + strings.TrimSuffix(key, path.Ext(key)) + `"`
+		w.Header().Set("ETag", etag)
+	}
+
+	contentType := uploadContentType(key, access.ContentType, info.ContentType)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if !inlineTypes[contentType] {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": key}))
+	}
+
+	// Local files can be seeked; objects of other backends are read in
+	// ranges on demand. Either way the standard library handles Range and
+	// conditional requests.
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, key, info.ModTime, rs)
+		return
+	}
+	if ranger, ok := h.Storage.(storage.Ranger); ok && info.Size > 0 {
+		obj := &rangeReader{storage: ranger, key: key, size: info.Size, rc: rc}
+		defer obj.Close()
+		http.ServeContent(w, r, key, info.ModTime, obj)
+		return
+	}
+
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if notModifiedSince(r, etag, info.ModTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if info.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	io.Copy(w, rc)
+}
+
+// uploadContentType picks the type recorded at upload, then the one the
+// file name implies, then the backend's; never one sniffed from content.
+func uploadContentType(key, recorded, backend string) string {
+	for _, t := range []string{recorded, mime.TypeByExtension(path.Ext(key)), backend} {
+		if mediaType, _, err := mime.ParseMediaType(t); err == nil {
+			return mediaType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// rangeReader lets http.ServeContent seek in an object that can only be
+// streamed. It reads from the reader of the whole object while that is at
+// the right position and opens a range of the object otherwise.
+type rangeReader struct {
+	storage storage.Ranger
+	key     string
+	size    int64
+	offset  int64
+	// rc is positioned at pos; opened is set once it came from GetRange and
+	// must be closed here rather than by the caller.
+	rc     io.ReadCloser
+	pos    int64
+	opened bool
+}
+
+func (o *rangeReader) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.rc == nil || o.pos != o.offset {
+		o.Close()
+		rc, err := o.storage.GetRange(o.key, o.offset, o.size-o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.rc, o.pos, o.opened = rc, o.offset, true
+	}
+	n, err := o.rc.Read(p)
+	o.offset += int64(n)
+	o.pos += int64(n)
+	return n, err
+}
+
+func (o *rangeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	}
+	if offset < 0 {
+		return 0, errors.New("uploads: negative position")
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *rangeReader) Close() error {
+	if !o.opened {
+		return nil
+	}
+	o.opened = false
+	return o.rc.Close()
+}
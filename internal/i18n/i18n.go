@@ -0,0 +1,273 @@
+// Package i18n holds the message catalogs of the user interface and picks
+// the text of a message in a language, including its plural form.
+//
+// Catalogs are flat JSON objects, one file per language (e.g. "sk.json"),
+// mapping message keys to text. Text may contain named placeholders such
+// as "{name}". A message with plural forms is an object of forms instead of
+// a string:
+//
+//	"comments.count": {"one": "{count} komentár", "few": "{count} komentáre", "other": "{count} komentárov"}
+//
+// The form is chosen by the "count" argument.
+package i18n
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Plural forms a message may define. Which of them a language uses is
+// decided by its plural rule; "other" is required.
+const (
+	One   = "one"
+	Few   = "few"
+	Other = "other"
+)
+
+// message is the text of one key; a message without plural forms has only
+// Other.
+type message map[string]string
+
+type catalog map[string]message
+
+// Bundle is the set of catalogs. The fallback language is used for keys a
+// catalog lacks, which the tests make sure does not happen.
+type Bundle struct {
+	catalogs map[string]catalog
+	names    map[string]string
+	fallback string
+
+	missing sync.Map // keys already reported as missing
+}
+
+// Load reads every "<lang>.json" in dir of fsys. fallback must be one of
+// them.
+func Load(fsys fs.FS, dir, fallback string) (*Bundle, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read catalogs: %w", err)
+	}
+	b := &Bundle{catalogs: make(map[string]catalog), names: make(map[string]string), fallback: fallback}
+	for _, e := range entries {
+		lang, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() {
+			continue
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read catalog %s: %w", lang, err)
+		}
+		c, err := parseCatalog(data)
+		if err != nil {
+			return nil, fmt.Errorf("catalog %s: %w", lang, err)
+		}
+		b.catalogs[lang] = c
+		b.names[lang] = c["language.name"][Other]
+	}
+	if b.catalogs[fallback] == nil {
+		return nil, fmt.Errorf("no catalog for fallback language %q", fallback)
+	}
+	return b, nil
+}
+
+func parseCatalog(data []byte) (catalog, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	c := make(catalog, len(raw))
+	for key, value := range raw {
+		var text string
+		if err := json.Unmarshal(value, &text); err == nil {
+			c[key] = message{Other: text}
+			continue
+		}
+		var forms message
+		if err := json.Unmarshal(value, &forms); err != nil {
+			return nil, fmt.Errorf("%s: neither text nor plural forms", key)
+		}
+		if forms[Other] == "" {
+			return nil, fmt.Errorf("%s: plural forms lack %q", key, Other)
+		}
+		for form := range forms {
+			if form != One && form != Few && form != Other {
+				return nil, fmt.Errorf("%s: unknown plural form %q", key, form)
+			}
+		}
+		c[key] = forms
+	}
+	return c, nil
+}
+
+// Language is a language with a catalog, for language pickers.
+type Language struct {
+	Code string
+	Name string // in the language itself, e.g. "Čeština"
+}
+
+// Languages lists the languages with a catalog, fallback first.
+func (b *Bundle) Languages() []Language {
+	langs := make([]Language, 0, len(b.catalogs))
+	for code := range b.catalogs {
+		langs = append(langs, Language{Code: code, Name: b.names[code]})
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if (langs[i].Code == b.fallback) != (langs[j].Code == b.fallback) {
+			return langs[i].Code == b.fallback
+		}
+		return langs[i].Code < langs[j].Code
+	})
+	return langs
+}
+
+// Has reports whether lang has a catalog.
+func (b *Bundle) Has(lang string) bool {
+	return b.catalogs[lang] != nil
+}
+
+// Fallback returns the language used when none is chosen.
+func (b *Bundle) Fallback() string {
+	return b.fallback
+}
+
+// Keys returns the sorted message keys of lang's catalog.
+func (b *Bundle) Keys(lang string) []string {
+	keys := make([]string, 0, len(b.catalogs[lang]))
+	for key := range b.catalogs[lang] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Forms returns the plural forms lang's catalog defines for key; a message
+// without plural forms has only Other.
+func (b *Bundle) Forms(lang, key string) []string {
+	var forms []string
+	for form := range b.catalogs[lang][key] {
+		forms = append(forms, form)
+	}
+	sort.Strings(forms)
+	return forms
+}
+
+// Localizer returns the localizer for lang, or for the fallback language
+// if lang has no catalog.
+func (b *Bundle) Localizer(lang string) *Localizer {
+	if !b.Has(lang) {
+		lang = b.fallback
+	}
+	return &Localizer{bundle: b, lang: lang}
+}
+
+// Localizer translates messages into one language.
+type Localizer struct {
+	bundle *Bundle
+	lang   string
+}
+
+// Lang returns the language code, e.g. "sk".
+func (l *Localizer) Lang() string {
+	if l == nil {
+		return ""
+	}
+	return l.lang
+}
+
+// T returns the text of key with the placeholders replaced by args, which
+// are name/value pairs: T("comments.count", "count", 3). The "count"
+// argument also chooses the plural form. Unknown keys are returned as they
+// are, so a missing translation shows up on the page instead of blank text.
+func (l *Localizer) T(key string, args ...interface{}) string {
+	if l == nil {
+		return key
+	}
+	msg, ok := l.bundle.catalogs[l.lang][key]
+	if !ok {
+		l.bundle.reportMissing(l.lang, key)
+		if msg, ok = l.bundle.catalogs[l.bundle.fallback][key]; !ok {
+			return key
+		}
+	}
+
+	text := msg[Other]
+	for i := 0; i+1 < len(args); i += 2 {
+		if name, _ := args[i].(string); name == "count" {
+			if n, ok := toInt(args[i+1]); ok {
+				if form := msg[pluralForm(l.lang, n)]; form != "" {
+					text = form
+				}
+			}
+		}
+	}
+	if !strings.Contains(text, "{") {
+		return text
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		name, _ := args[i].(string)
+		text = strings.ReplaceAll(text, "{"+name+"}", fmt.Sprint(args[i+1]))
+	}
+	return text
+}
+
+func (b *Bundle) reportMissing(lang, key string) {
+	if _, reported := b.missing.LoadOrStore(lang+"\x00"+key, true); !reported {
+		log.Printf("i18n: no %s translation for %q", lang, key)
+	}
+}
+
+// pluralForm is the CLDR plural rule for whole numbers: Slovak and Czech
+// say 1 komentár, 2–4 komentáre, 5 komentárov; English has one and other.
+func pluralForm(lang string, n int64) string {
+	if n < 0 {
+		n = -n
+	}
+	switch {
+	case n == 1:
+		return One
+	case (lang == "sk" || lang == "cs") && n >= 2 && n <= 4:
+		return Few
+	}
+	return Other
+}
+
+func toInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case uint:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case string:
+		i, err := strconv.ParseInt(n, 10, 64)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+type contextKey struct{}
+
+// WithLocalizer returns a copy of ctx carrying l.
+func WithLocalizer(ctx context.Context, l *Localizer) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the localizer of ctx. It may be nil, whose T returns
+// keys unchanged.
+func FromContext(ctx context.Context) *Localizer {
+	l, _ := ctx.Value(contextKey{}).(*Localizer)
+	return l
+}
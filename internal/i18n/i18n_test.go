@@ -0,0 +1,147 @@
+package i18n
+
+import (
+	"regexp"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	charon "github.com/lukas-pastva/web-charon"
+)
+
+func loadCatalogs(t *testing.T) *Bundle {
+	t.Helper()
+	b, err := Load(charon.LocalesFS, "locales", "sk")
+	if err != nil {
+		t.Fatalf("load catalogs: %v", err)
+	}
+	return b
+}
+
+// TestCatalogsHaveSameKeys makes sure no page falls back to another
+// language or shows a bare key.
+func TestCatalogsHaveSameKeys(t *testing.T) {
+	b := loadCatalogs(t)
+	if len(b.Languages()) < 2 {
+		t.Fatalf("expected several catalogs, got %v", b.Languages())
+	}
+	want := b.Keys(b.Fallback())
+	for _, lang := range b.Languages() {
+		got := b.Keys(lang.Code)
+		for _, key := range want {
+			if _, ok := slices.BinarySearch(got, key); !ok {
+				t.Errorf("%s: missing %q", lang.Code, key)
+			}
+		}
+		for _, key := range got {
+			if _, ok := slices.BinarySearch(want, key); !ok {
+				t.Errorf("%s: %q is not in the %s catalog", lang.Code, key, b.Fallback())
+			}
+		}
+		if lang.Name == "" {
+			t.Errorf("%s: missing language.name", lang.Code)
+		}
+	}
+}
+
+// TestPluralForms checks that every plural message has exactly the forms
+// its language's plural rule picks.
+func TestPluralForms(t *testing.T) {
+	b := loadCatalogs(t)
+	for _, lang := range b.Languages() {
+		var rule []string
+		for n := int64(0); n <= 200; n++ {
+			if form := pluralForm(lang.Code, n); !slices.Contains(rule, form) {
+				rule = append(rule, form)
+			}
+		}
+		slices.Sort(rule)
+		for _, key := range b.Keys(lang.Code) {
+			forms := b.Forms(lang.Code, key)
+			plural := len(forms) > 1 || b.Forms(b.Fallback(), key)[0] != Other
+			if plural && !slices.Equal(forms, rule) {
+				t.Errorf("%s: %q has forms %v, want %v", lang.Code, key, forms, rule)
+			}
+		}
+	}
+}
+
+var placeholder = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// TestPlaceholders checks that translations use the same placeholders as
+// the fallback catalog, so no argument is lost or left unreplaced.
+func TestPlaceholders(t *testing.T) {
+	b := loadCatalogs(t)
+	names := func(lang, key string) []string {
+		var found []string
+		for _, text := range b.catalogs[lang][key] {
+			for _, name := range placeholder.FindAllString(text, -1) {
+				if !slices.Contains(found, name) {
+					found = append(found, name)
+				}
+			}
+		}
+		slices.Sort(found)
+		return found
+	}
+	for _, lang := range b.Languages() {
+		for _, key := range b.Keys(b.Fallback()) {
+			if got, want := names(lang.Code, key), names(b.Fallback(), key); !slices.Equal(got, want) {
+				t.Errorf("%s: %q has placeholders %v, want %v", lang.Code, key, got, want)
+			}
+		}
+	}
+}
+
+func TestLocalizer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"l/sk.json": {Data: []byte(`{"language.name": "Slovenčina", "n": {"one": "{count} fotka", "few": "{count} fotky", "other": "{count} fotiek"}, "hi": "Ahoj, {name}!", "only.sk": "len po slovensky"}`)},
+		"l/en.json": {Data: []byte(`{"language.name": "English", "n": {"one": "{count} photo", "other": "{count} photos"}, "hi": "Hi, {name}!"}`)},
+	}
+	b, err := Load(fsys, "l", "sk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, en := b.Localizer("sk"), b.Localizer("en")
+	tests := []struct {
+		l    *Localizer
+		key  string
+		args []interface{}
+		want string
+	}{
+		{sk, "n", []interface{}{"count", 1}, "1 fotka"},
+		{sk, "n", []interface{}{"count", 3}, "3 fotky"},
+		{sk, "n", []interface{}{"count", 5}, "5 fotiek"},
+		{sk, "n", []interface{}{"count", 0}, "0 fotiek"},
+		{en, "n", []interface{}{"count", 1}, "1 photo"},
+		{en, "n", []interface{}{"count", 3}, "3 photos"},
+		{en, "hi", []interface{}{"name", "<b>"}, "Hi, <b>!"},
+		{en, "only.sk", nil, "len po slovensky"},
+		{en, "unknown", nil, "unknown"},
+		{nil, "hi", nil, "hi"},
+	}
+	for _, tt := range tests {
+		if got := tt.l.T(tt.key, tt.args...); got != tt.want {
+			t.Errorf("%s: T(%q, %v) = %q, want %q", tt.l.Lang(), tt.key, tt.args, got, tt.want)
+		}
+	}
+	if got := b.Localizer("de").Lang(); got != "sk" {
+		t.Errorf("unknown language uses %q, want the fallback", got)
+	}
+	if langs := b.Languages(); len(langs) != 2 || langs[0].Code != "sk" || langs[1].Name != "English" {
+		t.Errorf("Languages() = %v", langs)
+	}
+}
+
+func TestLoadRejectsBadPlurals(t *testing.T) {
+	for _, data := range []string{
+		`{"n": {"one": "x"}}`,
+		`{"n": {"one": "x", "many": "y", "other": "z"}}`,
+		`{"n": 3}`,
+	} {
+		fsys := fstest.MapFS{"l/sk.json": {Data: []byte(data)}}
+		if _, err := Load(fsys, "l", "sk"); err == nil {
+			t.Errorf("Load(%s) succeeded", data)
+		}
+	}
+}
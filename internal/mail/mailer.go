@@ -0,0 +1,176 @@
+package mail
+
+import (
+	"log"
+	"time"
+
+	"github.com/lukas-pastva/web-charon/internal/models"
+)
+
+// retryDelays is how long to wait after each failed attempt. A message is
+// given up on once every delay has been used.
+var retryDelays = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	6 * time.Hour,
+}
+
+const (
+	pollInterval = 30 * time.Second
+	batchSize    = 20
+	// sentRetention is how long delivered messages are kept for reference.
+	sentRetention = 30 * 24 * time.Hour
+)
+
+// Mailer queues rendered emails in the outbox and delivers them in the
+// background. With a nil Sender mail is disabled: nothing is queued, so
+// configuring SMTP later does not flush a backlog of stale messages.
+type Mailer struct {
+	Outbox   *models.OutboxStore
+	Renderer *Renderer
+	Sender   Sender
+	From     string
+	BaseURL  string
+	// SiteLanguage returns the language of mail to users who did not choose
+	// one. Without it the catalogs' fallback language is used.
+	SiteLanguage func() string
+	// wake lets Queue start delivery without waiting for the next poll.
+	wake chan struct{}
+}
+
+func NewMailer(outbox *models.OutboxStore, renderer *Renderer, sender Sender, from, baseURL string) *Mailer {
+	return &Mailer{
+		Outbox:   outbox,
+		Renderer: renderer,
+		Sender:   sender,
+		From:     from,
+		BaseURL:  baseURL,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Enabled reports whether queued mail is actually delivered.
+func (m *Mailer) Enabled() bool {
+	return m != nil && m.Sender != nil
+}
+
+// Queue renders the named template in the user's language and stores the
+// message for their address in the outbox. data is exposed to the template
+// as .Data next to .BaseURL.
+func (m *Mailer) Queue(to *models.User, name string, data interface{}) error {
+	return m.queue(to, name, data, false)
+}
+
+// QueueSensitive is like Queue for messages containing secrets, e.g.
+// password reset links. Their text is not kept after delivery.
+func (m *Mailer) QueueSensitive(to *models.User, name string, data interface{}) error {
+	return m.queue(to, name, data, true)
+}
+
+func (m *Mailer) queue(to *models.User, name string, data interface{}, sensitive bool) error {
+	if !m.Enabled() || to.Email == "" {
+		return nil
+	}
+	lang := to.Language
+	if lang == "" && m.SiteLanguage != nil {
+		lang = m.SiteLanguage()
+	}
+	msg, err := m.Renderer.Render(lang, name, map[string]interface{}{
+		"BaseURL": m.BaseURL,
+		"Data":    data,
+	})
+	if err != nil {
+		return err
+	}
+	if err := m.Outbox.Enqueue(&models.OutboxMessage{
+		To:        to.Email,
+		Subject:   msg.Subject,
+		TextBody:  msg.Text,
+		HTMLBody:  msg.HTML,
+		Sensitive: sensitive,
+	}); err != nil {
+		return err
+	}
+	m.Wake()
+	return nil
+}
+
+// Wake starts a delivery run without waiting for the next poll.
+func (m *Mailer) Wake() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due messages until the process exits.
+func (m *Mailer) Run() {
+	if !m.Enabled() {
+		log.Println("mail: SMTP_HOST not set, email is disabled")
+		return
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		m.deliverDue()
+		if time.Since(lastCleanup) > time.Hour {
+			if n, err := m.Outbox.DeleteSentBefore(time.Now().Add(-sentRetention)); err != nil {
+				log.Printf("mail: cleanup failed: %v", err)
+			} else if n > 0 {
+				log.Printf("mail: removed %d old sent messages", n)
+			}
+			lastCleanup = time.Now()
+		}
+		select {
+		case <-ticker.C:
+		case <-m.wake:
+		}
+	}
+}
+
+func (m *Mailer) deliverDue() {
+	for {
+		due, err := m.Outbox.Due(batchSize)
+		if err != nil {
+			log.Printf("mail: loading outbox failed: %v", err)
+			return
+		}
+		for _, om := range due {
+			m.deliver(om)
+		}
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+func (m *Mailer) deliver(om models.OutboxMessage) {
+	err := m.Sender.Send(&Message{
+		From:    m.From,
+		To:      om.To,
+		Subject: om.Subject,
+		Text:    om.TextBody,
+		HTML:    om.HTMLBody,
+	})
+	if err == nil {
+		if err := m.Outbox.MarkSent(om.ID); err != nil {
+			log.Printf("mail: marking message %d as sent failed: %v", om.ID, err)
+		}
+		return
+	}
+
+	final := om.Attempts >= len(retryDelays)
+	var delay time.Duration
+	if final {
+		log.Printf("mail: giving up on message %d to %s after %d attempts: %v", om.ID, om.To, om.Attempts+1, err)
+	} else {
+		delay = retryDelays[om.Attempts]
+		log.Printf("mail: sending message %d to %s failed, retrying in %s: %v", om.ID, om.To, delay, err)
+	}
+	if err := m.Outbox.MarkAttemptFailed(om.ID, err.Error(), delay, final); err != nil {
+		log.Printf("mail: recording failure of message %d failed: %v", om.ID, err)
+	}
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"log"
+
+	"github.com/lukas-pastva/web-charon/internal/events"
+	"github.com/lukas-pastva/web-charon/internal/models"
+)
+
+// Subscribe emails every user who asked to hear about comments waiting for
+// moderation whenever a visitor posts one.
+func (m *Mailer) Subscribe(bus *events.Bus, users *models.UserStore) {
+	events.Subscribe(bus, "comment notifications", events.Async, func(e events.CommentCreated) error {
+		if e.Comment.Status != models.CommentPending {
+			return nil
+		}
+		subscribers, err := users.GetCommentSubscribers()
+		if err != nil {
+			return err
+		}
+		data := map[string]interface{}{
+			"TargetKind":  e.Comment.Target().Kind,
+			"TargetTitle": e.Title,
+			"TargetPath":  e.Path,
+			"AuthorName":  e.Comment.AuthorName,
+			"Content":     e.Comment.Content,
+			"IsReply":     e.Comment.ParentID != nil,
+		}
+		for _, u := range subscribers {
+			if err := m.Queue(&u, "new_comment", data); err != nil {
+				log.Printf("error queueing comment notification for %s: %v", u.Nickname, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,127 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/lukas-pastva/web-charon/internal/i18n"
+)
+
+// Renderer builds messages from the embedded mail templates. Every email
+// has a NAME.txt template, which also defines the "subject" block, and an
+// optional NAME.html template rendered inside base.html. The templates are
+// parsed once per language of the message catalogs and get the text through
+// T, like the page templates.
+type Renderer struct {
+	bundle *i18n.Bundle
+	text   map[string]map[string]*texttemplate.Template // by language, then name
+	html   map[string]map[string]*htmltemplate.Template
+}
+
+// NewRenderer parses the templates in dir of fsys for every language of
+// bundle.
+func NewRenderer(fsys fs.FS, dir string, bundle *i18n.Bundle) (*Renderer, error) {
+	r := &Renderer{
+		bundle: bundle,
+		text:   make(map[string]map[string]*texttemplate.Template),
+		html:   make(map[string]map[string]*htmltemplate.Template),
+	}
+	for _, lang := range bundle.Languages() {
+		text, html, err := parseMailTemplates(fsys, dir, bundle.Localizer(lang.Code))
+		if err != nil {
+			return nil, err
+		}
+		r.text[lang.Code], r.html[lang.Code] = text, html
+	}
+	return r, nil
+}
+
+func parseMailTemplates(fsys fs.FS, dir string, loc *i18n.Localizer) (map[string]*texttemplate.Template, map[string]*htmltemplate.Template, error) {
+	text := make(map[string]*texttemplate.Template)
+	html := make(map[string]*htmltemplate.Template)
+
+	textFiles, err := fs.Glob(fsys, dir+"/*.txt")
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range textFiles {
+		name := strings.TrimSuffix(path.Base(file), ".txt")
+		t, err := texttemplate.New(path.Base(file)).Funcs(texttemplate.FuncMap{"T": loc.T}).ParseFS(fsys, file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse mail template %s: %w", file, err)
+		}
+		if t.Lookup("subject") == nil {
+			return nil, nil, fmt.Errorf("mail template %s does not define a subject", file)
+		}
+		text[name] = t
+	}
+
+	base, err := htmltemplate.New("").Funcs(htmltemplate.FuncMap{
+		"T":    loc.T,
+		"lang": loc.Lang,
+		// Thtml is T for messages with markup; the catalogs are trusted,
+		// the arguments are escaped.
+		"Thtml": func(key string, args ...interface{}) htmltemplate.HTML {
+			for i := 1; i < len(args); i += 2 {
+				args[i] = htmltemplate.HTMLEscapeString(fmt.Sprint(args[i]))
+			}
+			return htmltemplate.HTML(loc.T(key, args...))
+		},
+	}).ParseFS(fsys, dir+"/base.html")
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse mail base template: %w", err)
+	}
+	for name := range text {
+		file := dir + "/" + name + ".html"
+		if _, err := fs.Stat(fsys, file); err != nil {
+			continue
+		}
+		clone, err := base.Clone()
+		if err != nil {
+			return nil, nil, err
+		}
+		t, err := clone.ParseFS(fsys, file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("parse mail template %s: %w", file, err)
+		}
+		html[name] = t
+	}
+	return text, html, nil
+}
+
+// Render builds the named email for data in lang, or in the fallback
+// language if lang has no catalog. The To and From fields are left for the
+// caller.
+func (r *Renderer) Render(lang, name string, data interface{}) (*Message, error) {
+	if !r.bundle.Has(lang) {
+		lang = r.bundle.Fallback()
+	}
+	tt, ok := r.text[lang][name]
+	if !ok {
+		return nil, fmt.Errorf("unknown mail template %q", name)
+	}
+	var subject, text bytes.Buffer
+	if err := tt.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, fmt.Errorf("render %s subject: %w", name, err)
+	}
+	if err := tt.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return nil, fmt.Errorf("render %s text: %w", name, err)
+	}
+	m := &Message{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}
+	if ht, ok := r.html[lang][name]; ok {
+		var html bytes.Buffer
+		if err := ht.ExecuteTemplate(&html, "mail_base", data); err != nil {
+			return nil, fmt.Errorf("render %s html: %w", name, err)
+		}
+		m.HTML = html.String()
+	}
+	return m, nil
+}
@@ -0,0 +1,136 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// API token scopes. Each one grants the same actions on its area as the
+// HTML administration.
+const (
+	ScopeArticles  = "articles"
+	ScopeGalleries = "galleries"
+	ScopeComments  = "comments"
+)
+
+// APIScopes lists the scopes in the order they are offered on the profile.
+var APIScopes = []string{ScopeArticles, ScopeGalleries, ScopeComments}
+
+// apiTokenPrefix marks Charon tokens so they are easy to recognise, e.g. by
+// secret scanners.
+const apiTokenPrefix = "chr_"
+
+// APIToken is a personal access token for the admin API. Like UserToken
+// only the SHA-256 hash is stored; the start of the token is kept so users
+// can tell their tokens apart.
+type APIToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Prefix     string
+	Scopes     []string
+	ExpiresAt  *time.Time // nil for tokens that never expire
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func (t APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+type APITokenStore struct {
+	DB *sql.DB
+}
+
+const apiTokenColumns = "id, user_id, name, token_prefix, scopes, expires_at, last_used_at, created_at"
+
+// Create issues a token for t.UserID and returns its plain value, which is
+// shown to the user once. A zero ttl creates a token that never expires.
+func (s *APITokenStore) Create(t *APIToken, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	t.Prefix = token[:len(apiTokenPrefix)+6]
+
+	seconds := int64(ttl.Seconds())
+	res, err := s.DB.Exec("INSERT INTO api_tokens (user_id, name, token_hash, token_prefix, scopes, expires_at) VALUES (?, ?, ?, ?, ?, IF(? > 0, DATE_ADD(NOW(), INTERVAL ? SECOND), NULL))",
+		t.UserID, t.Name, hashToken(token), t.Prefix, strings.Join(t.Scopes, ","), seconds, seconds)
+	if err != nil {
+		return "", fmt.Errorf("insert api token: %w", err)
+	}
+	t.ID, _ = res.LastInsertId()
+	return token, nil
+}
+
+// GetByUser returns the user's tokens, newest first, including expired ones.
+func (s *APITokenStore) GetByUser(userID int64) ([]APIToken, error) {
+	rows, err := s.DB.Query("SELECT "+apiTokenColumns+" FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		if err := scanAPIToken(rows, &t); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// Authenticate returns the unexpired token with the given plain value and
+// records that it was used.
+func (s *APITokenStore) Authenticate(token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrTokenInvalid
+	}
+	t := &APIToken{}
+	err := scanAPIToken(s.DB.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ? AND (expires_at IS NULL OR expires_at > NOW())", hashToken(token)), t)
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.DB.Exec("UPDATE api_tokens SET last_used_at = NOW() WHERE id = ?", t.ID); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Delete revokes one of the user's tokens.
+func (s *APITokenStore) Delete(userID, id int64) error {
+	_, err := s.DB.Exec("DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID)
+	return err
+}
+
+func scanAPIToken(row rowScanner, t *APIToken) error {
+	var scopes string
+	if err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.ExpiresAt, &t.LastUsedAt, &t.CreatedAt); err != nil {
+		return err
+	}
+	t.Scopes = nil
+	for _, s := range strings.Split(scopes, ",") {
+		if s != "" {
+			t.Scopes = append(t.Scopes, s)
+		}
+	}
+	return nil
+}
@@ -15,16 +15,29 @@ type Article struct {
 	CoverImage      string
 	Published       bool
 	CommentsEnabled bool
+	CommentsClosed  bool       // existing comments stay visible, new ones are refused
+	PublishedAt     *time.Time // first publication, nil for drafts
 	CreatedAt       time.Time
 	UpdatedAt       time.Time
 }
 
+// CommentsAutoClosed reports whether comments closed automatically because
+// the article was published more than days ago. Zero days disables it.
+func (a Article) CommentsAutoClosed(days int, now time.Time) bool {
+	if days <= 0 || a.PublishedAt == nil {
+		return false
+	}
+	return now.After(a.PublishedAt.AddDate(0, 0, days))
+}
+
 type ArticleStore struct {
 	DB *sql.DB
 }
 
+const articleColumns = "id, title, slug, content, excerpt, cover_image, published, comments_enabled, comments_closed, published_at, created_at, updated_at"
+
 func (s *ArticleStore) GetAll() ([]Article, error) {
-	rows, err := s.DB.Query("SELECT id, title, slug, content, excerpt, cover_image, published, comments_enabled, created_at, updated_at FROM articles ORDER BY created_at DESC")
+	rows, err := s.DB.Query("SELECT " + articleColumns + " FROM articles ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +46,7 @@ func (s *ArticleStore) GetAll() ([]Article, error) {
 }
 
 func (s *ArticleStore) GetPublished() ([]Article, error) {
-	rows, err := s.DB.Query("SELECT id, title, slug, content, excerpt, cover_image, published, comments_enabled, created_at, updated_at FROM articles WHERE published = TRUE ORDER BY created_at DESC")
+	rows, err := s.DB.Query("SELECT " + articleColumns + " FROM articles WHERE published = TRUE ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -47,7 +60,7 @@ func (s *ArticleStore) GetPublishedPaginated(limit, offset int) ([]Article, int,
 	if err != nil {
 		return nil, 0, err
 	}
-	rows, err := s.DB.Query("SELECT id, title, slug, content, excerpt, cover_image, published, comments_enabled, created_at, updated_at FROM articles WHERE published = TRUE ORDER BY created_at DESC LIMIT ? OFFSET ?", limit, offset)
+	rows, err := s.DB.Query("SELECT "+articleColumns+" FROM articles WHERE published = TRUE ORDER BY created_at DESC LIMIT ? OFFSET ?", limit, offset)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -58,8 +71,7 @@ func (s *ArticleStore) GetPublishedPaginated(limit, offset int) ([]Article, int,
 
 func (s *ArticleStore) GetBySlug(slug string) (*Article, error) {
 	a := &Article{}
-	err := s.DB.QueryRow("SELECT id, title, slug, content, excerpt, cover_image, published, comments_enabled, created_at, updated_at FROM articles WHERE slug = ?", slug).
-		Scan(&a.ID, &a.Title, &a.Slug, &a.Content, &a.Excerpt, &a.CoverImage, &a.Published, &a.CommentsEnabled, &a.CreatedAt, &a.UpdatedAt)
+	err := scanArticle(s.DB.QueryRow("SELECT "+articleColumns+" FROM articles WHERE slug = ?", slug), a)
 	if err != nil {
 		return nil, err
 	}
@@ -68,8 +80,7 @@ func (s *ArticleStore) GetBySlug(slug string) (*Article, error) {
 
 func (s *ArticleStore) GetByID(id int64) (*Article, error) {
 	a := &Article{}
-	err := s.DB.QueryRow("SELECT id, title, slug, content, excerpt, cover_image, published, comments_enabled, created_at, updated_at FROM articles WHERE id = ?", id).
-		Scan(&a.ID, &a.Title, &a.Slug, &a.Content, &a.Excerpt, &a.CoverImage, &a.Published, &a.CommentsEnabled, &a.CreatedAt, &a.UpdatedAt)
+	err := scanArticle(s.DB.QueryRow("SELECT "+articleColumns+" FROM articles WHERE id = ?", id), a)
 	if err != nil {
 		return nil, err
 	}
@@ -77,8 +88,8 @@ func (s *ArticleStore) GetByID(id int64) (*Article, error) {
 }
 
 func (s *ArticleStore) Create(a *Article) error {
-	res, err := s.DB.Exec("INSERT INTO articles (title, slug, content, excerpt, cover_image, published, comments_enabled) VALUES (?, ?, ?, ?, ?, ?, ?)",
-		a.Title, a.Slug, a.Content, a.Excerpt, a.CoverImage, a.Published, a.CommentsEnabled)
+	res, err := s.DB.Exec("INSERT INTO articles (title, slug, content, excerpt, cover_image, published, comments_enabled, comments_closed, published_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, IF(?, NOW(), NULL))",
+		a.Title, a.Slug, a.Content, a.Excerpt, a.CoverImage, a.Published, a.CommentsEnabled, a.CommentsClosed, a.Published)
 	if err != nil {
 		return fmt.Errorf("insert article: %w", err)
 	}
@@ -87,8 +98,10 @@ func (s *ArticleStore) Create(a *Article) error {
 }
 
 func (s *ArticleStore) Update(a *Article) error {
-	_, err := s.DB.Exec("UPDATE articles SET title=?, slug=?, content=?, excerpt=?, cover_image=?, published=?, comments_enabled=? WHERE id=?",
-		a.Title, a.Slug, a.Content, a.Excerpt, a.CoverImage, a.Published, a.CommentsEnabled, a.ID)
+	// published_at keeps the first publication date when an article is
+	// unpublished and published again.
+	_, err := s.DB.Exec("UPDATE articles SET title=?, slug=?, content=?, excerpt=?, cover_image=?, published=?, comments_enabled=?, comments_closed=?, published_at=IF(?, COALESCE(published_at, NOW()), published_at) WHERE id=?",
+		a.Title, a.Slug, a.Content, a.Excerpt, a.CoverImage, a.Published, a.CommentsEnabled, a.CommentsClosed, a.Published, a.ID)
 	return err
 }
 
@@ -97,14 +110,50 @@ func (s *ArticleStore) Delete(id int64) error {
 	return err
 }
 
+// CoverImages returns the filenames of all article cover images.
+func (s *ArticleStore) CoverImages() ([]string, error) {
+	rows, err := s.DB.Query("SELECT cover_image FROM articles WHERE cover_image <> ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanStrings(rows)
+}
+
+// RenameCoverImage points all article covers stored as oldName to newName.
+func (s *ArticleStore) RenameCoverImage(oldName, newName string) error {
+	_, err := s.DB.Exec("UPDATE articles SET cover_image = ?, updated_at = updated_at WHERE cover_image = ?", newName, oldName)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanArticle(row rowScanner, a *Article) error {
+	return row.Scan(&a.ID, &a.Title, &a.Slug, &a.Content, &a.Excerpt, &a.CoverImage, &a.Published, &a.CommentsEnabled, &a.CommentsClosed, &a.PublishedAt, &a.CreatedAt, &a.UpdatedAt)
+}
+
 func scanArticles(rows *sql.Rows) ([]Article, error) {
 	var articles []Article
 	for rows.Next() {
 		var a Article
-		if err := rows.Scan(&a.ID, &a.Title, &a.Slug, &a.Content, &a.Excerpt, &a.CoverImage, &a.Published, &a.CommentsEnabled, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		if err := scanArticle(rows, &a); err != nil {
 			return nil, err
 		}
 		articles = append(articles, a)
 	}
 	return articles, rows.Err()
 }
+
+func scanStrings(rows *sql.Rows) ([]string, error) {
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
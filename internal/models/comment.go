@@ -3,29 +3,229 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Comment statuses. Pending comments wait for a moderator; spam is kept
+// apart so it does not bury real comments in the moderation queue.
+const (
+	CommentPending  = "pending"
+	CommentApproved = "approved"
+	CommentSpam     = "spam"
+)
+
+// CommentMaxDepth is the deepest reply level. Replies to a comment at this
+// depth are attached to its parent instead, so threads stay readable on
+// narrow screens.
+const CommentMaxDepth = 3
+
+// Kinds of things a comment can be attached to.
+const (
+	TargetArticle = "article"
+	TargetGallery = "gallery"
+	TargetImage   = "image"
+)
+
+// CommentTarget identifies the article, gallery or photo a comment belongs
+// to. The zero value means "no target".
+type CommentTarget struct {
+	Kind string
+	ID   int64
+}
+
+func ArticleTarget(id int64) CommentTarget { return CommentTarget{TargetArticle, id} }
+func GalleryTarget(id int64) CommentTarget { return CommentTarget{TargetGallery, id} }
+func ImageTarget(id int64) CommentTarget   { return CommentTarget{TargetImage, id} }
+
+// String returns the target as "kind:id", e.g. "gallery:3". The form is
+// used in filter URLs and to bind spam tokens to a page.
+func (t CommentTarget) String() string {
+	if t.Kind == "" {
+		return ""
+	}
+	return t.Kind + ":" + strconv.FormatInt(t.ID, 10)
+}
+
+// ParseCommentTarget parses the output of CommentTarget.String.
+func ParseCommentTarget(s string) (CommentTarget, bool) {
+	kind, id, ok := strings.Cut(s, ":")
+	if !ok {
+		return CommentTarget{}, false
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n <= 0 {
+		return CommentTarget{}, false
+	}
+	switch kind {
+	case TargetArticle, TargetGallery, TargetImage:
+		return CommentTarget{kind, n}, true
+	}
+	return CommentTarget{}, false
+}
+
+// column returns the comments column referencing the target.
+func (t CommentTarget) column() string {
+	switch t.Kind {
+	case TargetGallery:
+		return "gallery_id"
+	case TargetImage:
+		return "image_id"
+	}
+	return "article_id"
+}
+
 type Comment struct {
-	ID         int64
-	ArticleID  int64
+	ID int64
+	// Exactly one of ArticleID, GalleryID and ImageID is set.
+	ArticleID  *int64
+	GalleryID  *int64
+	ImageID    *int64
+	ParentID   *int64
+	Depth      int
+	UserID     *int64 // set for replies posted by a club member from the admin
 	AuthorName string
 	Content    string
-	Approved   bool
-	CreatedAt  time.Time
+	Status     string
+	SpamReason string
+	// The submitter's address is kept only as a salted hash (to recognise
+	// repeat posters) and as its /24 or /48 network.
+	IPHash    string
+	IPNetwork string
+	UserAgent string
+	CreatedAt time.Time
+	Replies   []*Comment
+
+	// Filled in by Search for the moderation view. For photos the title
+	// and slug are those of the gallery.
+	TargetTitle string
+	TargetSlug  string
+	Thumbnail   string // upload shown next to the comment, may be empty
+}
+
+// Target returns what the comment is attached to.
+func (c Comment) Target() CommentTarget {
+	switch {
+	case c.ArticleID != nil:
+		return ArticleTarget(*c.ArticleID)
+	case c.GalleryID != nil:
+		return GalleryTarget(*c.GalleryID)
+	case c.ImageID != nil:
+		return ImageTarget(*c.ImageID)
+	}
+	return CommentTarget{}
+}
+
+// SetTarget attaches the comment to t, clearing any previous target.
+func (c *Comment) SetTarget(t CommentTarget) {
+	id := t.ID
+	c.ArticleID, c.GalleryID, c.ImageID = nil, nil, nil
+	switch t.Kind {
+	case TargetArticle:
+		c.ArticleID = &id
+	case TargetGallery:
+		c.GalleryID = &id
+	case TargetImage:
+		c.ImageID = &id
+	}
+}
+
+// TargetURL returns the public page the comment appears on. It needs the
+// slug filled in by Search.
+func (c Comment) TargetURL() string {
+	t := c.Target()
+	switch t.Kind {
+	case TargetArticle:
+		return "/articles/" + c.TargetSlug
+	case TargetGallery:
+		return "/gallery/" + c.TargetSlug
+	case TargetImage:
+		return "/gallery/" + c.TargetSlug + "/photos/" + strconv.FormatInt(t.ID, 10)
+	}
+	return ""
+}
+
+func (c Comment) Approved() bool {
+	return c.Status == CommentApproved
+}
+
+func (c Comment) IsSpam() bool {
+	return c.Status == CommentSpam
+}
+
+// IsOfficial reports whether the comment is an organizer's reply.
+func (c Comment) IsOfficial() bool {
+	return c.UserID != nil
+}
+
+func (c Comment) CanReply() bool {
+	return c.Depth < CommentMaxDepth
+}
+
+// SetParent attaches the comment under parent, moving it one level up when
+// parent is already at the maximum depth.
+func (c *Comment) SetParent(parent *Comment) {
+	if parent.Depth >= CommentMaxDepth && parent.ParentID != nil {
+		id := *parent.ParentID
+		c.ParentID = &id
+		c.Depth = parent.Depth
+		return
+	}
+	id := parent.ID
+	c.ParentID = &id
+	c.Depth = parent.Depth + 1
+}
+
+// ThreadComments arranges comments into reply trees in chronological order.
+// Replies whose parent is not in the list (e.g. still awaiting moderation)
+// are shown as top-level comments.
+func ThreadComments(comments []Comment) []*Comment {
+	sorted := make([]*Comment, len(comments))
+	byID := make(map[int64]*Comment, len(comments))
+	for i := range comments {
+		c := &comments[i]
+		c.Replies = nil
+		sorted[i] = c
+		byID[c.ID] = c
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].CreatedAt.Equal(sorted[j].CreatedAt) {
+			return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	var roots []*Comment
+	for _, c := range sorted {
+		if c.ParentID != nil {
+			if parent, ok := byID[*c.ParentID]; ok {
+				parent.Replies = append(parent.Replies, c)
+				continue
+			}
+		}
+		roots = append(roots, c)
+	}
+	return roots
 }
 
 type CommentStore struct {
 	DB *sql.DB
 }
 
-func (s *CommentStore) GetByArticleID(articleID int64, approvedOnly bool) ([]Comment, error) {
-	query := "SELECT id, article_id, author_name, content, approved, created_at FROM comments WHERE article_id = ?"
+const commentColumns = "id, article_id, gallery_id, image_id, parent_id, depth, user_id, author_name, content, status, spam_reason, ip_hash, ip_network, user_agent, created_at"
+
+// GetByTarget returns the comments on an article, gallery or photo.
+// Comments on a gallery's photos are not included with the gallery.
+func (s *CommentStore) GetByTarget(t CommentTarget, approvedOnly bool) ([]Comment, error) {
+	query := "SELECT " + commentColumns + " FROM comments WHERE " + t.column() + " = ?"
 	if approvedOnly {
-		query += " AND approved = TRUE"
+		query += " AND status = 'approved'"
 	}
 	query += " ORDER BY created_at DESC"
-	rows, err := s.DB.Query(query, articleID)
+	rows, err := s.DB.Query(query, t.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -33,8 +233,28 @@ func (s *CommentStore) GetByArticleID(articleID int64, approvedOnly bool) ([]Com
 	return scanComments(rows)
 }
 
+func (s *CommentStore) GetByID(id int64) (*Comment, error) {
+	rows, err := s.DB.Query("SELECT "+commentColumns+" FROM comments WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &comments[0], nil
+}
+
 func (s *CommentStore) GetAllPending() ([]Comment, error) {
-	rows, err := s.DB.Query("SELECT id, article_id, author_name, content, approved, created_at FROM comments WHERE approved = FALSE ORDER BY created_at DESC")
+	return s.GetByStatus(CommentPending)
+}
+
+func (s *CommentStore) GetByStatus(status string) ([]Comment, error) {
+	rows, err := s.DB.Query("SELECT "+commentColumns+" FROM comments WHERE status = ? ORDER BY created_at DESC", status)
 	if err != nil {
 		return nil, err
 	}
@@ -42,8 +262,9 @@ func (s *CommentStore) GetAllPending() ([]Comment, error) {
 	return scanComments(rows)
 }
 
+// GetAll returns every comment except spam.
 func (s *CommentStore) GetAll() ([]Comment, error) {
-	rows, err := s.DB.Query("SELECT id, article_id, author_name, content, approved, created_at FROM comments ORDER BY created_at DESC")
+	rows, err := s.DB.Query("SELECT " + commentColumns + " FROM comments WHERE status <> 'spam' ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -51,9 +272,142 @@ func (s *CommentStore) GetAll() ([]Comment, error) {
 	return scanComments(rows)
 }
 
+// CountApprovedByImage returns the number of approved comments on each
+// photo of a gallery. Photos without comments are left out.
+func (s *CommentStore) CountApprovedByImage(galleryID int64) (map[int64]int, error) {
+	rows, err := s.DB.Query("SELECT c.image_id, COUNT(*) FROM comments c JOIN images i ON i.id = c.image_id WHERE i.gallery_id = ? AND c.status = 'approved' GROUP BY c.image_id", galleryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// CommentFilter narrows the moderation list. Zero values mean "any"; an
+// empty Status shows everything except spam. A gallery target also matches
+// comments on the gallery's photos.
+type CommentFilter struct {
+	Status  string
+	Target  CommentTarget
+	From    time.Time
+	To      time.Time // exclusive
+	Query   string
+	Visitor string // IP hash of the submitter
+}
+
+// moderationFrom joins each comment with its article, or with its gallery
+// (directly or through the photo), for the moderation view.
+const moderationFrom = " FROM comments c" +
+	" LEFT JOIN articles a ON a.id = c.article_id" +
+	" LEFT JOIN images i ON i.id = c.image_id" +
+	" LEFT JOIN galleries g ON g.id = COALESCE(c.gallery_id, i.gallery_id)"
+
+// moderationColumns are commentColumns followed by the target title, slug
+// and thumbnail. A gallery is shown with its cover, or its first photo.
+const moderationColumns = "c.id, c.article_id, c.gallery_id, c.image_id, c.parent_id, c.depth, c.user_id, c.author_name, c.content, c.status, c.spam_reason, c.ip_hash, c.ip_network, c.user_agent, c.created_at," +
+	" COALESCE(a.title, g.title, ''), COALESCE(a.slug, g.slug, '')," +
+	" CASE WHEN c.article_id IS NOT NULL THEN COALESCE(a.cover_image, '')" +
+	" WHEN c.image_id IS NOT NULL THEN i.filename" +
+	" ELSE COALESCE((SELECT filename FROM images WHERE id = g.cover_image_id), (SELECT filename FROM images WHERE gallery_id = g.id ORDER BY sort_order, id LIMIT 1), '') END"
+
+// GetForModeration returns a comment with its target title, slug and
+// thumbnail filled in.
+func (s *CommentStore) GetForModeration(id int64) (*Comment, error) {
+	rows, err := s.DB.Query("SELECT "+moderationColumns+moderationFrom+" WHERE c.id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments, err := scanModeration(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &comments[0], nil
+}
+
+// Search returns one page of comments matching the filter, newest first,
+// together with the total number of matches.
+func (s *CommentStore) Search(f CommentFilter, limit, offset int) ([]Comment, int, error) {
+	var where []string
+	var args []interface{}
+	if f.Status == "" {
+		where = append(where, "c.status <> 'spam'")
+	} else {
+		where = append(where, "c.status = ?")
+		args = append(args, f.Status)
+	}
+	switch f.Target.Kind {
+	case TargetGallery:
+		where = append(where, "(c.gallery_id = ? OR i.gallery_id = ?)")
+		args = append(args, f.Target.ID, f.Target.ID)
+	case TargetArticle, TargetImage:
+		where = append(where, "c."+f.Target.column()+" = ?")
+		args = append(args, f.Target.ID)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "c.created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		where = append(where, "c.created_at < ?")
+		args = append(args, f.To)
+	}
+	if f.Visitor != "" {
+		where = append(where, "c.ip_hash = ?")
+		args = append(args, f.Visitor)
+	}
+	// Every word must appear in the author name or the text.
+	for _, word := range strings.Fields(f.Query) {
+		pattern := "%" + escapeLike(word) + "%"
+		where = append(where, "(c.author_name LIKE ? OR c.content LIKE ?)")
+		args = append(args, pattern, pattern)
+	}
+	cond := " WHERE " + strings.Join(where, " AND ")
+
+	var total int
+	if err := s.DB.QueryRow("SELECT COUNT(*)"+moderationFrom+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + moderationColumns + moderationFrom + cond +
+		" ORDER BY c.created_at DESC, c.id DESC LIMIT ? OFFSET ?"
+	rows, err := s.DB.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	comments, err := scanModeration(rows)
+	return comments, total, err
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (s *CommentStore) CountByStatus(status string) (int, error) {
+	var n int
+	err := s.DB.QueryRow("SELECT COUNT(*) FROM comments WHERE status = ?", status).Scan(&n)
+	return n, err
+}
+
 func (s *CommentStore) Create(c *Comment) error {
-	res, err := s.DB.Exec("INSERT INTO comments (article_id, author_name, content, approved) VALUES (?, ?, ?, ?)",
-		c.ArticleID, c.AuthorName, c.Content, c.Approved)
+	if c.Status == "" {
+		c.Status = CommentPending
+	}
+	res, err := s.DB.Exec("INSERT INTO comments (article_id, gallery_id, image_id, parent_id, depth, user_id, author_name, content, status, spam_reason, ip_hash, ip_network, user_agent) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		c.ArticleID, c.GalleryID, c.ImageID, c.ParentID, c.Depth, c.UserID, c.AuthorName, c.Content, c.Status, c.SpamReason, c.IPHash, c.IPNetwork, c.UserAgent)
 	if err != nil {
 		return fmt.Errorf("insert comment: %w", err)
 	}
@@ -62,20 +416,112 @@ func (s *CommentStore) Create(c *Comment) error {
 }
 
 func (s *CommentStore) Approve(id int64) error {
-	_, err := s.DB.Exec("UPDATE comments SET approved = TRUE WHERE id = ?", id)
+	_, err := s.DB.Exec("UPDATE comments SET status = 'approved', spam_reason = '' WHERE id = ?", id)
 	return err
 }
 
+func (s *CommentStore) MarkSpam(id int64) error {
+	_, err := s.DB.Exec("UPDATE comments SET status = 'spam', spam_reason = 'moderator' WHERE id = ?", id)
+	return err
+}
+
+// UpdateText changes the author name and text of a comment, e.g. to remove
+// personal data before approving it.
+func (s *CommentStore) UpdateText(id int64, authorName, content string) error {
+	_, err := s.DB.Exec("UPDATE comments SET author_name = ?, content = ? WHERE id = ?", authorName, content, id)
+	return err
+}
+
+// SetStatusMany moves the given comments to status. Moving comments out of
+// spam clears their spam reason.
+func (s *CommentStore) SetStatusMany(ids []int64, status string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	reason := ""
+	if status == CommentSpam {
+		reason = "moderator"
+	}
+	placeholders, args := inClause(ids)
+	_, err := s.DB.Exec("UPDATE comments SET status = ?, spam_reason = ? WHERE id IN ("+placeholders+")",
+		append([]interface{}{status, reason}, args...)...)
+	return err
+}
+
+// TargetURLs returns the public pages showing the comments, each once. Call
+// it before deleting them.
+func (s *CommentStore) TargetURLs(ids []int64) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	placeholders, args := inClause(ids)
+	rows, err := s.DB.Query("SELECT "+moderationColumns+moderationFrom+" WHERE c.id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	comments, err := scanModeration(rows)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, c := range comments {
+		if u := c.TargetURL(); u != "" && !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+func (s *CommentStore) DeleteMany(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	placeholders, args := inClause(ids)
+	_, err := s.DB.Exec("DELETE FROM comments WHERE id IN ("+placeholders+")", args...)
+	return err
+}
+
+func inClause(ids []int64) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return strings.TrimSuffix(strings.Repeat("?,", len(ids)), ","), args
+}
+
 func (s *CommentStore) Delete(id int64) error {
 	_, err := s.DB.Exec("DELETE FROM comments WHERE id = ?", id)
 	return err
 }
 
+// DeleteSpam removes every comment marked as spam and returns how many were
+// deleted.
+func (s *CommentStore) DeleteSpam() (int64, error) {
+	res, err := s.DB.Exec("DELETE FROM comments WHERE status = 'spam'")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
 func scanComments(rows *sql.Rows) ([]Comment, error) {
 	var comments []Comment
 	for rows.Next() {
 		var c Comment
-		if err := rows.Scan(&c.ID, &c.ArticleID, &c.AuthorName, &c.Content, &c.Approved, &c.CreatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.ArticleID, &c.GalleryID, &c.ImageID, &c.ParentID, &c.Depth, &c.UserID, &c.AuthorName, &c.Content, &c.Status, &c.SpamReason, &c.IPHash, &c.IPNetwork, &c.UserAgent, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
+
+func scanModeration(rows *sql.Rows) ([]Comment, error) {
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.ArticleID, &c.GalleryID, &c.ImageID, &c.ParentID, &c.Depth, &c.UserID, &c.AuthorName, &c.Content, &c.Status, &c.SpamReason, &c.IPHash, &c.IPNetwork, &c.UserAgent, &c.CreatedAt, &c.TargetTitle, &c.TargetSlug, &c.Thumbnail); err != nil {
 			return nil, err
 		}
 		comments = append(comments, c)
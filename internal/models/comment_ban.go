@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Ban kinds. Submissions matching a ban are stored as spam without telling
+// the visitor.
+const (
+	BanIP      = "ip"      // single address or CIDR range
+	BanVisitor = "visitor" // hashed address of a past commenter
+	BanWord    = "word"    // word or phrase in the name or text
+	BanName    = "name"    // author name pattern, "*" matches anything
+)
+
+type CommentBan struct {
+	ID        int64
+	Kind      string
+	Pattern   string
+	Note      string
+	CreatedAt time.Time
+}
+
+type CommentBanStore struct {
+	DB *sql.DB
+}
+
+func (s *CommentBanStore) GetAll() ([]CommentBan, error) {
+	rows, err := s.DB.Query("SELECT id, kind, pattern, note, created_at FROM comment_bans ORDER BY kind, pattern")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var bans []CommentBan
+	for rows.Next() {
+		var b CommentBan
+		if err := rows.Scan(&b.ID, &b.Kind, &b.Pattern, &b.Note, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, b)
+	}
+	return bans, rows.Err()
+}
+
+// Create adds a ban. Adding an existing pattern again only updates its note.
+func (s *CommentBanStore) Create(b *CommentBan) error {
+	res, err := s.DB.Exec("INSERT INTO comment_bans (kind, pattern, note) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE note = VALUES(note)",
+		b.Kind, b.Pattern, b.Note)
+	if err != nil {
+		return fmt.Errorf("insert comment ban: %w", err)
+	}
+	b.ID, _ = res.LastInsertId()
+	return nil
+}
+
+func (s *CommentBanStore) Delete(id int64) error {
+	_, err := s.DB.Exec("DELETE FROM comment_bans WHERE id = ?", id)
+	return err
+}
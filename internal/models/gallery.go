@@ -7,14 +7,44 @@ import (
 )
 
 type Gallery struct {
-	ID          int64
-	Title       string
-	Slug        string
-	Description string
-	ArticleID   *int64
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
-	Images      []Image
+	ID           int64
+	Title        string
+	Slug         string
+	Description  string
+	ArticleID    *int64
+	CoverImageID *int64
+	// Comments on the gallery and its photos follow the same switches as
+	// article comments.
+	CommentsEnabled bool
+	CommentsClosed  bool
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Images          []Image
+}
+
+// CommentsAutoClosed reports whether comments closed automatically because
+// the gallery was created more than days ago. Zero days disables it.
+func (g Gallery) CommentsAutoClosed(days int, now time.Time) bool {
+	if days <= 0 {
+		return false
+	}
+	return now.After(g.CreatedAt.AddDate(0, 0, days))
+}
+
+// Cover returns the image chosen as the gallery cover, falling back to the
+// first image in sort order. It returns nil for galleries without images.
+func (g Gallery) Cover() *Image {
+	if len(g.Images) == 0 {
+		return nil
+	}
+	if g.CoverImageID != nil {
+		for i := range g.Images {
+			if g.Images[i].ID == *g.CoverImageID {
+				return &g.Images[i]
+			}
+		}
+	}
+	return &g.Images[0]
 }
 
 type Image struct {
@@ -22,16 +52,27 @@ type Image struct {
 	GalleryID int64
 	Filename  string
 	Caption   string
+	AltText   string
 	SortOrder int
 	CreatedAt time.Time
 }
 
+// Alt returns the alternative text for the image, falling back to the caption.
+func (img Image) Alt() string {
+	if img.AltText != "" {
+		return img.AltText
+	}
+	return img.Caption
+}
+
 type GalleryStore struct {
 	DB *sql.DB
 }
 
+const galleryColumns = "id, title, slug, description, article_id, cover_image_id, comments_enabled, comments_closed, created_at, updated_at"
+
 func (s *GalleryStore) GetAll() ([]Gallery, error) {
-	rows, err := s.DB.Query("SELECT id, title, slug, description, article_id, created_at, updated_at FROM galleries ORDER BY created_at DESC")
+	rows, err := s.DB.Query("SELECT " + galleryColumns + " FROM galleries ORDER BY created_at DESC")
 	if err != nil {
 		return nil, err
 	}
@@ -39,10 +80,26 @@ func (s *GalleryStore) GetAll() ([]Gallery, error) {
 	return scanGalleries(rows)
 }
 
+// GetPaginated returns one page of galleries, newest first, without their
+// images, and the total number of galleries.
+func (s *GalleryStore) GetPaginated(limit, offset int) ([]Gallery, int, error) {
+	var total int
+	if err := s.DB.QueryRow("SELECT COUNT(*) FROM galleries").Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := s.DB.Query("SELECT "+galleryColumns+" FROM galleries ORDER BY created_at DESC LIMIT ? OFFSET ?", limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	galleries, err := scanGalleries(rows)
+	return galleries, total, err
+}
+
 func (s *GalleryStore) GetBySlug(slug string) (*Gallery, error) {
 	g := &Gallery{}
-	err := s.DB.QueryRow("SELECT id, title, slug, description, article_id, created_at, updated_at FROM galleries WHERE slug = ?", slug).
-		Scan(&g.ID, &g.Title, &g.Slug, &g.Description, &g.ArticleID, &g.CreatedAt, &g.UpdatedAt)
+	err := s.DB.QueryRow("SELECT "+galleryColumns+" FROM galleries WHERE slug = ?", slug).
+		Scan(&g.ID, &g.Title, &g.Slug, &g.Description, &g.ArticleID, &g.CoverImageID, &g.CommentsEnabled, &g.CommentsClosed, &g.CreatedAt, &g.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -56,8 +113,8 @@ func (s *GalleryStore) GetBySlug(slug string) (*Gallery, error) {
 
 func (s *GalleryStore) GetByID(id int64) (*Gallery, error) {
 	g := &Gallery{}
-	err := s.DB.QueryRow("SELECT id, title, slug, description, article_id, created_at, updated_at FROM galleries WHERE id = ?", id).
-		Scan(&g.ID, &g.Title, &g.Slug, &g.Description, &g.ArticleID, &g.CreatedAt, &g.UpdatedAt)
+	err := s.DB.QueryRow("SELECT "+galleryColumns+" FROM galleries WHERE id = ?", id).
+		Scan(&g.ID, &g.Title, &g.Slug, &g.Description, &g.ArticleID, &g.CoverImageID, &g.CommentsEnabled, &g.CommentsClosed, &g.CreatedAt, &g.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -71,8 +128,8 @@ func (s *GalleryStore) GetByID(id int64) (*Gallery, error) {
 
 func (s *GalleryStore) GetByArticleID(articleID int64) (*Gallery, error) {
 	g := &Gallery{}
-	err := s.DB.QueryRow("SELECT id, title, slug, description, article_id, created_at, updated_at FROM galleries WHERE article_id = ?", articleID).
-		Scan(&g.ID, &g.Title, &g.Slug, &g.Description, &g.ArticleID, &g.CreatedAt, &g.UpdatedAt)
+	err := s.DB.QueryRow("SELECT "+galleryColumns+" FROM galleries WHERE article_id = ?", articleID).
+		Scan(&g.ID, &g.Title, &g.Slug, &g.Description, &g.ArticleID, &g.CoverImageID, &g.CommentsEnabled, &g.CommentsClosed, &g.CreatedAt, &g.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -85,8 +142,8 @@ func (s *GalleryStore) GetByArticleID(articleID int64) (*Gallery, error) {
 }
 
 func (s *GalleryStore) Create(g *Gallery) error {
-	res, err := s.DB.Exec("INSERT INTO galleries (title, slug, description, article_id) VALUES (?, ?, ?, ?)",
-		g.Title, g.Slug, g.Description, g.ArticleID)
+	res, err := s.DB.Exec("INSERT INTO galleries (title, slug, description, article_id, comments_enabled, comments_closed) VALUES (?, ?, ?, ?, ?, ?)",
+		g.Title, g.Slug, g.Description, g.ArticleID, g.CommentsEnabled, g.CommentsClosed)
 	if err != nil {
 		return fmt.Errorf("insert gallery: %w", err)
 	}
@@ -95,8 +152,8 @@ func (s *GalleryStore) Create(g *Gallery) error {
 }
 
 func (s *GalleryStore) Update(g *Gallery) error {
-	_, err := s.DB.Exec("UPDATE galleries SET title=?, slug=?, description=?, article_id=? WHERE id=?",
-		g.Title, g.Slug, g.Description, g.ArticleID, g.ID)
+	_, err := s.DB.Exec("UPDATE galleries SET title=?, slug=?, description=?, article_id=?, comments_enabled=?, comments_closed=? WHERE id=?",
+		g.Title, g.Slug, g.Description, g.ArticleID, g.CommentsEnabled, g.CommentsClosed, g.ID)
 	return err
 }
 
@@ -105,8 +162,15 @@ func (s *GalleryStore) Delete(id int64) error {
 	return err
 }
 
+// SetCover marks an image as the gallery cover. A nil imageID resets the
+// cover to the first image of the gallery.
+func (s *GalleryStore) SetCover(galleryID int64, imageID *int64) error {
+	_, err := s.DB.Exec("UPDATE galleries SET cover_image_id = ? WHERE id = ?", imageID, galleryID)
+	return err
+}
+
 func (s *GalleryStore) GetImages(galleryID int64) ([]Image, error) {
-	rows, err := s.DB.Query("SELECT id, gallery_id, filename, caption, sort_order, created_at FROM images WHERE gallery_id = ? ORDER BY sort_order, id", galleryID)
+	rows, err := s.DB.Query("SELECT id, gallery_id, filename, caption, alt_text, sort_order, created_at FROM images WHERE gallery_id = ? ORDER BY sort_order, id", galleryID)
 	if err != nil {
 		return nil, err
 	}
@@ -114,7 +178,7 @@ func (s *GalleryStore) GetImages(galleryID int64) ([]Image, error) {
 	var images []Image
 	for rows.Next() {
 		var img Image
-		if err := rows.Scan(&img.ID, &img.GalleryID, &img.Filename, &img.Caption, &img.SortOrder, &img.CreatedAt); err != nil {
+		if err := rows.Scan(&img.ID, &img.GalleryID, &img.Filename, &img.Caption, &img.AltText, &img.SortOrder, &img.CreatedAt); err != nil {
 			return nil, err
 		}
 		images = append(images, img)
@@ -122,9 +186,11 @@ func (s *GalleryStore) GetImages(galleryID int64) ([]Image, error) {
 	return images, rows.Err()
 }
 
+// AddImage appends an image to the end of its gallery; the sort order is
+// assigned by the database so that successive upload batches keep their order.
 func (s *GalleryStore) AddImage(img *Image) error {
-	res, err := s.DB.Exec("INSERT INTO images (gallery_id, filename, caption, sort_order) VALUES (?, ?, ?, ?)",
-		img.GalleryID, img.Filename, img.Caption, img.SortOrder)
+	res, err := s.DB.Exec("INSERT INTO images (gallery_id, filename, caption, alt_text, sort_order) SELECT ?, ?, ?, ?, COALESCE(MAX(sort_order) + 1, 0) FROM images WHERE gallery_id = ?",
+		img.GalleryID, img.Filename, img.Caption, img.AltText, img.GalleryID)
 	if err != nil {
 		return fmt.Errorf("insert image: %w", err)
 	}
@@ -132,6 +198,56 @@ func (s *GalleryStore) AddImage(img *Image) error {
 	return nil
 }
 
+func (s *GalleryStore) UpdateImage(img *Image) error {
+	_, err := s.DB.Exec("UPDATE images SET caption=?, alt_text=? WHERE id=?", img.Caption, img.AltText, img.ID)
+	return err
+}
+
+// ReorderImages assigns sort positions to the given images in the order they
+// are listed. Images not belonging to the gallery are ignored.
+func (s *GalleryStore) ReorderImages(galleryID int64, imageIDs []int64) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare("UPDATE images SET sort_order = ? WHERE id = ? AND gallery_id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, id := range imageIDs {
+		if _, err := stmt.Exec(i, id, galleryID); err != nil {
+			return fmt.Errorf("reorder image %d: %w", id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// MoveImage moves an image to the end of another gallery. If the image was
+// the cover of its previous gallery, that gallery falls back to its first image.
+func (s *GalleryStore) MoveImage(imageID, targetGalleryID int64) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var next int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(sort_order) + 1, 0) FROM images WHERE gallery_id = ?", targetGalleryID).Scan(&next); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE galleries SET cover_image_id = NULL WHERE cover_image_id = ?", imageID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE images SET gallery_id = ?, sort_order = ? WHERE id = ?", targetGalleryID, next, imageID); err != nil {
+		return fmt.Errorf("move image: %w", err)
+	}
+	return tx.Commit()
+}
+
 func (s *GalleryStore) DeleteImage(id int64) error {
 	_, err := s.DB.Exec("DELETE FROM images WHERE id = ?", id)
 	return err
@@ -139,19 +255,46 @@ func (s *GalleryStore) DeleteImage(id int64) error {
 
 func (s *GalleryStore) GetImageByID(id int64) (*Image, error) {
 	img := &Image{}
-	err := s.DB.QueryRow("SELECT id, gallery_id, filename, caption, sort_order, created_at FROM images WHERE id = ?", id).
-		Scan(&img.ID, &img.GalleryID, &img.Filename, &img.Caption, &img.SortOrder, &img.CreatedAt)
+	err := s.DB.QueryRow("SELECT id, gallery_id, filename, caption, alt_text, sort_order, created_at FROM images WHERE id = ?", id).
+		Scan(&img.ID, &img.GalleryID, &img.Filename, &img.Caption, &img.AltText, &img.SortOrder, &img.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return img, nil
 }
 
+// GetByImageFilename returns the galleries that contain an image stored
+// under the given filename.
+func (s *GalleryStore) GetByImageFilename(filename string) ([]Gallery, error) {
+	rows, err := s.DB.Query("SELECT DISTINCT g.id, g.title, g.slug, g.description, g.article_id, g.cover_image_id, g.comments_enabled, g.comments_closed, g.created_at, g.updated_at FROM galleries g JOIN images i ON i.gallery_id = g.id WHERE i.filename = ? ORDER BY g.created_at DESC", filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanGalleries(rows)
+}
+
+// RenameImageFile points all images stored as oldName to newName.
+func (s *GalleryStore) RenameImageFile(oldName, newName string) error {
+	_, err := s.DB.Exec("UPDATE images SET filename = ? WHERE filename = ?", newName, oldName)
+	return err
+}
+
+// ImageFilenames returns the filenames of all gallery images.
+func (s *GalleryStore) ImageFilenames() ([]string, error) {
+	rows, err := s.DB.Query("SELECT filename FROM images")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanStrings(rows)
+}
+
 func scanGalleries(rows *sql.Rows) ([]Gallery, error) {
 	var galleries []Gallery
 	for rows.Next() {
 		var g Gallery
-		if err := rows.Scan(&g.ID, &g.Title, &g.Slug, &g.Description, &g.ArticleID, &g.CreatedAt, &g.UpdatedAt); err != nil {
+		if err := rows.Scan(&g.ID, &g.Title, &g.Slug, &g.Description, &g.ArticleID, &g.CoverImageID, &g.CommentsEnabled, &g.CommentsClosed, &g.CreatedAt, &g.UpdatedAt); err != nil {
 			return nil, err
 		}
 		galleries = append(galleries, g)
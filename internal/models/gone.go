@@ -0,0 +1,39 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// GoneStore remembers the public URLs of deleted articles, galleries and
+// photos, so that they answer 410 Gone instead of 404 Not Found.
+type GoneStore struct {
+	DB *sql.DB
+}
+
+func (s *GoneStore) Add(path string) error {
+	_, err := s.DB.Exec("INSERT INTO gone_paths (path) VALUES (?) ON DUPLICATE KEY UPDATE deleted_at = NOW()", path)
+	return err
+}
+
+// Remove forgets a path, e.g. when a new article takes over the slug.
+func (s *GoneStore) Remove(path string) error {
+	_, err := s.DB.Exec("DELETE FROM gone_paths WHERE path = ?", path)
+	return err
+}
+
+// Contains reports whether the path, or a page it belongs to, was deleted.
+// A photo of a deleted gallery is gone as well.
+func (s *GoneStore) Contains(path string) (bool, error) {
+	var candidates []interface{}
+	for p := strings.TrimSuffix(path, "/"); strings.Count(p, "/") > 1; p = p[:strings.LastIndex(p, "/")] {
+		candidates = append(candidates, p)
+	}
+	if len(candidates) == 0 {
+		return false, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(candidates)), ",")
+	var n int
+	err := s.DB.QueryRow("SELECT COUNT(*) FROM gone_paths WHERE path IN ("+placeholders+")", candidates...).Scan(&n)
+	return n > 0, err
+}
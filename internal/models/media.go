@@ -0,0 +1,147 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Media is a stored upload identified by the SHA-256 hash of its content.
+// RefCount tracks how many images and article covers use the file.
+type Media struct {
+	ID          int64
+	Hash        string
+	Filename    string
+	ContentType string
+	Size        int64
+	RefCount    int
+	CreatedAt   time.Time
+}
+
+type MediaStore struct {
+	DB *sql.DB
+}
+
+func (s *MediaStore) GetByHash(hash string) (*Media, error) {
+	m := &Media{}
+	err := s.DB.QueryRow("SELECT id, hash, filename, content_type, size, ref_count, created_at FROM media WHERE hash = ?", hash).
+		Scan(&m.ID, &m.Hash, &m.Filename, &m.ContentType, &m.Size, &m.RefCount, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *MediaStore) GetByFilename(filename string) (*Media, error) {
+	m := &Media{}
+	err := s.DB.QueryRow("SELECT id, hash, filename, content_type, size, ref_count, created_at FROM media WHERE filename = ?", filename).
+		Scan(&m.ID, &m.Hash, &m.Filename, &m.ContentType, &m.Size, &m.RefCount, &m.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Acquire records a new reference to the media, creating the row on first
+// use. created is true when the row was inserted: a Release may have just
+// deleted the stored file, so the caller has to store it again.
+func (s *MediaStore) Acquire(m *Media) (created bool, err error) {
+	res, err := s.DB.Exec("INSERT INTO media (hash, filename, content_type, size, ref_count) VALUES (?, ?, ?, ?, 1) ON DUPLICATE KEY UPDATE ref_count = ref_count + 1",
+		m.Hash, m.Filename, m.ContentType, m.Size)
+	if err != nil {
+		return false, fmt.Errorf("acquire media: %w", err)
+	}
+	// MySQL reports 1 affected row for an insert and 2 for an update.
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("acquire media: %w", err)
+	}
+	return n == 1, nil
+}
+
+// Release drops one reference to the file. When the last reference is gone
+// the row is deleted and remove is called to delete the stored object while
+// the row is still locked, so a concurrent Acquire of the same content waits
+// until the object is gone and then inserts a new row. Files without a media
+// row (uploaded before content addressing) are removed right away. When
+// remove fails the row is deleted anyway; the object is then reported as
+// orphaned in storage.
+func (s *MediaStore) Release(filename string, remove func() error) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var refs int
+	err = tx.QueryRow("SELECT ref_count FROM media WHERE filename = ? FOR UPDATE", filename).Scan(&refs)
+	if err == sql.ErrNoRows {
+		return remove()
+	}
+	if err != nil {
+		return err
+	}
+
+	if refs > 1 {
+		if _, err := tx.Exec("UPDATE media SET ref_count = ref_count - 1 WHERE filename = ?", filename); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if _, err := tx.Exec("DELETE FROM media WHERE filename = ?", filename); err != nil {
+		return err
+	}
+	removeErr := remove()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return removeErr
+}
+
+// DeleteByFilename removes the media row of a file that was deleted from storage.
+func (s *MediaStore) DeleteByFilename(filename string) error {
+	_, err := s.DB.Exec("DELETE FROM media WHERE filename = ?", filename)
+	return err
+}
+
+// RecountReferences recomputes every reference count from the images and
+// articles that use each file.
+func (s *MediaStore) RecountReferences() error {
+	_, err := s.DB.Exec(`UPDATE media m SET ref_count =
+		(SELECT COUNT(*) FROM images i WHERE i.filename = m.filename) +
+		(SELECT COUNT(*) FROM articles a WHERE a.cover_image = m.filename)`)
+	return err
+}
+
+// MediaAccess says who may download a stored file.
+type MediaAccess struct {
+	// Known is set for files recorded at upload or used by content; other
+	// objects in storage are never served.
+	Known bool
+	// Public is set for files used by a gallery or a published article.
+	// The rest, e.g. covers of drafts, are for signed-in users only.
+	Public bool
+	// ContentType as detected at upload, "" for files uploaded before
+	// content addressing.
+	ContentType string
+}
+
+// Access looks up who may download the file stored under filename.
+func (s *MediaStore) Access(filename string) (*MediaAccess, error) {
+	a := &MediaAccess{}
+	var inMedia, inImages, asCover, asPublishedCover bool
+	err := s.DB.QueryRow(`SELECT
+		EXISTS(SELECT 1 FROM media WHERE filename = ?),
+		COALESCE((SELECT content_type FROM media WHERE filename = ?), ''),
+		EXISTS(SELECT 1 FROM images WHERE filename = ?),
+		EXISTS(SELECT 1 FROM articles WHERE cover_image = ?),
+		EXISTS(SELECT 1 FROM articles WHERE cover_image = ? AND published = 1)`,
+		filename, filename, filename, filename, filename).
+		Scan(&inMedia, &a.ContentType, &inImages, &asCover, &asPublishedCover)
+	if err != nil {
+		return nil, fmt.Errorf("media access: %w", err)
+	}
+	a.Known = inMedia || inImages || asCover
+	a.Public = inImages || asPublishedCover
+	return a, nil
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Outbox message statuses.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxMessage is an email waiting to be delivered (or already delivered).
+// Mail is queued in the database so it survives restarts and SMTP outages.
+type OutboxMessage struct {
+	ID            int64
+	To            string
+	Subject       string
+	TextBody      string
+	HTMLBody      string
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	// Sensitive messages carry secrets such as password reset links; their
+	// bodies are erased once delivered.
+	Sensitive bool
+	CreatedAt time.Time
+	SentAt    *time.Time
+}
+
+type OutboxStore struct {
+	DB *sql.DB
+}
+
+const outboxColumns = "id, to_address, subject, text_body, html_body, status, attempts, last_error, next_attempt_at, sensitive, created_at, sent_at"
+
+func (s *OutboxStore) Enqueue(m *OutboxMessage) error {
+	res, err := s.DB.Exec("INSERT INTO outbox (to_address, subject, text_body, html_body, sensitive) VALUES (?, ?, ?, ?, ?)",
+		m.To, m.Subject, m.TextBody, m.HTMLBody, m.Sensitive)
+	if err != nil {
+		return fmt.Errorf("enqueue mail: %w", err)
+	}
+	m.ID, _ = res.LastInsertId()
+	m.Status = OutboxPending
+	return nil
+}
+
+// Due returns pending messages whose next attempt time has passed.
+func (s *OutboxStore) Due(limit int) ([]OutboxMessage, error) {
+	rows, err := s.DB.Query("SELECT "+outboxColumns+" FROM outbox WHERE status = 'pending' AND next_attempt_at <= NOW() ORDER BY next_attempt_at, id LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanOutbox(rows)
+}
+
+// Recent returns the latest messages for the admin overview.
+func (s *OutboxStore) Recent(limit int) ([]OutboxMessage, error) {
+	rows, err := s.DB.Query("SELECT "+outboxColumns+" FROM outbox ORDER BY created_at DESC, id DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanOutbox(rows)
+}
+
+// MarkSent records a delivery and erases the body of sensitive messages.
+func (s *OutboxStore) MarkSent(id int64) error {
+	_, err := s.DB.Exec("UPDATE outbox SET status = 'sent', attempts = attempts + 1, last_error = '', sent_at = NOW(),"+
+		" text_body = IF(sensitive, '', text_body), html_body = IF(sensitive, '', html_body) WHERE id = ?", id)
+	return err
+}
+
+// MarkAttemptFailed records a failed delivery. The message is retried
+// after delay, or given up on when final is true.
+func (s *OutboxStore) MarkAttemptFailed(id int64, errMsg string, delay time.Duration, final bool) error {
+	if len(errMsg) > 1000 {
+		errMsg = errMsg[:1000]
+	}
+	status := OutboxPending
+	if final {
+		status = OutboxFailed
+	}
+	_, err := s.DB.Exec("UPDATE outbox SET status = ?, attempts = attempts + 1, last_error = ?, next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE id = ?",
+		status, errMsg, int64(delay.Seconds()), id)
+	return err
+}
+
+// Retry puts a failed message back into the queue.
+func (s *OutboxStore) Retry(id int64) error {
+	_, err := s.DB.Exec("UPDATE outbox SET status = 'pending', attempts = 0, next_attempt_at = NOW() WHERE id = ? AND status = 'failed'", id)
+	return err
+}
+
+// DeleteSentBefore removes delivered messages older than t.
+func (s *OutboxStore) DeleteSentBefore(t time.Time) (int64, error) {
+	res, err := s.DB.Exec("DELETE FROM outbox WHERE status = 'sent' AND sent_at < ?", t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanOutbox(rows *sql.Rows) ([]OutboxMessage, error) {
+	var messages []OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.To, &m.Subject, &m.TextBody, &m.HTMLBody, &m.Status, &m.Attempts, &m.LastError, &m.NextAttemptAt, &m.Sensitive, &m.CreatedAt, &m.SentAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
@@ -3,26 +3,48 @@ package models
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 )
 
 type User struct {
-	ID           int64
-	Name         string
-	Surname      string
-	Nickname     string
-	PasswordHash string
-	IsAdmin      bool
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID             int64
+	Name           string
+	Surname        string
+	Nickname       string
+	PasswordHash   string
+	IsAdmin        bool
+	Email          string
+	NotifyComments bool   // email the user about comments awaiting moderation
+	Language       string // of the administration; "" follows the site
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// DisplayName returns the user's full name, or the nickname when no name
+// is filled in.
+func (u User) DisplayName() string {
+	name := strings.TrimSpace(u.Name + " " + u.Surname)
+	if name == "" {
+		return u.Nickname
+	}
+	return name
+}
+
+// InvitePending reports whether the user was invited and has not chosen a
+// password yet. Such a user cannot log in.
+func (u User) InvitePending() bool {
+	return u.PasswordHash == ""
 }
 
 type UserStore struct {
 	DB *sql.DB
 }
 
+const userColumns = "id, name, surname, nickname, password_hash, is_admin, email, notify_comments, language, created_at, updated_at"
+
 func (s *UserStore) GetAll() ([]User, error) {
-	rows, err := s.DB.Query("SELECT id, name, surname, nickname, password_hash, is_admin, created_at, updated_at FROM users ORDER BY id ASC")
+	rows, err := s.DB.Query("SELECT " + userColumns + " FROM users ORDER BY id ASC")
 	if err != nil {
 		return nil, err
 	}
@@ -32,8 +54,7 @@ func (s *UserStore) GetAll() ([]User, error) {
 
 func (s *UserStore) GetByID(id int64) (*User, error) {
 	u := &User{}
-	err := s.DB.QueryRow("SELECT id, name, surname, nickname, password_hash, is_admin, created_at, updated_at FROM users WHERE id = ?", id).
-		Scan(&u.ID, &u.Name, &u.Surname, &u.Nickname, &u.PasswordHash, &u.IsAdmin, &u.CreatedAt, &u.UpdatedAt)
+	err := scanUser(s.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id), u)
 	if err != nil {
 		return nil, err
 	}
@@ -42,17 +63,27 @@ func (s *UserStore) GetByID(id int64) (*User, error) {
 
 func (s *UserStore) GetByNickname(nickname string) (*User, error) {
 	u := &User{}
-	err := s.DB.QueryRow("SELECT id, name, surname, nickname, password_hash, is_admin, created_at, updated_at FROM users WHERE nickname = ?", nickname).
-		Scan(&u.ID, &u.Name, &u.Surname, &u.Nickname, &u.PasswordHash, &u.IsAdmin, &u.CreatedAt, &u.UpdatedAt)
+	err := scanUser(s.DB.QueryRow("SELECT "+userColumns+" FROM users WHERE nickname = ?", nickname), u)
 	if err != nil {
 		return nil, err
 	}
 	return u, nil
 }
 
+// GetByEmail returns all users with the address. Email addresses are not
+// unique, e.g. one person may have several accounts.
+func (s *UserStore) GetByEmail(email string) ([]User, error) {
+	rows, err := s.DB.Query("SELECT "+userColumns+" FROM users WHERE email = ? AND email <> ''", email)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUsers(rows)
+}
+
 func (s *UserStore) Create(u *User) error {
-	res, err := s.DB.Exec("INSERT INTO users (name, surname, nickname, password_hash, is_admin) VALUES (?, ?, ?, ?, ?)",
-		u.Name, u.Surname, u.Nickname, u.PasswordHash, u.IsAdmin)
+	res, err := s.DB.Exec("INSERT INTO users (name, surname, nickname, password_hash, is_admin, email, notify_comments, language) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		u.Name, u.Surname, u.Nickname, u.PasswordHash, u.IsAdmin, u.Email, u.NotifyComments, u.Language)
 	if err != nil {
 		return fmt.Errorf("insert user: %w", err)
 	}
@@ -61,8 +92,13 @@ func (s *UserStore) Create(u *User) error {
 }
 
 func (s *UserStore) Update(u *User) error {
-	_, err := s.DB.Exec("UPDATE users SET name=?, surname=?, nickname=?, password_hash=?, is_admin=? WHERE id=?",
-		u.Name, u.Surname, u.Nickname, u.PasswordHash, u.IsAdmin, u.ID)
+	_, err := s.DB.Exec("UPDATE users SET name=?, surname=?, nickname=?, password_hash=?, is_admin=?, email=?, notify_comments=?, language=? WHERE id=?",
+		u.Name, u.Surname, u.Nickname, u.PasswordHash, u.IsAdmin, u.Email, u.NotifyComments, u.Language, u.ID)
+	return err
+}
+
+func (s *UserStore) SetPassword(id int64, hash string) error {
+	_, err := s.DB.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hash, id)
 	return err
 }
 
@@ -77,17 +113,31 @@ func (s *UserStore) GetFirstAdminID() (int64, error) {
 	return id, err
 }
 
+// GetCommentSubscribers returns users who want to hear about new comments.
+func (s *UserStore) GetCommentSubscribers() ([]User, error) {
+	rows, err := s.DB.Query("SELECT " + userColumns + " FROM users WHERE notify_comments = TRUE AND email <> ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanUsers(rows)
+}
+
 func (s *UserStore) Count() (int, error) {
 	var count int
 	err := s.DB.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
 	return count, err
 }
 
+func scanUser(row rowScanner, u *User) error {
+	return row.Scan(&u.ID, &u.Name, &u.Surname, &u.Nickname, &u.PasswordHash, &u.IsAdmin, &u.Email, &u.NotifyComments, &u.Language, &u.CreatedAt, &u.UpdatedAt)
+}
+
 func scanUsers(rows *sql.Rows) ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.Name, &u.Surname, &u.Nickname, &u.PasswordHash, &u.IsAdmin, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		if err := scanUser(rows, &u); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Token purposes. An invite lets a new user choose their first password;
+// a reset replaces a forgotten one.
+const (
+	TokenInvite = "invite"
+	TokenReset  = "reset"
+)
+
+// ErrTokenInvalid is returned for unknown, expired or already used tokens.
+var ErrTokenInvalid = errors.New("token is invalid or expired")
+
+// UserToken is a single-use secret sent to a user by email. Only the
+// SHA-256 hash of the token is stored, so a database leak does not expose
+// working links.
+type UserToken struct {
+	ID        int64
+	UserID    int64
+	Purpose   string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type UserTokenStore struct {
+	DB *sql.DB
+}
+
+// Create issues a new token and returns its plain value for the email
+// link. Earlier unused tokens of the same purpose stop working; they are
+// expired rather than deleted so CountRecent still sees them.
+func (s *UserTokenStore) Create(userID int64, purpose string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE user_tokens SET expires_at = NOW() WHERE user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > NOW()", userID, purpose); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES (?, ?, ?, DATE_ADD(NOW(), INTERVAL ? SECOND))",
+		userID, purpose, hashToken(token), int64(ttl.Seconds())); err != nil {
+		return "", fmt.Errorf("insert user token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Lookup returns the token if it can still be used, without using it up.
+func (s *UserTokenStore) Lookup(token string) (*UserToken, error) {
+	t := &UserToken{}
+	err := s.DB.QueryRow("SELECT id, user_id, purpose, expires_at, used_at, created_at FROM user_tokens WHERE token_hash = ? AND used_at IS NULL AND expires_at > NOW()",
+		hashToken(token)).Scan(&t.ID, &t.UserID, &t.Purpose, &t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Consume marks the token as used. Only the first of several concurrent
+// calls succeeds; the others get ErrTokenInvalid.
+func (s *UserTokenStore) Consume(t *UserToken) error {
+	res, err := s.DB.Exec("UPDATE user_tokens SET used_at = NOW() WHERE id = ? AND used_at IS NULL AND expires_at > NOW()", t.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// CountRecent returns how many tokens of the purpose were issued to the
+// user within the last period, used to throttle reset requests. Superseded
+// and used tokens count too.
+func (s *UserTokenStore) CountRecent(userID int64, purpose string, period time.Duration) (int, error) {
+	var n int
+	err := s.DB.QueryRow("SELECT COUNT(*) FROM user_tokens WHERE user_id = ? AND purpose = ? AND created_at > DATE_SUB(NOW(), INTERVAL ? SECOND)",
+		userID, purpose, int64(period.Seconds())).Scan(&n)
+	return n, err
+}
+
+// HasPending reports whether the user has an unused, unexpired token of
+// the purpose.
+func (s *UserTokenStore) HasPending(userID int64, purpose string) (bool, error) {
+	var n int
+	err := s.DB.QueryRow("SELECT COUNT(*) FROM user_tokens WHERE user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > NOW()", userID, purpose).Scan(&n)
+	return n > 0, err
+}
+
+// DeleteExpired removes tokens that expired more than a day ago. Recent
+// ones are kept so reset requests stay throttled.
+func (s *UserTokenStore) DeleteExpired() error {
+	_, err := s.DB.Exec("DELETE FROM user_tokens WHERE expires_at < DATE_SUB(NOW(), INTERVAL 1 DAY)")
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Webhook events. EventPing is only sent by the "send test" button.
+const (
+	EventArticlePublished = "article.published"
+	EventArticleDeleted   = "article.deleted"
+	EventGalleryCreated   = "gallery.created"
+	EventGalleryDeleted   = "gallery.deleted"
+	EventCommentPending   = "comment.pending"
+	EventPing             = "ping"
+)
+
+// WebhookEvents lists the events a webhook can subscribe to, in the order
+// they are offered in the administration.
+var WebhookEvents = []string{EventArticlePublished, EventArticleDeleted, EventGalleryCreated, EventGalleryDeleted, EventCommentPending}
+
+// Webhook is an URL that receives signed JSON notifications about the
+// selected events.
+type Webhook struct {
+	ID        int64
+	Name      string
+	URL       string
+	Secret    string
+	Events    []string
+	Active    bool
+	CreatedAt time.Time
+}
+
+func (w Webhook) Subscribed(event string) bool {
+	for _, e := range w.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookStore struct {
+	DB *sql.DB
+}
+
+const webhookColumns = "id, name, url, secret, events, active, created_at"
+
+func (s *WebhookStore) GetAll() ([]Webhook, error) {
+	rows, err := s.DB.Query("SELECT " + webhookColumns + " FROM webhooks ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var hooks []Webhook
+	for rows.Next() {
+		var w Webhook
+		if err := scanWebhook(rows, &w); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+// GetSubscribed returns the active webhooks that want event.
+func (s *WebhookStore) GetSubscribed(event string) ([]Webhook, error) {
+	all, err := s.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	var hooks []Webhook
+	for _, w := range all {
+		if w.Active && w.Subscribed(event) {
+			hooks = append(hooks, w)
+		}
+	}
+	return hooks, nil
+}
+
+func (s *WebhookStore) GetByID(id int64) (*Webhook, error) {
+	w := &Webhook{}
+	if err := scanWebhook(s.DB.QueryRow("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?", id), w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *WebhookStore) Create(w *Webhook) error {
+	res, err := s.DB.Exec("INSERT INTO webhooks (name, url, secret, events, active) VALUES (?, ?, ?, ?, ?)",
+		w.Name, w.URL, w.Secret, strings.Join(w.Events, ","), w.Active)
+	if err != nil {
+		return fmt.Errorf("insert webhook: %w", err)
+	}
+	w.ID, _ = res.LastInsertId()
+	return nil
+}
+
+func (s *WebhookStore) Update(w *Webhook) error {
+	_, err := s.DB.Exec("UPDATE webhooks SET name = ?, url = ?, secret = ?, events = ?, active = ? WHERE id = ?",
+		w.Name, w.URL, w.Secret, strings.Join(w.Events, ","), w.Active, w.ID)
+	return err
+}
+
+// Delete removes the webhook together with its delivery log.
+func (s *WebhookStore) Delete(id int64) error {
+	_, err := s.DB.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	return err
+}
+
+func scanWebhook(row rowScanner, w *Webhook) error {
+	var events string
+	if err := row.Scan(&w.ID, &w.Name, &w.URL, &w.Secret, &events, &w.Active, &w.CreatedAt); err != nil {
+		return err
+	}
+	w.Events = nil
+	for _, e := range strings.Split(events, ",") {
+		if e != "" {
+			w.Events = append(w.Events, e)
+		}
+	}
+	return nil
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent (or waiting to be sent) to a webhook.
+// Like the mail outbox, deliveries are queued in the database so they
+// survive restarts and outages of the receiver.
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	Event         string
+	Payload       string
+	Status        string
+	Attempts      int
+	ResponseCode  *int // nil until the receiver answered
+	ResponseBody  string
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+	// WebhookName is filled in by Recent for the delivery log.
+	WebhookName string
+}
+
+type WebhookDeliveryStore struct {
+	DB *sql.DB
+}
+
+const deliveryColumns = "d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.response_code, d.response_body, d.last_error, d.next_attempt_at, d.created_at, d.delivered_at, w.name"
+
+const deliveryFrom = " FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id"
+
+func (s *WebhookDeliveryStore) Enqueue(d *WebhookDelivery) error {
+	res, err := s.DB.Exec("INSERT INTO webhook_deliveries (webhook_id, event, payload) VALUES (?, ?, ?)", d.WebhookID, d.Event, d.Payload)
+	if err != nil {
+		return fmt.Errorf("enqueue webhook delivery: %w", err)
+	}
+	d.ID, _ = res.LastInsertId()
+	d.Status = DeliveryPending
+	return nil
+}
+
+// Due returns pending deliveries whose next attempt time has passed.
+// Inactive webhooks only receive test pings.
+func (s *WebhookDeliveryStore) Due(limit int) ([]WebhookDelivery, error) {
+	rows, err := s.DB.Query("SELECT "+deliveryColumns+deliveryFrom+" WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND (w.active OR d.event = 'ping') ORDER BY d.next_attempt_at, d.id LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
+
+// Recent returns the latest deliveries for the admin log, for one webhook
+// or for all of them when webhookID is 0.
+func (s *WebhookDeliveryStore) Recent(webhookID int64, limit int) ([]WebhookDelivery, error) {
+	rows, err := s.DB.Query("SELECT "+deliveryColumns+deliveryFrom+" WHERE ? = 0 OR d.webhook_id = ? ORDER BY d.created_at DESC, d.id DESC LIMIT ?", webhookID, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanDeliveries(rows)
+}
+
+// MarkDelivered records a successful delivery and the receiver's answer.
+func (s *WebhookDeliveryStore) MarkDelivered(id int64, code int, body string) error {
+	_, err := s.DB.Exec("UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1, response_code = ?, response_body = ?, last_error = '', delivered_at = NOW() WHERE id = ?",
+		code, truncateLog(body), id)
+	return err
+}
+
+// MarkAttemptFailed records a failed delivery; code is 0 when the receiver
+// could not be reached. The delivery is retried after delay, or given up on
+// when final is true.
+func (s *WebhookDeliveryStore) MarkAttemptFailed(id int64, code int, body, errMsg string, delay time.Duration, final bool) error {
+	status := DeliveryPending
+	if final {
+		status = DeliveryFailed
+	}
+	var responseCode *int
+	if code != 0 {
+		responseCode = &code
+	}
+	_, err := s.DB.Exec("UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, response_code = ?, response_body = ?, last_error = ?, next_attempt_at = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE id = ?",
+		status, responseCode, truncateLog(body), truncateLog(errMsg), int64(delay.Seconds()), id)
+	return err
+}
+
+// Retry puts a failed delivery back into the queue.
+func (s *WebhookDeliveryStore) Retry(id int64) error {
+	_, err := s.DB.Exec("UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = NOW() WHERE id = ? AND status = 'failed'", id)
+	return err
+}
+
+// DeleteBefore removes finished deliveries older than t.
+func (s *WebhookDeliveryStore) DeleteBefore(t time.Time) (int64, error) {
+	res, err := s.DB.Exec("DELETE FROM webhook_deliveries WHERE status <> 'pending' AND created_at < ?", t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func scanDeliveries(rows *sql.Rows) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode, &d.ResponseBody, &d.LastError, &d.NextAttemptAt, &d.CreatedAt, &d.DeliveredAt, &d.WebhookName); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// truncateLog shortens text stored for reference to the column size
+// without cutting a character in half.
+func truncateLog(s string) string {
+	if len(s) > 1000 {
+		return strings.ToValidUTF8(s[:1000], "")
+	}
+	return s
+}
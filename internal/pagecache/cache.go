@@ -0,0 +1,380 @@
+// Package pagecache keeps rendered public pages in memory. Entries are
+// dropped when content changes, served stale for a while after they expire
+// while a fresh copy renders in the background, and evicted least recently
+// used first once the cache reaches its size limit.
+//
+// Parts of a page that must differ per visitor, such as the comment form's
+// single-use token, are left as holes: the handler renders a marker while
+// the page is captured and the cache fills it in on every response.
+package pagecache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Cache is an in-memory cache of rendered pages. The zero value is not
+// usable; create one with New.
+type Cache struct {
+	maxBytes int64
+	ttl      time.Duration // how long an entry is fresh
+	stale    time.Duration // how long after that it may be served stale
+
+	// Bypass reports requests that must always see a fresh render, e.g.
+	// from signed-in administrators.
+	Bypass func(r *http.Request) bool
+
+	mu         sync.Mutex
+	lru        *list.List // of *entry, most recently used first
+	entries    map[string]*list.Element
+	size       int64
+	gen        uint64 // bumped by Invalidate
+	refreshing map[string]bool
+
+	holes      map[string]func(r *http.Request, arg string) string
+	holeMarker *regexp.Regexp
+	markerID   string
+
+	hits, staleHits, misses, bypasses, evictions, invalidations atomic.Int64
+}
+
+type entry struct {
+	key      string
+	status   int
+	header   http.Header
+	body     []byte
+	holes    bool
+	storedAt time.Time
+	gen      uint64
+}
+
+func (e *entry) cost() int64 {
+	return int64(len(e.key) + len(e.body) + 512) // rough allowance for headers
+}
+
+// Stats are the counters shown in the administration.
+type Stats struct {
+	Hits          int64
+	StaleHits     int64
+	Misses        int64
+	Bypasses      int64
+	Evictions     int64
+	Invalidations int64
+	Entries       int
+	Bytes         int64
+	MaxBytes      int64
+}
+
+// HitRatio is the share of cacheable requests answered from the cache, in
+// percent.
+func (s Stats) HitRatio() int {
+	total := s.Hits + s.StaleHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return int((s.Hits + s.StaleHits) * 100 / total)
+}
+
+// New returns a cache holding up to maxBytes of pages. Entries are fresh
+// for ttl and may be served stale for another stale while being refreshed.
+// A maxBytes of 0 disables caching; holes are still filled.
+func New(maxBytes int64, ttl, stale time.Duration) *Cache {
+	b := make([]byte, 6)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	return &Cache{
+		maxBytes:   maxBytes,
+		ttl:        ttl,
+		stale:      stale,
+		lru:        list.New(),
+		entries:    make(map[string]*list.Element),
+		refreshing: make(map[string]bool),
+		holes:      make(map[string]func(*http.Request, string) string),
+		holeMarker: regexp.MustCompile(`__pc` + id + `_([a-z-]+)_([0-9a-f]*)__`),
+		markerID:   id,
+	}
+}
+
+type contextKey string
+
+const capturingKey contextKey = "pagecache_capturing"
+
+// Hole registers fill as the source of the named per-response fragment; it
+// gets the request being answered. Register holes before serving requests.
+func (c *Cache) Hole(name string, fill func(r *http.Request, arg string) string) {
+	c.holes[name] = fill
+}
+
+// Fragment returns the named hole for arg: a marker while the page is being
+// captured for the cache, the filled-in value otherwise.
+func (c *Cache) Fragment(r *http.Request, name, arg string) string {
+	if capturing, _ := r.Context().Value(capturingKey).(bool); capturing {
+		return "__pc" + c.markerID + "_" + name + "_" + hex.EncodeToString([]byte(arg)) + "__"
+	}
+	if fill := c.holes[name]; fill != nil {
+		return fill(r, arg)
+	}
+	return ""
+}
+
+// Middleware serves GET requests from the cache and stores cacheable
+// responses of next. Conditional requests go straight to next, which
+// answers them itself.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.maxBytes <= 0 || r.Method != http.MethodGet ||
+			r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if c.Bypass != nil && c.Bypass(r) {
+			c.bypasses.Add(1)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := cacheKey(r)
+		if e, fresh, ok := c.get(key); ok {
+			if fresh {
+				c.hits.Add(1)
+			} else {
+				c.staleHits.Add(1)
+				c.refresh(key, r, next)
+			}
+			c.write(w, r, e)
+			return
+		}
+
+		c.misses.Add(1)
+		e := c.capture(key, r, next)
+		c.write(w, r, e)
+	})
+}
+
+// Invalidate drops every cached page. Renders already in progress are not
+// stored, as they may have read the old content.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+	c.invalidations.Add(1)
+}
+
+// InvalidatePaths drops the cached pages at paths, with any query string.
+// A path ending in "*" drops every page whose path starts with the rest.
+// Renders already in progress are not stored, whichever page they are for.
+func (c *Cache) InvalidatePaths(paths ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for key, el := range c.entries {
+		path, _, _ := strings.Cut(key, "?")
+		for _, p := range paths {
+			prefix, wildcard := strings.CutSuffix(p, "*")
+			if path == p || wildcard && strings.HasPrefix(path, prefix) {
+				c.remove(el)
+				break
+			}
+		}
+	}
+	c.invalidations.Add(1)
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	entries, size := len(c.entries), c.size
+	c.mu.Unlock()
+	return Stats{
+		Hits:          c.hits.Load(),
+		StaleHits:     c.staleHits.Load(),
+		Misses:        c.misses.Load(),
+		Bypasses:      c.bypasses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Entries:       entries,
+		Bytes:         size,
+		MaxBytes:      c.maxBytes,
+	}
+}
+
+// Enabled reports whether pages are cached at all.
+func (c *Cache) Enabled() bool {
+	return c.maxBytes > 0
+}
+
+// get returns the entry for key and whether it is still fresh. Entries
+// past their stale window are dropped.
+func (c *Cache) get(key string) (*entry, bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, false
+	}
+	e := el.Value.(*entry)
+	age := time.Since(e.storedAt)
+	if age > c.ttl+c.stale {
+		c.remove(el)
+		return nil, false, false
+	}
+	c.lru.MoveToFront(el)
+	return e, age <= c.ttl, true
+}
+
+// capture renders the page and stores it when the response is cacheable.
+func (c *Cache) capture(key string, r *http.Request, next http.Handler) *entry {
+	c.mu.Lock()
+	gen := c.gen
+	c.mu.Unlock()
+
+	rec := &recorder{header: make(http.Header), status: http.StatusOK}
+	next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), capturingKey, true)))
+	e := &entry{
+		key:      key,
+		status:   rec.status,
+		header:   rec.header,
+		body:     rec.body.Bytes(),
+		holes:    c.holeMarker.Match(rec.body.Bytes()),
+		storedAt: time.Now(),
+		gen:      gen,
+	}
+	if cacheable(e) {
+		c.put(e)
+	}
+	return e
+}
+
+// refresh renders a stale page again in the background, once per key.
+func (c *Cache) refresh(key string, r *http.Request, next http.Handler) {
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+
+	// The original request is cancelled once its handler returns, and chi
+	// reuses its routing context for the next request, so the refresh gets
+	// a copy of the URL parameters.
+	ctx := context.WithoutCancel(r.Context())
+	if rctx := chi.RouteContext(ctx); rctx != nil {
+		copied := chi.NewRouteContext()
+		copied.Routes = rctx.Routes
+		copied.RoutePath = rctx.RoutePath
+		copied.RoutePatterns = append([]string(nil), rctx.RoutePatterns...)
+		copied.URLParams.Keys = append([]string(nil), rctx.URLParams.Keys...)
+		copied.URLParams.Values = append([]string(nil), rctx.URLParams.Values...)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, copied)
+	}
+	bg := r.Clone(ctx)
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+			if rec := recover(); rec != nil {
+				log.Printf("pagecache: refreshing %s panicked: %v", key, rec)
+			}
+		}()
+		c.capture(key, bg, next)
+	}()
+}
+
+func (c *Cache) put(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.gen != c.gen || e.cost() > c.maxBytes {
+		return
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.cost()
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.cost()
+}
+
+// write sends e to the client with its holes filled in for r.
+func (c *Cache) write(w http.ResponseWriter, r *http.Request, e *entry) {
+	for k, v := range e.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	body := e.body
+	if e.holes {
+		body = c.holeMarker.ReplaceAllFunc(body, func(m []byte) []byte {
+			parts := c.holeMarker.FindSubmatch(m)
+			arg, _ := hex.DecodeString(string(parts[2]))
+			if fill := c.holes[string(parts[1])]; fill != nil {
+				return []byte(fill(r, string(arg)))
+			}
+			return nil
+		})
+		w.Header().Del("Content-Length")
+	}
+	w.WriteHeader(e.status)
+	w.Write(body)
+}
+
+// cacheable accepts complete successful responses meant for everyone.
+func cacheable(e *entry) bool {
+	if e.status != http.StatusOK || e.header.Get("Set-Cookie") != "" {
+		return false
+	}
+	cc := e.header.Get("Cache-Control")
+	return !strings.Contains(cc, "no-store") && !strings.Contains(cc, "private")
+}
+
+// cacheKey is the path with the query parameters in a canonical order.
+func cacheKey(r *http.Request) string {
+	if r.URL.RawQuery == "" {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// recorder buffers a response so it can be stored before it is sent.
+type recorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header { return r.header }
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(b)
+}
@@ -0,0 +1,121 @@
+package pagecache
+
+import (
+	"strings"
+
+	"github.com/lukas-pastva/web-charon/internal/events"
+	"github.com/lukas-pastva/web-charon/internal/models"
+)
+
+var (
+	// articleLists are the pages listing published articles.
+	articleLists = []string{"/", "/articles", "/sitemap.xml", "/feed.xml", "/atom.xml", "/feed.json"}
+	// galleryLists are the pages listing galleries; the home page features
+	// the newest one.
+	galleryLists = []string{"/", "/gallery", "/sitemap.xml"}
+)
+
+// Subscribe drops the cached pages showing content that changed on bus. It
+// runs synchronously, so the redirect after an edit already shows the new
+// page. articles and galleries resolve the pages of linked content; when
+// that fails, or settings shared by all pages change, everything goes.
+func (c *Cache) Subscribe(bus *events.Bus, articles *models.ArticleStore, galleries *models.GalleryStore) {
+	res := resolver{articles: articles, galleries: galleries}
+	events.Subscribe(bus, "pagecache", events.Sync, func(e events.Event) error {
+		if !c.Enabled() {
+			return nil
+		}
+		paths, all := res.affected(e)
+		if all {
+			c.Invalidate()
+		} else if len(paths) > 0 {
+			c.InvalidatePaths(paths...)
+		}
+		return nil
+	})
+}
+
+// resolver works out which public pages show the content of an event.
+type resolver struct {
+	articles  *models.ArticleStore
+	galleries *models.GalleryStore
+}
+
+// affected returns the paths to drop for e, or all when every page may
+// have changed.
+func (res resolver) affected(e events.Event) (paths []string, all bool) {
+	switch e := e.(type) {
+	case events.ArticleSaved:
+		return append([]string{"/articles/" + e.Article.Slug}, articleLists...), false
+	case events.ArticleDeleted:
+		return append([]string{"/articles/" + e.Article.Slug}, articleLists...), false
+	case events.GallerySaved:
+		return res.gallery(e.Gallery, e.PreviousArticleID)
+	case events.GalleryDeleted:
+		return res.gallery(e.Gallery, nil)
+	case events.ImageUploaded:
+		return res.gallery(e.Gallery, nil)
+	case events.ImageChanged:
+		ids := []int64{e.Image.GalleryID}
+		// A moved photo also changes the gallery it was moved to.
+		if img, err := res.galleries.GetImageByID(e.Image.ID); err == nil && img.GalleryID != e.Image.GalleryID {
+			ids = append(ids, img.GalleryID)
+		}
+		for _, id := range ids {
+			g, err := res.galleries.GetByID(id)
+			if err != nil {
+				return nil, true
+			}
+			p, all := res.gallery(g, nil)
+			if all {
+				return nil, true
+			}
+			paths = append(paths, p...)
+		}
+		return paths, false
+	case events.CommentCreated:
+		// Comments awaiting moderation or caught as spam are not shown.
+		if e.Comment.Status != models.CommentApproved {
+			return nil, false
+		}
+		return commentPages([]string{e.Path}), false
+	case events.CommentsModerated:
+		if len(e.Paths) == 0 {
+			return nil, true
+		}
+		return commentPages(e.Paths), false
+	case events.UserChanged:
+		// Comments keep the name they were posted under.
+		return nil, false
+	}
+	return nil, true
+}
+
+// gallery returns the pages of g and its photos, the lists showing it and
+// the articles it is or was attached to, which show its photos.
+func (res resolver) gallery(g *models.Gallery, previousArticleID *int64) ([]string, bool) {
+	paths := append([]string{"/gallery/" + g.Slug, "/gallery/" + g.Slug + "/*"}, galleryLists...)
+	for _, id := range []*int64{g.ArticleID, previousArticleID} {
+		if id == nil {
+			continue
+		}
+		a, err := res.articles.GetByID(*id)
+		if err != nil {
+			return nil, true
+		}
+		paths = append(paths, "/articles/"+a.Slug)
+	}
+	return paths, false
+}
+
+// commentPages adds to the pages showing comments the galleries of photo
+// pages, which show how many comments each photo has.
+func commentPages(paths []string) []string {
+	out := append([]string(nil), paths...)
+	for _, p := range paths {
+		if gallery, _, ok := strings.Cut(p, "/photos/"); ok {
+			out = append(out, gallery)
+		}
+	}
+	return out
+}
@@ -5,46 +5,143 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/lukas-pastva/web-charon/internal/compress"
 	"github.com/lukas-pastva/web-charon/internal/handlers"
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/pagecache"
+	"github.com/lukas-pastva/web-charon/internal/security"
 )
 
-func New(pub *handlers.PublicHandler, admin *handlers.AdminHandler, auth *handlers.AuthHandler, storagePath string, staticFS http.FileSystem) http.Handler {
+// Policies are the security headers of the public site, the administration
+// and the JSON API.
+type Policies struct {
+	Public security.Policy
+	Admin  security.Policy
+	API    security.Policy
+}
+
+func New(pub *handlers.PublicHandler, admin *handlers.AdminHandler, auth *handlers.AuthHandler, api *handlers.APIHandler, adminAPI *handlers.AdminAPIHandler, uploads *handlers.UploadsHandler, errs *handlers.ErrorPages, locales *handlers.Locales, cache *pagecache.Cache, static http.Handler, policies Policies, trustProxy bool) http.Handler {
 	r := chi.NewRouter()
+	if trustProxy {
+		r.Use(middleware.RealIP)
+	}
+	r.Use(errs.RequestID)
 	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(errs.Recoverer)
+	r.Use(compress.Middleware)
+	r.Use(policies.Public.Middleware)
+	r.Use(locales.Middleware)
+
+	// Must be set before the admin sub-router is mounted so it inherits it.
+	r.NotFound(errs.NotFound)
+
+	// Public pages, served from the page cache
+	r.Group(func(r chi.Router) {
+		r.Use(cache.Middleware)
+
+		r.Get("/", pub.Home)
+		r.Get("/articles", pub.Articles_List)
+		r.Get("/articles/{slug}", pub.Article_Show)
+		r.Get("/gallery", pub.Gallery_List)
+		r.Get("/gallery/{slug}", pub.Gallery_Show)
+		r.Get("/gallery/{slug}/photos/{id}", pub.Photo_Show)
+		r.Get("/robots.txt", pub.Robots)
+		r.Get("/sitemap.xml", pub.Sitemap)
+		r.Get("/feed.xml", pub.Feed_RSS)
+		r.Get("/atom.xml", pub.Feed_Atom)
+		r.Get("/feed.json", pub.Feed_JSON)
+	})
 
-	// Public routes
-	r.Get("/", pub.Home)
-	r.Get("/articles", pub.Articles_List)
-	r.Get("/articles/{slug}", pub.Article_Show)
+	r.Get("/comments/token", pub.Comment_Token)
 	r.Post("/articles/{slug}/comments", pub.Comment_Submit)
-	r.Get("/gallery", pub.Gallery_List)
-	r.Get("/gallery/{slug}", pub.Gallery_Show)
-	r.Get("/robots.txt", pub.Robots)
-	r.Get("/sitemap.xml", pub.Sitemap)
+	r.Post("/gallery/{slug}/comments", pub.Gallery_CommentSubmit)
+	r.Get("/gallery/{slug}/download", pub.Gallery_Download)
+	r.Post("/gallery/{slug}/photos/{id}/comments", pub.Photo_CommentSubmit)
+
+	// Public read-only JSON API
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(policies.API.Middleware)
+		r.Use(api.CORS)
+		r.Use(middleware.GetHead)
+		r.NotFound(api.NotFound)
+		r.MethodNotAllowed(api.MethodNotAllowed)
+
+		r.Get("/articles", api.Articles_List)
+		r.Get("/articles/{slug}", api.Article_Show)
+		r.Get("/articles/{slug}/comments", api.Article_Comments)
+		r.Get("/galleries", api.Galleries_List)
+		r.Get("/galleries/{slug}", api.Gallery_Show)
+		r.Get("/galleries/{slug}/comments", api.Gallery_Comments)
+		r.Get("/galleries/{slug}/photos/{id}/comments", api.Photo_Comments)
+
+		// Admin API, authenticated by personal access tokens
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(adminAPI.RequireToken)
+
+			r.Group(func(r chi.Router) {
+				r.Use(adminAPI.RequireScope(models.ScopeArticles))
+				r.Get("/articles", adminAPI.Articles_List)
+				r.Post("/articles", adminAPI.Articles_Create)
+				r.Get("/articles/{id}", adminAPI.Article_Show)
+				r.Patch("/articles/{id}", adminAPI.Articles_Update)
+				r.Delete("/articles/{id}", adminAPI.Articles_Delete)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(adminAPI.RequireScope(models.ScopeGalleries))
+				r.Get("/galleries", adminAPI.Galleries_List)
+				r.Post("/galleries", adminAPI.Galleries_Create)
+				r.Get("/galleries/{id}", adminAPI.Gallery_Show)
+				r.Patch("/galleries/{id}", adminAPI.Galleries_Update)
+				r.Delete("/galleries/{id}", adminAPI.Galleries_Delete)
+				r.Post("/galleries/{id}/images", adminAPI.Galleries_UploadImages)
+				r.Patch("/images/{id}", adminAPI.Images_Update)
+				r.Delete("/images/{id}", adminAPI.Images_Delete)
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(adminAPI.RequireScope(models.ScopeComments))
+				r.Get("/comments", adminAPI.Comments_List)
+				r.Post("/comments/{id}/approve", adminAPI.Comments_Approve)
+				r.Post("/comments/{id}/spam", adminAPI.Comments_Spam)
+				r.Post("/comments/{id}/reply", adminAPI.Comments_Reply)
+				r.Delete("/comments/{id}", adminAPI.Comments_Delete)
+			})
+		})
+	})
 
 	// Static files
-	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(staticFS)))
+	r.Handle("/static/*", http.StripPrefix("/static", static))
 
 	// Uploaded files
-	r.Handle("/uploads/*", http.StripPrefix("/uploads/", http.FileServer(http.Dir(storagePath))))
+	r.Get("/uploads/*", uploads.Serve)
 
 	// Admin routes
 	r.Route("/admin", func(r chi.Router) {
+		r.Use(policies.Admin.Middleware)
+
 		// Unauthenticated routes
 		r.Get("/login", auth.Login)
 		r.Post("/login", auth.LoginPost)
 		r.Post("/logout", auth.Logout)
+		r.Get("/forgot", auth.Forgot)
+		r.Post("/forgot", auth.ForgotPost)
+		r.Get("/password/{token}", auth.SetPassword)
+		r.Post("/password/{token}", auth.SetPasswordPost)
 
 		// Authenticated routes
 		r.Group(func(r chi.Router) {
 			r.Use(auth.RequireAuth)
+			r.Use(locales.UserMiddleware)
 
 			r.Get("/", admin.Dashboard)
+			r.Post("/cache/purge", admin.Cache_Purge)
 
 			// Profile (any authenticated user)
 			r.Get("/profile", admin.Profile_Show)
 			r.Post("/profile", admin.Profile_Update)
+			r.Post("/profile/tokens", admin.Profile_CreateToken)
+			r.Post("/profile/tokens/{id}/delete", admin.Profile_DeleteToken)
 
 			r.Get("/articles", admin.Articles_List)
 			r.Get("/articles/new", admin.Articles_New)
@@ -60,17 +157,33 @@ func New(pub *handlers.PublicHandler, admin *handlers.AdminHandler, auth *handle
 			r.Post("/galleries/{id}", admin.Galleries_Update)
 			r.Post("/galleries/{id}/delete", admin.Galleries_Delete)
 			r.Post("/galleries/{id}/images", admin.Galleries_UploadImages)
+			r.Post("/galleries/{id}/import", admin.Galleries_ImportArchive)
+			r.Post("/galleries/{id}/reorder", admin.Galleries_Reorder)
+			r.Post("/galleries/{id}/cover", admin.Galleries_SetCover)
 
+			r.Post("/images/{id}", admin.Images_Update)
+			r.Post("/images/{id}/move", admin.Images_Move)
 			r.Post("/images/{id}/delete", admin.Images_Delete)
 
 			r.Get("/comments", admin.Comments_List)
+			r.Post("/comments/bulk", admin.Comments_Bulk)
+			r.Get("/comments/{id}/edit", admin.Comments_Edit)
+			r.Post("/comments/{id}", admin.Comments_Update)
 			r.Post("/comments/{id}/approve", admin.Comments_Approve)
+			r.Post("/comments/{id}/spam", admin.Comments_Spam)
+			r.Post("/comments/{id}/reply", admin.Comments_Reply)
+			r.Post("/comments/{id}/ban", admin.Comments_Ban)
+			r.Post("/comments/spam/delete", admin.Comments_DeleteSpam)
 			r.Post("/comments/{id}/delete", admin.Comments_Delete)
 
+			r.Get("/bans", admin.Bans_List)
+			r.Post("/bans", admin.Bans_Create)
+			r.Post("/bans/{id}/delete", admin.Bans_Delete)
+
 			r.Get("/settings", admin.Settings_Show)
 			r.Post("/settings", admin.Settings_Update)
 
-			// User management (admin-only)
+			// User, storage, mail and webhook management (admin-only)
 			r.Group(func(r chi.Router) {
 				r.Use(auth.RequireAdmin)
 
@@ -80,6 +193,22 @@ func New(pub *handlers.PublicHandler, admin *handlers.AdminHandler, auth *handle
 				r.Get("/users/{id}/edit", admin.Users_Edit)
 				r.Post("/users/{id}", admin.Users_Update)
 				r.Post("/users/{id}/delete", admin.Users_Delete)
+				r.Post("/users/{id}/invite", admin.Users_Invite)
+
+				r.Get("/storage", admin.Storage_Show)
+				r.Post("/storage/gc", admin.Storage_GC)
+
+				r.Get("/mail", admin.Mail_Show)
+				r.Post("/mail/{id}/retry", admin.Mail_Retry)
+
+				r.Get("/webhooks", admin.Webhooks_List)
+				r.Get("/webhooks/new", admin.Webhooks_New)
+				r.Post("/webhooks", admin.Webhooks_Create)
+				r.Get("/webhooks/{id}/edit", admin.Webhooks_Edit)
+				r.Post("/webhooks/{id}", admin.Webhooks_Update)
+				r.Post("/webhooks/{id}/delete", admin.Webhooks_Delete)
+				r.Post("/webhooks/{id}/test", admin.Webhooks_Test)
+				r.Post("/webhooks/deliveries/{id}/retry", admin.Webhooks_RetryDelivery)
 			})
 		})
 	})
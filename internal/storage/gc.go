@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultGracePeriod protects freshly written files whose database row may not
+// exist yet (an upload is stored before the image record is inserted).
+const DefaultGracePeriod = 24 * time.Hour
+
+// Orphan is a stored file that no database record refers to.
+type Orphan struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// GCReport lists unreferenced files. Orphans are old enough to be removed;
+// Recent ones are still inside the grace period and are left alone.
+type GCReport struct {
+	Orphans     []Orphan
+	Recent      []Orphan
+	OrphanBytes int64
+	GracePeriod time.Duration
+}
+
+// FindOrphans lists the storage and reports every object whose key is not
+// in referenced.
+func FindOrphans(store Storage, referenced map[string]bool, grace time.Duration) (*GCReport, error) {
+	objects, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GCReport{GracePeriod: grace}
+	cutoff := time.Now().Add(-grace)
+	for _, obj := range objects {
+		if referenced[obj.Key] {
+			continue
+		}
+		o := Orphan{Name: obj.Key, Size: obj.Size, ModTime: obj.ModTime}
+		if o.ModTime.After(cutoff) {
+			report.Recent = append(report.Recent, o)
+			continue
+		}
+		report.Orphans = append(report.Orphans, o)
+		report.OrphanBytes += o.Size
+	}
+
+	sort.Slice(report.Orphans, func(i, j int) bool { return report.Orphans[i].ModTime.Before(report.Orphans[j].ModTime) })
+	sort.Slice(report.Recent, func(i, j int) bool { return report.Recent[i].ModTime.Before(report.Recent[j].ModTime) })
+	return report, nil
+}
+
+// RemoveOrphans deletes the orphans listed in the report and returns the
+// names of the removed files. It keeps going after individual failures and
+// returns the first error encountered.
+func RemoveOrphans(store Storage, report *GCReport) ([]string, error) {
+	var firstErr error
+	var removed []string
+	for _, o := range report.Orphans {
+		if err := store.Delete(o.Name); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		removed = append(removed, o.Name)
+	}
+	return removed, firstErr
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned when a requested object is not stored.
+var ErrNotExist = errors.New("storage: object does not exist")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Storage is a flat key/value store for uploaded files. Keys are plain file
+// names such as "1700000000000.jpg"; they never contain path separators.
+type Storage interface {
+	// Put stores the content of r under key, replacing any existing object.
+	// size is the content length in bytes.
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get opens an object for reading. The caller must close the reader.
+	Get(key string) (io.ReadCloser, *ObjectInfo, error)
+	Delete(key string) error
+	Stat(key string) (*ObjectInfo, error)
+	List() ([]ObjectInfo, error)
+	// URL returns the address under which browsers can fetch the object.
+	URL(key string) string
+}
+
+// Ranger is implemented by backends whose readers cannot seek but which can
+// read part of an object, so large files can be served in ranges.
+type Ranger interface {
+	// GetRange opens length bytes of an object starting at offset.
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// AbsoluteURL is s.URL(key) for links that leave the site, such as API
+// responses, feeds and webhooks. Addresses served by the application are
+// resolved against siteURL.
+func AbsoluteURL(s Storage, siteURL, key string) string {
+	u := s.URL(key)
+	if strings.HasPrefix(u, "/") {
+		return siteURL + u
+	}
+	return u
+}
+
+// ValidKey reports whether key is a usable object name.
+func ValidKey(key string) bool {
+	if key == "" || key == "." || key == ".." {
+		return false
+	}
+	for _, c := range key {
+		if c == '/' || c == '\\' || c < ' ' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+)
+
+// retryDelays is how long to wait after each failed attempt. A delivery is
+// given up on once every delay has been used.
+var retryDelays = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	6 * time.Hour,
+}
+
+const (
+	pollInterval   = 30 * time.Second
+	batchSize      = 20
+	requestTimeout = 10 * time.Second
+	// logRetention is how long finished deliveries are kept in the log.
+	logRetention = 30 * 24 * time.Hour
+	// maxResponseBody is how much of the receiver's answer is kept.
+	maxResponseBody = 1000
+)
+
+// Dispatcher queues events for the webhooks subscribed to them and delivers
+// them in the background, the same way Mailer delivers the outbox.
+type Dispatcher struct {
+	Hooks      *models.WebhookStore
+	Deliveries *models.WebhookDeliveryStore
+	Client     *http.Client
+	Storage    storage.Storage // addresses of uploads in payloads
+	BaseURL    string
+	// wake lets Trigger start delivery without waiting for the next poll.
+	wake chan struct{}
+}
+
+func NewDispatcher(hooks *models.WebhookStore, deliveries *models.WebhookDeliveryStore, store storage.Storage, baseURL string) *Dispatcher {
+	return &Dispatcher{
+		Hooks:      hooks,
+		Deliveries: deliveries,
+		Client:     &http.Client{Timeout: requestTimeout},
+		Storage:    store,
+		BaseURL:    baseURL,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Payload is the JSON body of every delivery.
+type Payload struct {
+	ID        string      `json:"id"` // same for all webhooks receiving the event
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Site      string      `json:"site"`
+	Data      interface{} `json:"data"`
+}
+
+// Trigger queues event for every active webhook subscribed to it. Errors
+// are logged: a failing webhook must not break the action that caused it.
+func (d *Dispatcher) Trigger(event string, data interface{}) {
+	hooks, err := d.Hooks.GetSubscribed(event)
+	if err != nil {
+		log.Printf("webhook: loading webhooks for %s failed: %v", event, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := d.payload(event, data)
+	if err != nil {
+		log.Printf("webhook: encoding %s failed: %v", event, err)
+		return
+	}
+	for _, hook := range hooks {
+		if err := d.Deliveries.Enqueue(&models.WebhookDelivery{WebhookID: hook.ID, Event: event, Payload: body}); err != nil {
+			log.Printf("webhook: queueing %s for webhook %d failed: %v", event, hook.ID, err)
+		}
+	}
+	d.Wake()
+}
+
+// SendTest queues a ping event with message for hook, whether or not it
+// is active.
+func (d *Dispatcher) SendTest(hook *models.Webhook, message string) error {
+	body, err := d.payload(models.EventPing, map[string]string{
+		"message": message,
+		"webhook": hook.Name,
+	})
+	if err != nil {
+		return err
+	}
+	if err := d.Deliveries.Enqueue(&models.WebhookDelivery{WebhookID: hook.ID, Event: models.EventPing, Payload: body}); err != nil {
+		return err
+	}
+	d.Wake()
+	return nil
+}
+
+// Wake starts a delivery run without waiting for the next poll.
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due events until the process exits.
+func (d *Dispatcher) Run() {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastCleanup := time.Time{}
+	for {
+		d.deliverDue()
+		if time.Since(lastCleanup) > time.Hour {
+			if n, err := d.Deliveries.DeleteBefore(time.Now().Add(-logRetention)); err != nil {
+				log.Printf("webhook: cleanup failed: %v", err)
+			} else if n > 0 {
+				log.Printf("webhook: removed %d old deliveries", n)
+			}
+			lastCleanup = time.Now()
+		}
+		select {
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+func (d *Dispatcher) deliverDue() {
+	for {
+		due, err := d.Deliveries.Due(batchSize)
+		if err != nil {
+			log.Printf("webhook: loading deliveries failed: %v", err)
+			return
+		}
+		hooks := map[int64]*models.Webhook{}
+		for _, dl := range due {
+			hook, ok := hooks[dl.WebhookID]
+			if !ok {
+				if hook, err = d.Hooks.GetByID(dl.WebhookID); err != nil {
+					log.Printf("webhook: loading webhook %d failed: %v", dl.WebhookID, err)
+					continue
+				}
+				hooks[dl.WebhookID] = hook
+			}
+			d.deliver(dl, hook.Secret, hook.URL)
+		}
+		if len(due) < batchSize {
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(dl models.WebhookDelivery, secret, url string) {
+	code, body, err := d.post(dl, secret, url)
+	if err == nil {
+		if err := d.Deliveries.MarkDelivered(dl.ID, code, body); err != nil {
+			log.Printf("webhook: marking delivery %d as delivered failed: %v", dl.ID, err)
+		}
+		return
+	}
+
+	final := dl.Attempts >= len(retryDelays)
+	var delay time.Duration
+	if final {
+		log.Printf("webhook: giving up on delivery %d (%s) to %s after %d attempts: %v", dl.ID, dl.Event, dl.WebhookName, dl.Attempts+1, err)
+	} else {
+		delay = retryDelays[dl.Attempts]
+		log.Printf("webhook: delivery %d (%s) to %s failed, retrying in %s: %v", dl.ID, dl.Event, dl.WebhookName, delay, err)
+	}
+	if err := d.Deliveries.MarkAttemptFailed(dl.ID, code, body, err.Error(), delay, final); err != nil {
+		log.Printf("webhook: recording failure of delivery %d failed: %v", dl.ID, err)
+	}
+}
+
+// post sends one delivery. Any 2xx answer counts as delivered; code is 0
+// when the receiver could not be reached.
+func (d *Dispatcher) post(dl models.WebhookDelivery, secret, url string) (code int, body string, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader([]byte(dl.Payload)))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Charon-Webhook/1.0")
+	req.Header.Set("X-Charon-Event", dl.Event)
+	req.Header.Set("X-Charon-Delivery", strconv.FormatInt(dl.ID, 10))
+	req.Header.Set("X-Charon-Signature", Sign(secret, []byte(dl.Payload)))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(b), fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, string(b), nil
+}
+
+func (d *Dispatcher) payload(event string, data interface{}) (string, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	b, err := json.Marshal(Payload{
+		ID:        hex.EncodeToString(id),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Site:      d.BaseURL,
+		Data:      data,
+	})
+	return string(b), err
+}
+
+// Sign returns the X-Charon-Signature header for body: the hex HMAC-SHA256
+// of the exact request body keyed with the webhook's secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a signing secret for a new webhook.
+func NewSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
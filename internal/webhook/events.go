@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/lukas-pastva/web-charon/internal/events"
+	"github.com/lukas-pastva/web-charon/internal/models"
+	"github.com/lukas-pastva/web-charon/internal/storage"
+)
+
+// Article is the data of article.* events.
+type Article struct {
+	ID          int64      `json:"id"`
+	Title       string     `json:"title"`
+	Slug        string     `json:"slug"`
+	URL         string     `json:"url"`
+	Excerpt     string     `json:"excerpt"`
+	CoverImage  *string    `json:"cover_image"`
+	PublishedAt *time.Time `json:"published_at"`
+}
+
+// Gallery is the data of gallery.* events.
+type Gallery struct {
+	ID          int64  `json:"id"`
+	Title       string `json:"title"`
+	Slug        string `json:"slug"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+	ImageCount  int    `json:"image_count"`
+}
+
+// Comment is the data of comment.* events. ModerationURL leads to the
+// comment in the administration.
+type Comment struct {
+	ID            int64         `json:"id"`
+	Target        CommentTarget `json:"target"`
+	ParentID      *int64        `json:"parent_id"`
+	AuthorName    string        `json:"author_name"`
+	Content       string        `json:"content"`
+	ModerationURL string        `json:"moderation_url"`
+}
+
+type CommentTarget struct {
+	Kind  string `json:"kind"` // article, gallery or image
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// Subscribe sends the webhook events for the content events on bus. One
+// async subscriber handles them all, so webhooks see them in order.
+func (d *Dispatcher) Subscribe(bus *events.Bus) {
+	events.Subscribe(bus, "webhooks", events.Async, func(e events.Event) error {
+		switch e := e.(type) {
+		case events.ArticleSaved:
+			if e.FirstPublished {
+				d.Trigger(models.EventArticlePublished, d.article(e.Article))
+			}
+		case events.ArticleDeleted:
+			// Drafts were never announced.
+			if e.Article.PublishedAt != nil {
+				d.Trigger(models.EventArticleDeleted, d.article(e.Article))
+			}
+		case events.GallerySaved:
+			if e.Created {
+				d.Trigger(models.EventGalleryCreated, d.gallery(e.Gallery))
+			}
+		case events.GalleryDeleted:
+			d.Trigger(models.EventGalleryDeleted, d.gallery(e.Gallery))
+		case events.CommentCreated:
+			if e.Comment.Status == models.CommentPending {
+				d.Trigger(models.EventCommentPending, d.comment(e.Comment, e.Title, e.Path))
+			}
+		}
+		return nil
+	})
+}
+
+func (d *Dispatcher) comment(c *models.Comment, title, path string) Comment {
+	t := c.Target()
+	return Comment{
+		ID:            c.ID,
+		Target:        CommentTarget{Kind: t.Kind, ID: t.ID, Title: title, URL: d.BaseURL + path},
+		ParentID:      c.ParentID,
+		AuthorName:    c.AuthorName,
+		Content:       c.Content,
+		ModerationURL: d.BaseURL + "/admin/comments/" + strconv.FormatInt(c.ID, 10) + "/edit",
+	}
+}
+
+func (d *Dispatcher) article(a *models.Article) Article {
+	out := Article{
+		ID:          a.ID,
+		Title:       a.Title,
+		Slug:        a.Slug,
+		URL:         d.BaseURL + "/articles/" + a.Slug,
+		Excerpt:     a.Excerpt,
+		PublishedAt: a.PublishedAt,
+	}
+	if a.CoverImage != "" {
+		u := storage.AbsoluteURL(d.Storage, d.BaseURL, a.CoverImage)
+		out.CoverImage = &u
+	}
+	return out
+}
+
+func (d *Dispatcher) gallery(g *models.Gallery) Gallery {
+	return Gallery{
+		ID:          g.ID,
+		Title:       g.Title,
+		Slug:        g.Slug,
+		URL:         d.BaseURL + "/gallery/" + g.Slug,
+		Description: g.Description,
+		ImageCount:  len(g.Images),
+	}
+}
@@ -0,0 +1,5 @@
+ALTER TABLE images ADD COLUMN alt_text VARCHAR(500) NOT NULL DEFAULT '';
+
+ALTER TABLE galleries ADD COLUMN cover_image_id BIGINT NULL;
+
+ALTER TABLE galleries ADD CONSTRAINT fk_galleries_cover_image FOREIGN KEY (cover_image_id) REFERENCES images(id) ON DELETE SET NULL;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS media (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    hash CHAR(64) NOT NULL UNIQUE,
+    filename VARCHAR(255) NOT NULL UNIQUE,
+    content_type VARCHAR(100) NOT NULL DEFAULT '',
+    size BIGINT NOT NULL DEFAULT 0,
+    ref_count INT NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE INDEX idx_images_filename ON images (filename);
@@ -0,0 +1,9 @@
+ALTER TABLE comments ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'pending';
+
+UPDATE comments SET status = 'approved' WHERE approved = TRUE;
+
+ALTER TABLE comments DROP COLUMN approved;
+
+ALTER TABLE comments ADD COLUMN spam_reason VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_comments_status ON comments (status, created_at);
@@ -0,0 +1,9 @@
+ALTER TABLE comments ADD COLUMN parent_id BIGINT NULL;
+
+ALTER TABLE comments ADD COLUMN depth INT NOT NULL DEFAULT 0;
+
+ALTER TABLE comments ADD COLUMN user_id BIGINT NULL;
+
+ALTER TABLE comments ADD CONSTRAINT fk_comments_parent FOREIGN KEY (parent_id) REFERENCES comments(id) ON DELETE CASCADE;
+
+ALTER TABLE comments ADD CONSTRAINT fk_comments_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,7 @@
+ALTER TABLE articles ADD COLUMN comments_closed BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE articles ADD COLUMN published_at DATETIME NULL;
+
+UPDATE articles SET published_at = created_at, updated_at = updated_at WHERE published = TRUE AND published_at IS NULL;
+
+INSERT IGNORE INTO settings (setting_key, setting_value) VALUES ('comments_auto_close_days', '0');
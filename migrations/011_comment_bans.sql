@@ -0,0 +1,16 @@
+ALTER TABLE comments ADD COLUMN ip_hash VARCHAR(64) NOT NULL DEFAULT '';
+
+ALTER TABLE comments ADD COLUMN ip_network VARCHAR(64) NOT NULL DEFAULT '';
+
+ALTER TABLE comments ADD COLUMN user_agent VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_comments_ip_hash ON comments (ip_hash);
+
+CREATE TABLE IF NOT EXISTS comment_bans (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(16) NOT NULL,
+    pattern VARCHAR(255) NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_comment_bans (kind, pattern)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
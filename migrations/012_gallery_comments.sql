@@ -0,0 +1,13 @@
+ALTER TABLE comments MODIFY article_id BIGINT NULL;
+
+ALTER TABLE comments ADD COLUMN gallery_id BIGINT NULL AFTER article_id;
+
+ALTER TABLE comments ADD COLUMN image_id BIGINT NULL AFTER gallery_id;
+
+ALTER TABLE comments ADD CONSTRAINT fk_comments_gallery FOREIGN KEY (gallery_id) REFERENCES galleries(id) ON DELETE CASCADE;
+
+ALTER TABLE comments ADD CONSTRAINT fk_comments_image FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE;
+
+ALTER TABLE galleries ADD COLUMN comments_enabled BOOLEAN NOT NULL DEFAULT TRUE;
+
+ALTER TABLE galleries ADD COLUMN comments_closed BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+INSERT IGNORE INTO settings (setting_key, setting_value) VALUES ('feed_content', 'full');
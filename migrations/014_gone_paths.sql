@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS gone_paths (
+    path VARCHAR(255) PRIMARY KEY,
+    deleted_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1 @@
+CREATE INDEX idx_articles_cover_image ON articles (cover_image);
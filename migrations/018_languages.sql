@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN language VARCHAR(8) NOT NULL DEFAULT '';
+
+INSERT IGNORE INTO settings (setting_key, setting_value) VALUES ('site_language', 'sk');
@@ -0,0 +1,90 @@
+// Web Charon - administration
+
+document.addEventListener('click', function (e) {
+    var confirmed = e.target.closest('[data-confirm]');
+    if (confirmed && !confirm(confirmed.getAttribute('data-confirm'))) {
+        e.preventDefault();
+    }
+
+    var menuBtn = e.target.closest('.admin-menu-btn');
+    if (menuBtn) {
+        document.getElementById(menuBtn.getAttribute('aria-controls')).classList.toggle('open');
+    }
+
+    var selectable = e.target.closest('[data-select-on-click]');
+    if (selectable) {
+        selectable.select();
+    }
+});
+
+document.addEventListener('DOMContentLoaded', function () {
+    initBulkSelect();
+    initImageReorder();
+});
+
+// The desktop table and the mobile cards list the same comments; keep both
+// copies of each checkbox in sync.
+function initBulkSelect() {
+    var all = document.getElementById('bulkAll');
+    if (!all) return;
+
+    var items = document.querySelectorAll('.bulk-item');
+    all.addEventListener('change', function () {
+        var checked = this.checked;
+        items.forEach(function (box) { box.checked = checked; });
+    });
+    items.forEach(function (box) {
+        box.addEventListener('change', function () {
+            items.forEach(function (other) {
+                if (other.value === box.value) { other.checked = box.checked; }
+            });
+        });
+    });
+}
+
+// Gallery images are reordered by dragging; the new order is saved at once.
+function initImageReorder() {
+    var editor = document.getElementById('imageEditor');
+    var status = document.getElementById('reorderStatus');
+    if (!editor || !status) return;
+
+    var dragged = null;
+
+    function saveOrder() {
+        var ids = Array.prototype.map.call(editor.querySelectorAll('.image-editor-item'), function (el) { return el.getAttribute('data-id'); });
+        var body = new URLSearchParams();
+        body.set('order', ids.join(','));
+        status.textContent = status.dataset.saving;
+        fetch(editor.getAttribute('data-reorder-url'), {
+            method: 'POST',
+            headers: { 'X-Requested-With': 'fetch' },
+            body: body
+        }).then(function (res) {
+            status.textContent = res.ok ? status.dataset.saved : status.dataset.failed;
+        }).catch(function () {
+            status.textContent = status.dataset.failed;
+        });
+    }
+
+    editor.addEventListener('dragstart', function (e) {
+        dragged = e.target.closest('.image-editor-item');
+        if (!dragged) return;
+        dragged.classList.add('dragging');
+        e.dataTransfer.effectAllowed = 'move';
+    });
+    editor.addEventListener('dragover', function (e) {
+        var target = e.target.closest('.image-editor-item');
+        if (!dragged || !target || target === dragged) return;
+        e.preventDefault();
+        var rect = target.getBoundingClientRect();
+        var after = (e.clientY - rect.top) > rect.height / 2 || (e.clientX - rect.left) > rect.width / 2;
+        editor.insertBefore(dragged, after ? target.nextSibling : target);
+    });
+    editor.addEventListener('drop', function (e) { e.preventDefault(); });
+    editor.addEventListener('dragend', function () {
+        if (!dragged) return;
+        dragged.classList.remove('dragging');
+        dragged = null;
+        saveOrder();
+    });
+}
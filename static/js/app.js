@@ -12,8 +12,30 @@ document.addEventListener('DOMContentLoaded', function () {
 
     // Gallery lightbox
     initLightbox();
+
+    initCommentTokens();
 });
 
+// A page answered with 304 Not Modified keeps the comment token of its first
+// render, which may have been used already. Each comment form therefore asks
+// for a fresh one when the visitor starts writing; the token in the page
+// stays as the fallback.
+function initCommentTokens() {
+    const section = document.querySelector('.comments-section[data-comment-target]');
+    if (!section) return;
+    const url = '/comments/token?target=' + encodeURIComponent(section.dataset.commentTarget);
+    section.querySelectorAll('form').forEach(function (form) {
+        form.addEventListener('focusin', function () {
+            fetch(url, { cache: 'no-store' })
+                .then(function (res) { return res.ok ? res.text() : ''; })
+                .then(function (token) {
+                    if (token) form.elements.comment_token.value = token;
+                })
+                .catch(function () {});
+        }, { once: true });
+    });
+}
+
 function initLightbox() {
     const images = document.querySelectorAll('.gallery-images img');
     if (images.length === 0) return;
@@ -22,16 +44,24 @@ function initLightbox() {
     const lightbox = document.createElement('div');
     lightbox.className = 'lightbox';
     lightbox.innerHTML = `
-        <button class="lightbox-close" aria-label="Zavrieť">&times;</button>
-        <button class="lightbox-nav lightbox-prev" aria-label="Predchádzajúci">&lsaquo;</button>
-        <button class="lightbox-nav lightbox-next" aria-label="Ďalší">&rsaquo;</button>
+        <button class="lightbox-close">&times;</button>
+        <button class="lightbox-nav lightbox-prev">&lsaquo;</button>
+        <button class="lightbox-nav lightbox-next">&rsaquo;</button>
         <img src="" alt="">
         <div class="lightbox-caption"></div>
+        <a class="lightbox-link" href=""></a>
     `;
+    // Labels come translated from the page, see base.html.
+    const labels = document.body.dataset;
+    lightbox.querySelector('.lightbox-close').setAttribute('aria-label', labels.lightboxClose);
+    lightbox.querySelector('.lightbox-prev').setAttribute('aria-label', labels.lightboxPrev);
+    lightbox.querySelector('.lightbox-next').setAttribute('aria-label', labels.lightboxNext);
+    lightbox.querySelector('.lightbox-link').textContent = labels.lightboxComments;
     document.body.appendChild(lightbox);
 
     const lbImg = lightbox.querySelector('img');
     const lbCaption = lightbox.querySelector('.lightbox-caption');
+    const lbLink = lightbox.querySelector('.lightbox-link');
     const btnClose = lightbox.querySelector('.lightbox-close');
     const btnPrev = lightbox.querySelector('.lightbox-prev');
     const btnNext = lightbox.querySelector('.lightbox-next');
@@ -44,6 +74,10 @@ function initLightbox() {
         currentIndex = index;
         lbImg.src = images[index].src;
         lbCaption.textContent = images[index].getAttribute('data-caption') || '';
+        // Each photo has its own page with comments
+        const page = images[index].getAttribute('data-page');
+        lbLink.hidden = !page;
+        lbLink.href = page ? page + '#comments' : '';
     }
 
     function openLightbox(index) {